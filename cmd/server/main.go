@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"log"
+	"os"
 	//"github.com/BlaccStacc/blaccend/internal/mail"
 	"github.com/BlaccStacc/blaccend/internal/api"
+	"github.com/BlaccStacc/blaccend/internal/audit"
 	"github.com/BlaccStacc/blaccend/internal/config"
 	"github.com/BlaccStacc/blaccend/internal/db"
+	"github.com/BlaccStacc/blaccend/internal/services"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -20,11 +26,51 @@ func main() {
 	defer database.Close() // defer schedules a func to run after the surrounding func returns, no matter how
 	//basically defer runs last
 
+	// init.sql only runs on a fresh docker volume; this upgrades existing ones
+	if err := db.Migrate(context.Background(), database); err != nil {
+		log.Fatalf("db migrate failed: %v", err)
+	}
+
+	// `server audit-verify` checks the audit hash chain and exits 1 if it is broken
+	if len(os.Args) > 1 && os.Args[1] == "audit-verify" {
+		os.Exit(verifyAudit(database, cfg))
+	}
+
 	// create fiber app ce pula mea e fiber- web framework pt go gen un fel de node.js lolz
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		BodyLimit: cfg.BodyLimitMB * 1024 * 1024,
+		// JSON errors everywhere, see api.ErrorHandler
+		ErrorHandler: api.ErrorHandler,
+	})
 
-	api.RegisterRoutes(app, database)
+	api.RegisterRoutes(app, database, cfg)
+
+	// background jobs (digests, reminders, big imports)
+	services.Start(context.Background(), database, cfg, func(r *services.Runner) {
+		api.RegisterJobs(r, database)
+	})
 
 	log.Printf("server running pe port %s", cfg.Port)
 	log.Fatal(app.Listen(":" + cfg.Port))
 }
+
+func verifyAudit(database *sql.DB, cfg *config.Config) int {
+	key, err := audit.LoadSigningKey(cfg)
+	if err != nil {
+		log.Printf("audit-verify: %v", err)
+		return 2
+	}
+	rep, err := audit.Verify(context.Background(), database, key)
+	if err != nil {
+		log.Printf("audit-verify: %v", err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(rep)
+	if !rep.OK {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,319 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/audit"
+	"github.com/gofiber/fiber/v2"
+)
+
+// auditActor is who a change gets attributed to. Background work (import
+// jobs) only knows the user who started it.
+type auditActor struct {
+	userID    *int64
+	ip        string
+	userAgent string
+}
+
+func requestActor(c *fiber.Ctx) auditActor {
+	a := auditActor{ip: c.IP(), userAgent: c.Get(fiber.HeaderUserAgent)}
+	if uid, ok := currentUserID(c); ok {
+		a.userID = &uid
+	}
+	return a
+}
+
+// fields that change on every write and would only add noise to diffs
+var auditIgnoredFields = []string{"version", "updated_at"}
+
+// record logs a change to entityType/entityID inside tx, so the entry commits
+// or rolls back with the change. before is nil for creates and after is nil
+// for deletes; otherwise only the fields that differ are kept.
+func (a auditActor) record(tx *sql.Tx, action, entityType string, entityID int64, before, after any) error {
+	b, af, err := audit.Diff(before, after, auditIgnoredFields...)
+	if err != nil {
+		return err
+	}
+	return audit.Record(tx, audit.Entry{
+		ActorID:    a.userID,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   &entityID,
+		IP:         a.ip,
+		UserAgent:  a.userAgent,
+		Before:     b,
+		After:      af,
+	})
+}
+
+// recordEvent logs something that isn't about a single record (a restore,
+// an import...), with details as the after side.
+func (a auditActor) recordEvent(tx *sql.Tx, action string, details any) error {
+	return audit.Record(tx, audit.Entry{
+		ActorID:   a.userID,
+		Action:    action,
+		IP:        a.ip,
+		UserAgent: a.userAgent,
+		After:     details,
+	})
+}
+
+// recordAuthEvent logs an authentication event through ex (the db, or the
+// transaction making an account change). userID is nil when the account is
+// unknown, e.g. a failed login for an unregistered email. A failed write is
+// only logged: it must not lock people out. Inside a transaction the write
+// runs in a savepoint, so a failure doesn't abort the account change.
+func recordAuthEvent(ex audit.Execer, c *fiber.Ctx, action string, userID *int64, details fiber.Map) {
+	e := audit.Entry{
+		ActorID:   userID,
+		Action:    action,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+	if userID != nil {
+		e.EntityType, e.EntityID = "user", userID
+	}
+	if len(details) > 0 {
+		e.After = details
+	}
+	tx, inTx := ex.(*sql.Tx)
+	if inTx {
+		if _, err := tx.Exec(`SAVEPOINT auth_event`); err != nil {
+			log.Printf("audit %s: %v", action, err)
+			return
+		}
+	}
+	if err := audit.Record(ex, e); err != nil {
+		log.Printf("audit %s: %v", action, err)
+		if inTx {
+			tx.Exec(`ROLLBACK TO SAVEPOINT auth_event`)
+		}
+	}
+	if inTx {
+		tx.Exec(`RELEASE SAVEPOINT auth_event`)
+	}
+}
+
+type AuditEntry struct {
+	ID            int64   `json:"id"`
+	ActorID       *int64  `json:"actor_id,omitempty"`
+	ActorUsername *string `json:"actor_username,omitempty"`
+	Action        string  `json:"action"`
+	EntityType    *string `json:"entity_type,omitempty"`
+	EntityID      *int64  `json:"entity_id,omitempty"`
+	IP            *string `json:"ip,omitempty"`
+	UserAgent     *string `json:"user_agent,omitempty"`
+	Before        rawJSON `json:"before,omitempty"`
+	After         rawJSON `json:"after,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+}
+
+// rawJSON passes a JSONB column through untouched; empty for NULL.
+type rawJSON []byte
+
+func (r rawJSON) MarshalJSON() ([]byte, error) {
+	if len(r) == 0 {
+		return []byte("null"), nil
+	}
+	return r, nil
+}
+
+const auditColumns = `
+	a.id, a.actor_id, u.username, a.action, a.entity_type, a.entity_id, a.ip, a.user_agent,
+	a.before, a.after, a.created_at`
+
+const auditFrom = `
+	FROM audit_log a
+	LEFT JOIN users u ON u.id = a.actor_id`
+
+var auditSorts = map[string]sortColumn{
+	"id":         {"a.id", "bigint"},
+	"created_at": {"a.created_at", "timestamptz"},
+}
+
+func scanAuditEntry(row rowScanner) (AuditEntry, error) {
+	var e AuditEntry
+	var before, after []byte
+	var created time.Time
+	err := row.Scan(&e.ID, &e.ActorID, &e.ActorUsername, &e.Action, &e.EntityType, &e.EntityID, &e.IP,
+		&e.UserAgent, &before, &after, &created)
+	if err != nil {
+		return e, err
+	}
+	e.Before, e.After = before, after
+	e.CreatedAt = created.UTC().Format(time.RFC3339)
+	return e, nil
+}
+
+// queryAuditLog runs a filtered, keyset-paginated audit query and writes
+// {"entries": [...], "next_cursor": ...}.
+func queryAuditLog(c *fiber.Ctx, db *sql.DB, conds []string, args []any, redact bool) error {
+	page, err := parseListPage(c, auditSorts, "a.id")
+	if err != nil {
+		return err
+	}
+	if cond, a := page.where(args); cond != "" {
+		conds, args = append(conds, cond), a
+	}
+
+	q := `SELECT ` + auditColumns + page.sortKeyColumn() + auditFrom
+	if len(conds) > 0 {
+		q += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	q += page.orderLimit()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	var n int
+	var lastKey string
+	for rows.Next() {
+		var key string
+		e, err := scanAuditEntry(keyedRow{rows, &key})
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if n++; n > page.limit {
+			break
+		}
+		if redact {
+			e.IP, e.UserAgent = nil, nil
+		}
+		entries = append(entries, e)
+		lastKey = key
+	}
+
+	var next *string
+	if len(entries) > 0 {
+		next = page.next(n, lastKey, entries[len(entries)-1].ID)
+	}
+	return c.JSON(fiber.Map{"entries": entries, "next_cursor": next})
+}
+
+// GET /audit?actor_id=&action=&entity_type=&entity_id=&since=&until=&sort=-id&limit=&cursor=
+// Owners only. action ending in "." matches a prefix ("item." for all item changes).
+func listAuditLog(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can read the audit log"); err != nil {
+			return err
+		}
+
+		var conds []string
+		var args []any
+		addInt := func(param, column string) error {
+			v := c.Query(param)
+			if v == "" {
+				return nil
+			}
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid "+param)
+			}
+			args = append(args, n)
+			conds = append(conds, fmt.Sprintf("%s = $%d", column, len(args)))
+			return nil
+		}
+		addTime := func(param, cond string) error {
+			v := c.Query(param)
+			if v == "" {
+				return nil
+			}
+			t, err := parseFlexibleTime(v)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, param+" must be RFC3339 or YYYY-MM-DD")
+			}
+			args = append(args, t)
+			conds = append(conds, fmt.Sprintf(cond, len(args)))
+			return nil
+		}
+
+		if err := addInt("actor_id", "a.actor_id"); err != nil {
+			return err
+		}
+		if err := addInt("entity_id", "a.entity_id"); err != nil {
+			return err
+		}
+		if err := addTime("since", "a.created_at >= $%d"); err != nil {
+			return err
+		}
+		if err := addTime("until", "a.created_at < $%d"); err != nil {
+			return err
+		}
+		if v := c.Query("entity_type"); v != "" {
+			args = append(args, v)
+			conds = append(conds, fmt.Sprintf("a.entity_type = $%d", len(args)))
+		}
+		if v := c.Query("action"); v != "" {
+			if strings.HasSuffix(v, ".") {
+				args = append(args, escapeLike(v)+"%")
+				conds = append(conds, fmt.Sprintf("a.action LIKE $%d", len(args)))
+			} else {
+				args = append(args, v)
+				conds = append(conds, fmt.Sprintf("a.action = $%d", len(args)))
+			}
+		}
+
+		return queryAuditLog(c, db, conds, args, false)
+	}
+}
+
+// GET /audit/verify
+// Owners only. Recomputes the whole hash chain and checks the signed
+// checkpoints; first_broken names the first entry that doesn't hold.
+// The same check runs from the command line with `server audit-verify`.
+// key is nil when SIGNING_KEY is unusable.
+func verifyAuditLog(db *sql.DB, key *audit.SigningKey) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can verify the audit log"); err != nil {
+			return err
+		}
+
+		if key == nil {
+			return fiber.NewError(fiber.StatusServiceUnavailable, "audit signing key is misconfigured")
+		}
+		rep, err := audit.Verify(c.Context(), db, *key)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(rep)
+	}
+}
+
+// GET /garage/items/:id/history?sort=-id&limit=&cursor=
+// Every change to the item, trashed or not. The garage is shared, so anyone
+// who can edit the item can read its history; IP and user agent are only
+// shown to owners.
+func listItemHistory(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM garage_items WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if !exists {
+			return fiber.NewError(fiber.StatusNotFound, "item not found")
+		}
+
+		owner := false
+		if uid, ok := currentUserID(c); ok {
+			if owner, err = isGarageOwner(db, uid); err != nil {
+				return fiber.ErrInternalServerError
+			}
+		}
+
+		return queryAuditLog(c, db, []string{"a.entity_type = 'item'", "a.entity_id = $1"}, []any{id}, !owner)
+	}
+}
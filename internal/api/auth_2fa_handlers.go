@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"os"
 
+	"github.com/BlaccStacc/blaccend/internal/geoip"
 	"github.com/BlaccStacc/blaccend/internal/security"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
@@ -11,7 +12,7 @@ import (
 
 // POST /auth/login/2fa
 // Body: { "temp_token": "...", "code": "123456" }
-func Login2FAHandler(db *sql.DB) fiber.Handler {
+func Login2FAHandler(db *sql.DB, geo geoip.Locator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var body Login2FARequest
 		if err := c.BodyParser(&body); err != nil {
@@ -43,17 +44,19 @@ func Login2FAHandler(db *sql.DB) fiber.Handler {
 		userID := int64(userIDFloat)
 
 		var (
-			username     string
-			email        string
-			totpSecret   string
-			twoFAEnabled bool
+			username      string
+			email         string
+			totpSecret    string
+			twoFAEnabled  bool
+			resetRequired bool
+			sessionVer    int
 		)
 
 		err = db.QueryRow(`
-			SELECT username, email, totp_secret, twofa_enabled
+			SELECT username, email, totp_secret, twofa_enabled, password_reset_required, session_version
 			FROM users
 			WHERE id = $1
-		`, userID).Scan(&username, &email, &totpSecret, &twoFAEnabled)
+		`, userID).Scan(&username, &email, &totpSecret, &twoFAEnabled, &resetRequired, &sessionVer)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "db error"})
 		}
@@ -63,13 +66,21 @@ func Login2FAHandler(db *sql.DB) fiber.Handler {
 		}
 
 		if !security.ValidateTOTP(body.Code, totpSecret) {
+			recordAuthEvent(db, c, "auth.login_failed", &userID, fiber.Map{"reason": "invalid 2fa code"})
 			return c.Status(400).JSON(fiber.Map{"error": "invalid 2fa code"})
 		}
 
-		accessToken, err := createAccessToken(userID, email, username)
+		// the temp token may predate "this wasn't me"
+		if resetRequired {
+			return c.Status(403).JSON(fiber.Map{"error": "password reset required"})
+		}
+
+		accessToken, err := createAccessToken(userID, email, username, sessionVer)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "token error"})
 		}
+		recordAuthEvent(db, c, "auth.login", &userID, fiber.Map{"2fa": true})
+		noteLoginDevice(db, geo, c, userID)
 
 		return c.JSON(fiber.Map{
 			"token": accessToken,
@@ -106,8 +117,14 @@ func TwoFASetupHandler(db *sql.DB) fiber.Handler {
 			return c.Status(500).JSON(fiber.Map{"error": "failed to build otpauth url"})
 		}
 
+		tx, err := db.Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		defer tx.Rollback()
+
 		// Store secret in DB but keep twofa_enabled = false until confirmed
-		_, err = db.Exec(`
+		_, err = tx.Exec(`
 			UPDATE users
 			SET totp_secret = $1,
 			    twofa_enabled = FALSE
@@ -116,6 +133,11 @@ func TwoFASetupHandler(db *sql.DB) fiber.Handler {
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "db error"})
 		}
+		// a new secret turns 2FA off until it is confirmed
+		recordAuthEvent(tx, c, "auth.2fa_setup", &userID, nil)
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
 
 		return c.JSON(fiber.Map{
 			"secret":      secret,
@@ -167,7 +189,13 @@ func TwoFAConfirmHandler(db *sql.DB) fiber.Handler {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid 2fa code"})
 		}
 
-		_, err = db.Exec(`
+		tx, err := db.Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(`
 			UPDATE users
 			SET twofa_enabled = TRUE
 			WHERE id = $1
@@ -175,6 +203,10 @@ func TwoFAConfirmHandler(db *sql.DB) fiber.Handler {
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "db error"})
 		}
+		recordAuthEvent(tx, c, "auth.2fa_enabled", &userID, nil)
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
 
 		return c.JSON(fiber.Map{
 			"message":       "2fa enabled",
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"database/sql"
 	"os"
 	"time"
 
@@ -20,7 +21,30 @@ func buildUserResponse(id int64, username, email string, emailVerified, twoFAEna
 	}
 }
 
-func createAccessToken(id int64, email, username string) (string, error) {
+// currentUserID reads the user id from the JWT claims set by AuthMiddleware.
+func currentUserID(c *fiber.Ctx) (int64, bool) {
+	claims, ok := c.Locals("user").(jwt.MapClaims)
+	if !ok {
+		return 0, false
+	}
+	idFloat, _ := claims["user_id"].(float64)
+	if idFloat == 0 {
+		return 0, false
+	}
+	return int64(idFloat), true
+}
+
+// isGarageOwner reports whether the user has the owner role.
+func isGarageOwner(db *sql.DB, userID int64) (bool, error) {
+	var role string
+	err := db.QueryRow(`SELECT role FROM users WHERE id = $1`, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return role == "owner", err
+}
+
+func createAccessToken(id int64, email, username string, sessionVersion int) (string, error) {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		return "", fiber.NewError(fiber.StatusInternalServerError, "JWT missing")
@@ -30,6 +54,7 @@ func createAccessToken(id int64, email, username string) (string, error) {
 		"user_id":  id,
 		"email":    email,
 		"username": username,
+		"sv":       sessionVersion, // see sessionCurrent
 		"exp":      time.Now().Add(24 * time.Hour).Unix(),
 		"typ":      "access",
 	}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/netip"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/geoip"
+	"github.com/BlaccStacc/blaccend/internal/mail"
+	"github.com/BlaccStacc/blaccend/internal/security"
+	"github.com/BlaccStacc/blaccend/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every successful login is matched against the user's known devices. One
+// from a device, network or country they haven't used before gets a security
+// notification with a "this wasn't me" link, which logs every session out
+// and makes them pick a new password.
+
+// loginAlertValidity is how long a "this wasn't me" link works.
+const loginAlertValidity = 7 * 24 * time.Hour
+
+// deviceFingerprint identifies the client: the X-Device-ID header apps send
+// (a random id they keep), otherwise the User-Agent. Only a hash is stored.
+func deviceFingerprint(c *fiber.Ctx) string {
+	id := "id:" + c.Get("X-Device-ID")
+	if c.Get("X-Device-ID") == "" {
+		id = "ua:" + c.Get(fiber.HeaderUserAgent)
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// noteLoginDevice remembers where userID just logged in from and raises an
+// alert when something about it is new. The first login ever only
+// remembers. Failures are logged; they never block the login.
+func noteLoginDevice(db *sql.DB, geo geoip.Locator, c *fiber.Ctx, userID int64) {
+	if err := recordLoginDevice(db, geo, c, userID); err != nil {
+		log.Printf("login device for user %d: %v", userID, err)
+	}
+}
+
+func recordLoginDevice(db *sql.DB, geo geoip.Locator, c *fiber.Ctx, userID int64) error {
+	ip, err := netip.ParseAddr(c.IP())
+	if err != nil {
+		return err
+	}
+	fingerprint := deviceFingerprint(c)
+	network := geoip.Network(ip)
+	country := geo.Country(ip)
+	userAgent := c.Get(fiber.HeaderUserAgent)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// denied devices don't count as known any more
+	var seenBefore, knownDevice, knownNetwork, knownCountry bool
+	err = tx.QueryRow(`
+		SELECT COUNT(*) > 0,
+		       COALESCE(BOOL_OR(fingerprint = $2), FALSE),
+		       COALESCE(BOOL_OR(ip_range = $3), FALSE),
+		       COALESCE(BOOL_OR(country = $4), FALSE)
+		FROM known_devices
+		WHERE user_id = $1 AND denied_at IS NULL
+	`, userID, fingerprint, network, country).Scan(&seenBefore, &knownDevice, &knownNetwork, &knownCountry)
+	if err != nil {
+		return err
+	}
+
+	var deviceID int64
+	err = tx.QueryRow(`
+		INSERT INTO known_devices (user_id, fingerprint, ip_range, country, user_agent, last_ip)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, fingerprint, ip_range, country) DO UPDATE
+		SET last_seen_at = NOW(), user_agent = EXCLUDED.user_agent, last_ip = EXCLUDED.last_ip, denied_at = NULL
+		RETURNING id
+	`, userID, fingerprint, network, country, userAgent, ip.String()).Scan(&deviceID)
+	if err != nil {
+		return err
+	}
+
+	alert := mail.LoginAlert{
+		At:         time.Now(),
+		IP:         ip.String(),
+		Country:    country,
+		UserAgent:  userAgent,
+		NewDevice:  !knownDevice,
+		NewNetwork: !knownNetwork,
+		NewCountry: country != "" && !knownCountry, // unknown isn't new
+	}
+	if seenBefore && (alert.NewDevice || alert.NewNetwork || alert.NewCountry) {
+		if alert.Token, err = security.NewRandomToken(32); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			UPDATE known_devices SET alert_token = $2, alerted_at = NOW() WHERE id = $1
+		`, deviceID, alert.Token); err != nil {
+			return err
+		}
+		if err := services.Notify(tx, userID, services.EventSecurity, "", alert); err != nil {
+			return err
+		}
+		recordAuthEvent(tx, c, "auth.login_new_device", &userID, fiber.Map{
+			"country":    country,
+			"new_device": alert.NewDevice, "new_network": alert.NewNetwork, "new_country": alert.NewCountry,
+		})
+	}
+
+	return tx.Commit()
+}
+
+// POST /auth/login-alert/deny
+// Body: { "token": "..." } from the "this wasn't me" link.
+// Logs every session out, revokes the calendar feed, forgets the device and
+// requires a new password; answers with a reset token so the client can go
+// straight to choosing one.
+// A POST rather than the link itself so mail scanners that prefetch links
+// can't trigger it.
+func DenyLoginHandler(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Token == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		defer tx.Rollback()
+
+		var deviceID, userID int64
+		err = tx.QueryRow(`
+			SELECT id, user_id
+			FROM known_devices
+			WHERE alert_token = $1 AND alerted_at > $2
+			FOR UPDATE
+		`, body.Token, time.Now().Add(-loginAlertValidity)).Scan(&deviceID, &userID)
+		if err == sql.ErrNoRows {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+
+		resetToken, err := security.NewRandomToken(32)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "token error"})
+		}
+
+		if _, err := tx.Exec(`UPDATE known_devices SET denied_at = NOW(), alert_token = NULL WHERE id = $1`, deviceID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		_, err = tx.Exec(`
+			UPDATE users
+			SET session_version = session_version + 1,
+			    password_reset_required = TRUE,
+			    calendar_token = NULL,
+			    reset_token = $2,
+			    reset_expires_at = $3
+			WHERE id = $1
+		`, userID, resetToken, time.Now().Add(passwordResetValidity))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		recordAuthEvent(tx, c, "auth.login_denied", &userID, fiber.Map{"device_id": deviceID})
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message":     "all sessions signed out; choose a new password",
+			"reset_token": resetToken,
+		})
+	}
+}
@@ -3,13 +3,15 @@ package api
 import (
 	"database/sql"
 
+	"github.com/BlaccStacc/blaccend/internal/geoip"
 	"github.com/BlaccStacc/blaccend/internal/security"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 // POST /auth/login
-func LoginHandler(db *sql.DB) fiber.Handler {
+// A login from somewhere new raises an alert, see noteLoginDevice.
+func LoginHandler(db *sql.DB, geo geoip.Locator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var body LoginRequest
 		if err := c.BodyParser(&body); err != nil {
@@ -23,15 +25,20 @@ func LoginHandler(db *sql.DB) fiber.Handler {
 			passwordHash  string
 			emailVerified bool
 			twoFAEnabled  bool
+			resetRequired bool
+			sessionVer    int
 		)
 
 		err := db.QueryRow(`
-			SELECT id, username, email, password_hash, email_verified, twofa_enabled
+			SELECT id, username, email, password_hash, email_verified, twofa_enabled,
+			       password_reset_required, session_version
 			FROM users
 			WHERE email = $1
-		`, body.Email).Scan(&id, &username, &email, &passwordHash, &emailVerified, &twoFAEnabled)
+		`, body.Email).Scan(&id, &username, &email, &passwordHash, &emailVerified, &twoFAEnabled,
+			&resetRequired, &sessionVer)
 
 		if err == sql.ErrNoRows {
+			recordAuthEvent(db, c, "auth.login_failed", nil, fiber.Map{"email": body.Email, "reason": "unknown email"})
 			return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
 		}
 		if err != nil {
@@ -39,14 +46,24 @@ func LoginHandler(db *sql.DB) fiber.Handler {
 		}
 
 		if !emailVerified {
+			recordAuthEvent(db, c, "auth.login_failed", &id, fiber.Map{"reason": "email not verified"})
 			return c.Status(403).JSON(fiber.Map{"error": "email not verified"})
 		}
 
 		if !security.VerifyPassword(passwordHash, body.Password) {
+			recordAuthEvent(db, c, "auth.login_failed", &id, fiber.Map{"reason": "wrong password"})
 			return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
 		}
 
+		// after "this wasn't me" the old password is presumed known
+		if resetRequired {
+			recordAuthEvent(db, c, "auth.login_failed", &id, fiber.Map{"reason": "password reset required"})
+			return c.Status(403).JSON(fiber.Map{"error": "password reset required"})
+		}
+
 		if twoFAEnabled {
+			// the login only counts once the second factor is checked
+			recordAuthEvent(db, c, "auth.password_ok", &id, nil)
 			tempToken, err := createTemp2FAToken(id, email)
 			if err != nil {
 				return c.Status(500).JSON(fiber.Map{"error": "token error"})
@@ -59,10 +76,12 @@ func LoginHandler(db *sql.DB) fiber.Handler {
 			})
 		}
 
-		accesToken, err := createAccessToken(id, email, username)
+		accesToken, err := createAccessToken(id, email, username, sessionVer)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "token error"})
 		}
+		recordAuthEvent(db, c, "auth.login", &id, nil)
+		noteLoginDevice(db, geo, c, id)
 
 		return c.JSON(fiber.Map{
 			"token": accesToken,
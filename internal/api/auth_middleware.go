@@ -1,14 +1,16 @@
 package api
 
 import (
+	"database/sql"
 	"os"
 	"strings"
 
 	"github.com/BlaccStacc/blaccend/internal/security"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware() fiber.Handler {
+func AuthMiddleware(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Expect "Authorization: Bearer <token>"
 		authHeader := c.Get("Authorization")
@@ -32,6 +34,13 @@ func AuthMiddleware() fiber.Handler {
 		if err != nil {
 			return c.Status(401).JSON(fiber.Map{"error": "invalid or expired token"})
 		}
+		// 2fa, events and calendar tokens only work where they're meant to
+		if typ, _ := claims["typ"].(string); typ != "access" {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid token type"})
+		}
+		if !sessionCurrent(db, claims) {
+			return c.Status(401).JSON(fiber.Map{"error": "session revoked"})
+		}
 
 		// Attach claims to context
 		c.Locals("user", claims)
@@ -39,3 +48,17 @@ func AuthMiddleware() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// sessionCurrent reports whether a token's session version ("sv", 0 when
+// missing) still matches the user's. Bumping users.session_version (password
+// reset, "this wasn't me") logs out every token issued before.
+func sessionCurrent(db *sql.DB, claims jwt.MapClaims) bool {
+	idFloat, _ := claims["user_id"].(float64)
+	sv, _ := claims["sv"].(float64)
+
+	var version int
+	if err := db.QueryRow(`SELECT session_version FROM users WHERE id = $1`, int64(idFloat)).Scan(&version); err != nil {
+		return false
+	}
+	return version == int(sv)
+}
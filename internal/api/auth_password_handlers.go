@@ -0,0 +1,117 @@
+package api
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/mail"
+	"github.com/BlaccStacc/blaccend/internal/security"
+	"github.com/BlaccStacc/blaccend/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// passwordResetValidity is how long a reset token works.
+const passwordResetValidity = time.Hour
+
+// POST /auth/password/forgot
+// Body: { "email": "..." }
+// Mails a reset link. The answer is the same whether the address has an
+// account or not.
+func ForgotPasswordHandler(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			Email string `json:"email"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Email == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "email required"})
+		}
+
+		token, err := security.NewRandomToken(32)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "token error"})
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		defer tx.Rollback()
+
+		var id int64
+		var to mail.Recipient
+		err = tx.QueryRow(`
+			UPDATE users
+			SET reset_token = $2, reset_expires_at = $3
+			WHERE email = $1
+			RETURNING id, email, locale
+		`, body.Email, token, time.Now().Add(passwordResetValidity)).Scan(&id, &to.Email, &to.Locale)
+		switch {
+		case err == sql.ErrNoRows:
+			recordAuthEvent(tx, c, "auth.password_reset_requested", nil, fiber.Map{"email": body.Email, "reason": "unknown email"})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		default:
+			if err := services.QueuePasswordResetEmail(tx, to, token); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "db error"})
+			}
+			recordAuthEvent(tx, c, "auth.password_reset_requested", &id, nil)
+		}
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+
+		return c.JSON(fiber.Map{"message": "if the address has an account, a reset link is on its way"})
+	}
+}
+
+// POST /auth/password/reset
+// Body: { "token": "...", "password": "..." }
+// Sets the new password and logs every existing session out.
+func ResetPasswordHandler(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Token == "" || body.Password == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "token and password required"})
+		}
+		if err := security.ValidatePasswordStrength(body.Password); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		passwordHash, err := security.HashPassword(body.Password)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "password hashing failed"})
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		defer tx.Rollback()
+
+		var id int64
+		err = tx.QueryRow(`
+			UPDATE users
+			SET password_hash = $2,
+			    reset_token = NULL,
+			    reset_expires_at = NULL,
+			    password_reset_required = FALSE,
+			    session_version = session_version + 1
+			WHERE reset_token = $1 AND reset_expires_at > NOW()
+			RETURNING id
+		`, body.Token, passwordHash).Scan(&id)
+		if err == sql.ErrNoRows {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		recordAuthEvent(tx, c, "auth.password_reset", &id, nil)
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+
+		return c.JSON(fiber.Map{"message": "password changed; log in again"})
+	}
+}
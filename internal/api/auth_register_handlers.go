@@ -2,15 +2,20 @@ package api
 
 import (
 	"database/sql"
-	"log"
 
 	"time"
 
 	"github.com/BlaccStacc/blaccend/internal/mail"
 	"github.com/BlaccStacc/blaccend/internal/security"
+	"github.com/BlaccStacc/blaccend/internal/services"
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
 	"github.com/gofiber/fiber/v2"
 )
 
+// firstOwnerLockKey serialises signups (pg_advisory_xact_lock) so only one
+// of them can become the owner.
+const firstOwnerLockKey = 0x6f776e72 // "ownr"
+
 // POST /auth/register
 func RegisterHandler(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -23,6 +28,11 @@ func RegisterHandler(db *sql.DB) fiber.Handler {
 			return c.Status(400).JSON(fiber.Map{"error": "email and password required"})
 		}
 
+		if body.Locale != "" && !mail.IsLocale(body.Locale) {
+			return c.Status(400).JSON(fiber.Map{"error": "unsupported locale"})
+		}
+		locale := mail.MatchLocale(body.Locale, c.Get(fiber.HeaderAcceptLanguage))
+
 		// 🔐 NEW: password strength check
 		if err := security.ValidatePasswordStrength(body.Password); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
@@ -50,19 +60,37 @@ func RegisterHandler(db *sql.DB) fiber.Handler {
 		}
 		verifyExpires := time.Now().Add(24 * time.Hour)
 
+		tx, err := db.Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		defer tx.Rollback()
+
+		// the first account becomes the garage owner; the lock keeps two
+		// signups racing on an empty table from both seeing no users
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, firstOwnerLockKey); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
 		var id int64
-		err = db.QueryRow(`
-			INSERT INTO users (username, email, password_hash, email_verified, verify_token, verify_expires_at, date_registered)
-			VALUES ($1, $2, $3, FALSE, $4, $5, $6)
+		err = tx.QueryRow(`
+			INSERT INTO users (username, email, password_hash, email_verified, verify_token, verify_expires_at, date_registered, locale, role)
+			VALUES ($1, $2, $3, FALSE, $4, $5, $6, $7,
+			        CASE WHEN EXISTS(SELECT 1 FROM users) THEN 'member' ELSE 'owner' END)
 			RETURNING id
-		`, body.Username, body.Email, passwordHash, verifyToken, verifyExpires, time.Now().UTC()).Scan(&id)
+		`, body.Username, body.Email, passwordHash, verifyToken, verifyExpires, time.Now().UTC(), locale).Scan(&id)
 		if err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "could not create user"})
 		}
 
-		if err := mail.SendVerificationEmail(body.Email, verifyToken); err != nil {
-			log.Printf("SendVerificationEmail failed: %v", err)
-			return c.Status(500).JSON(fiber.Map{"error": "failed to send verification email"})
+		// the mail goes out from the job queue; SMTP trouble only delays it
+		if err := services.QueueVerificationEmail(tx, mail.Recipient{Email: body.Email, Locale: locale}, verifyToken); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		if err := webhooks.Enqueue(tx, "user.registered", fiber.Map{"id": id, "username": body.Username}); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
 		}
 
 		return c.Status(201).JSON(buildUserResponse(id, body.Username, body.Email, false, false))
@@ -97,3 +125,35 @@ func VerifyEmailHandler(db *sql.DB) fiber.Handler {
 		return c.JSON(fiber.Map{"message": "email verified"})
 	}
 }
+
+// PUT /auth/me/locale   (protected; requires AuthMiddleware)
+// Body: { "locale": "ro" }
+// Sets the language mail to the user is written in.
+func UpdateLocaleHandler(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+
+		var body struct {
+			Locale string `json:"locale"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
+		}
+		if !mail.IsLocale(body.Locale) {
+			return c.Status(400).JSON(fiber.Map{"error": "unsupported locale", "supported": mail.Locales})
+		}
+
+		res, err := db.Exec(`UPDATE users SET locale = $1 WHERE id = $2`, body.Locale, userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "user not found"})
+		}
+
+		return c.JSON(fiber.Map{"locale": body.Locale})
+	}
+}
@@ -4,6 +4,7 @@ type RegisterRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Locale   string `json:"locale"` // mail language; Accept-Language when empty
 }
 
 type LoginRequest struct {
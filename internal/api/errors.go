@@ -0,0 +1,159 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Every error leaves the API as JSON, same shape the auth handlers use:
+//
+//	{"error": "validation failed", "fields": {"quantity": "must be >= 0"}}
+//
+// "fields" is only present for validation problems.
+
+// ValidationError carries per-field messages; it is answered with 422.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+" "+e.Fields[k])
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+// validator collects field problems; the first message per field wins.
+type validator struct {
+	fields map[string]string
+}
+
+func (v *validator) add(field, msg string) {
+	if v.fields == nil {
+		v.fields = map[string]string{}
+	}
+	if _, ok := v.fields[field]; !ok {
+		v.fields[field] = msg
+	}
+}
+
+// check records msg for field when ok is false.
+func (v *validator) check(ok bool, field, msg string) {
+	if !ok {
+		v.add(field, msg)
+	}
+}
+
+func (v *validator) required(s, field string) {
+	v.check(strings.TrimSpace(s) != "", field, "is required")
+}
+
+func (v *validator) maxLen(s *string, n int, field string) {
+	if s != nil {
+		v.check(len([]rune(*s)) <= n, field, "is too long")
+	}
+}
+
+func (v *validator) minInt(n *int, min int, field string) {
+	if n != nil {
+		v.check(*n >= min, field, "must be >= "+strconv.Itoa(min))
+	}
+}
+
+// err returns nil when everything checked out.
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+// constraintFields maps constraint names to the request field they guard, so
+// database-level rejections still point at the right input.
+var constraintFields = map[string]string{
+	"garage_items_space_id_fkey":          "space_id",
+	"garage_items_quantity_check":         "quantity",
+	"garage_items_min_quantity_check":     "min_quantity",
+	"garage_items_reorder_quantity_check": "reorder_quantity",
+	"garage_items_purchase_price_check":   "purchase_price",
+}
+
+// dbError turns a database error into the HTTP error a client should see:
+// no rows -> 404 (with notFound as message), FK violation -> 422 on the
+// offending field (409 when deleting something still referenced), unique ->
+// 409, check / bad input -> 422. A quantity change the stock rules refuse is
+// a 409 too. Anything else is logged and becomes 500.
+func dbError(err error, notFound string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fiber.NewError(fiber.StatusNotFound, notFound)
+	}
+	if errors.Is(err, errInsufficientStock) || errors.Is(err, errStockLentOut) {
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		log.Printf("db error: %v", err)
+		return fiber.ErrInternalServerError
+	}
+
+	field := constraintFields[pgErr.ConstraintName]
+	switch pgErr.Code {
+	case "23503": // foreign_key_violation
+		if field != "" {
+			return &ValidationError{Fields: map[string]string{field: "does not exist"}}
+		}
+		// deleting something still referenced
+		return fiber.NewError(fiber.StatusConflict, "still referenced by other records")
+	case "23505": // unique_violation
+		return fiber.NewError(fiber.StatusConflict, "already exists")
+	case "23514", "23502": // check_violation, not_null_violation
+		if field == "" {
+			field = pgErr.ColumnName
+		}
+		if field != "" {
+			return &ValidationError{Fields: map[string]string{field: "is out of range"}}
+		}
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "value out of range")
+	case "22P02", "22003", "22007", "22008": // bad text repr, numeric/datetime out of range
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "invalid value")
+	}
+
+	log.Printf("db error: %v", err)
+	return fiber.ErrInternalServerError
+}
+
+// ErrorHandler is the app-wide fiber error handler.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":  "validation failed",
+			"fields": ve.Fields,
+		})
+	}
+
+	code := fiber.StatusInternalServerError
+	msg := "internal server error"
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		code, msg = fe.Code, fe.Message
+	} else {
+		log.Printf("unhandled error on %s %s: %v", c.Method(), c.Path(), err)
+	}
+
+	return c.Status(code).JSON(fiber.Map{"error": msg})
+}
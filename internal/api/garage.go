@@ -2,9 +2,12 @@ package api
 
 import (
 	"database/sql"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -13,6 +16,8 @@ type GarageSpace struct {
 	Name        string  `json:"name"`
 	Description *string `json:"description,omitempty"`
 	Location    *string `json:"location,omitempty"` // ex: "Shelf A1"
+	Version     int     `json:"version"`
+	DeletedAt   *string `json:"deleted_at,omitempty"` // only set in the trash
 	CreatedAt   string  `json:"created_at"`
 }
 
@@ -22,74 +27,342 @@ type GarageItem struct {
 	Name      string  `json:"name"`
 	Quantity  int     `json:"quantity"`
 	Notes     *string `json:"notes,omitempty"`
+	Version   int     `json:"version"`              // also sent as the ETag
+	DeletedAt *string `json:"deleted_at,omitempty"` // only set in the trash
 	CreatedAt string  `json:"created_at"`
 	UpdatedAt string  `json:"updated_at"`
+
+	MinQuantity     *int `json:"min_quantity,omitempty"`
+	ReorderQuantity *int `json:"reorder_quantity,omitempty"`
+
+	CheckedOut int `json:"checked_out"` // units currently lent out
+	Available  int `json:"available"`   // quantity - checked_out
+
+	UsageHours float64 `json:"usage_hours"`
+
+	// purchase / warranty info (dates are YYYY-MM-DD)
+	PurchaseDate    *string  `json:"purchase_date,omitempty"`
+	Vendor          *string  `json:"vendor,omitempty"`
+	PurchasePrice   *float64 `json:"purchase_price,omitempty"` // per unit
+	Currency        *string  `json:"currency,omitempty"`
+	SerialNumber    *string  `json:"serial_number,omitempty"`
+	WarrantyExpires *string  `json:"warranty_expires,omitempty"`
+}
+
+const garageItemColumns = `
+	i.id, i.space_id, i.name, i.quantity, i.notes, i.min_quantity, i.reorder_quantity,
+	i.usage_hours, i.purchase_date, i.vendor, i.purchase_price, i.currency, i.serial_number,
+	i.warranty_expires, i.version, i.deleted_at, i.created_at, i.updated_at, COALESCE(l.out, 0)`
+
+const garageItemFrom = `
+	FROM garage_items i
+	LEFT JOIN (
+		SELECT item_id, SUM(quantity) AS out
+		FROM garage_loans
+		WHERE returned_at IS NULL
+		GROUP BY item_id
+	) l ON l.item_id = i.id`
+
+const dateFormat = "2006-01-02"
+
+func scanGarageItem(row rowScanner) (GarageItem, error) {
+	var it GarageItem
+	var created, updated time.Time
+	var purchased, warranty, deleted *time.Time
+
+	err := row.Scan(&it.ID, &it.SpaceID, &it.Name, &it.Quantity, &it.Notes, &it.MinQuantity, &it.ReorderQuantity,
+		&it.UsageHours, &purchased, &it.Vendor, &it.PurchasePrice, &it.Currency, &it.SerialNumber,
+		&warranty, &it.Version, &deleted, &created, &updated, &it.CheckedOut)
+	if err != nil {
+		return it, err
+	}
+	it.Available = it.Quantity - it.CheckedOut
+	it.PurchaseDate = formatOptionalDate(purchased)
+	it.WarrantyExpires = formatOptionalDate(warranty)
+	it.DeletedAt = formatOptionalTime(deleted)
+	it.CreatedAt = created.UTC().Format(time.RFC3339)
+	it.UpdatedAt = updated.UTC().Format(time.RFC3339)
+	return it, nil
+}
+
+const garageSpaceColumns = `id, name, description, location, version, deleted_at, created_at`
+
+func scanGarageSpace(row rowScanner) (GarageSpace, error) {
+	var s GarageSpace
+	var created time.Time
+	var deleted *time.Time
+	if err := row.Scan(&s.ID, &s.Name, &s.Description, &s.Location, &s.Version, &deleted, &created); err != nil {
+		return s, err
+	}
+	s.DeletedAt = formatOptionalTime(deleted)
+	s.CreatedAt = created.UTC().Format(time.RFC3339)
+	return s, nil
+}
+
+// loadGarageItem reads an item (trashed or not) and locks it for the rest of
+// tx; handlers use it for the before/after snapshots of the audit log.
+func loadGarageItem(tx *sql.Tx, id int64) (GarageItem, error) {
+	return scanGarageItem(tx.QueryRow(`SELECT `+garageItemColumns+garageItemFrom+` WHERE i.id = $1 FOR UPDATE OF i`, id))
+}
+
+// loadGarageSpace is loadGarageItem for spaces.
+func loadGarageSpace(tx *sql.Tx, id int64) (GarageSpace, error) {
+	return scanGarageSpace(tx.QueryRow(`SELECT `+garageSpaceColumns+` FROM garage_spaces WHERE id = $1 FOR UPDATE`, id))
+}
+
+func formatOptionalDate(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format(dateFormat)
+	return &s
+}
+
+// purchaseInfo is the purchase/warranty part of item create & update bodies.
+type purchaseInfo struct {
+	PurchaseDate    *string  `json:"purchase_date"`
+	Vendor          *string  `json:"vendor"`
+	PurchasePrice   *float64 `json:"purchase_price"`
+	Currency        *string  `json:"currency"`
+	SerialNumber    *string  `json:"serial_number"`
+	WarrantyExpires *string  `json:"warranty_expires"`
+
+	purchaseDate    *time.Time
+	warrantyExpires *time.Time
+}
+
+// parse validates the fields into v and fills in the parsed dates.
+func (p *purchaseInfo) parse(v *validator) {
+	if p.PurchaseDate != nil {
+		t, err := time.Parse(dateFormat, *p.PurchaseDate)
+		v.check(err == nil, "purchase_date", "must be YYYY-MM-DD")
+		if err == nil {
+			p.purchaseDate = &t
+		}
+	}
+	if p.WarrantyExpires != nil {
+		t, err := time.Parse(dateFormat, *p.WarrantyExpires)
+		v.check(err == nil, "warranty_expires", "must be YYYY-MM-DD")
+		if err == nil {
+			p.warrantyExpires = &t
+		}
+	}
+	if p.PurchasePrice != nil {
+		v.check(*p.PurchasePrice >= 0, "purchase_price", "cannot be negative")
+	}
+	if p.Currency != nil {
+		cur := strings.ToUpper(*p.Currency)
+		v.check(len(cur) == 3, "currency", "must be a 3-letter ISO code")
+		p.Currency = &cur
+	}
+	v.maxLen(p.Vendor, maxShortText, "vendor")
+	v.maxLen(p.SerialNumber, maxShortText, "serial_number")
 }
 
+// limits for free-text fields
+const (
+	maxShortText = 200
+	maxLongText  = 5000
+)
+
 // RegisterGarageRoutes attaches garage endpoints to protected group
 func RegisterGarageRoutes(group fiber.Router, db *sql.DB) {
 	// Spaces
 	group.Get("/garage/spaces", listGarageSpaces(db))
 	group.Post("/garage/spaces", createGarageSpace(db))
+	group.Get("/garage/spaces/:id", getGarageSpace(db))
+	group.Patch("/garage/spaces/:id", patchGarageSpace(db))
+	group.Delete("/garage/spaces/:id", deleteGarageSpace(db))
+	group.Post("/garage/spaces/:id/restore", restoreGarageSpace(db))
 
 	// Items
 	group.Get("/garage/items", listGarageItems(db))
 	group.Post("/garage/items", createGarageItem(db))
+	group.Post("/garage/items/batch", batchGarageItems(db))
+	group.Get("/garage/items/:id", getGarageItem(db))
 	group.Put("/garage/items/:id", updateGarageItem(db))
+	group.Patch("/garage/items/:id", patchGarageItem(db))
 	group.Delete("/garage/items/:id", deleteGarageItem(db))
+	group.Post("/garage/items/:id/restore", restoreGarageItem(db))
+	group.Get("/garage/items/:id/history", listItemHistory(db))
+
+	// Trash
+	group.Get("/garage/trash", listGarageTrash(db))
+
+	// Bulk import
+	group.Post("/garage/import", importGarageItems(db))
+	group.Get("/garage/import/:id", getImportJob(db))
+	group.Post("/garage/import/:id/resume", resumeImportJob(db))
+
+	// Backups
+	group.Get("/garage/export", exportGarage(db))
+	group.Post("/garage/restore", restoreGarage(db))
+
+	// Stock ledger
+	group.Get("/garage/items/:id/movements", listStockMovements(db))
+	group.Post("/garage/items/:id/movements", createStockMovement(db))
+
+	// Low-stock alerts
+	group.Get("/garage/alerts", listGarageAlerts(db))
+	group.Post("/garage/alerts/:id/ack", acknowledgeGarageAlert(db))
+
+	// Lending
+	group.Post("/garage/items/:id/checkout", checkOutGarageItem(db))
+	group.Get("/garage/loans", listGarageLoans(db))
+	group.Post("/garage/loans/:id/checkin", checkInGarageLoan(db))
+
+	// Reservations
+	group.Post("/garage/items/:id/reservations", createGarageReservation(db))
+	group.Get("/garage/reservations", listGarageReservations(db))
+	group.Get("/garage/reservations/feed", garageCalendarFeedURL(db))
+	group.Post("/garage/reservations/feed/rotate", rotateGarageCalendarFeed(db))
+	group.Delete("/garage/reservations/feed", revokeGarageCalendarFeed(db))
+	group.Delete("/garage/reservations/:id", cancelGarageReservation(db))
+
+	// Live changes (the stream itself is registered in RegisterRoutes)
+	group.Get("/garage/events/url", garageEventStreamURL())
+
+	// Maintenance
+	group.Post("/garage/items/:id/usage", recordItemUsage(db))
+	group.Get("/garage/items/:id/maintenance", listItemMaintenancePlans(db))
+	group.Post("/garage/items/:id/maintenance", createMaintenancePlan(db))
+	group.Get("/garage/maintenance", listUpcomingMaintenance(db))
+	group.Delete("/garage/maintenance/:id", deleteMaintenancePlan(db))
+	group.Get("/garage/maintenance/:id/log", listMaintenanceLog(db))
+	group.Post("/garage/maintenance/:id/log", logMaintenance(db))
+
+	// Purchase, warranty & attachments
+	group.Get("/garage/items/:id/attachments", listItemAttachments(db))
+	group.Post("/garage/items/:id/attachments", uploadItemAttachment(db))
+	group.Get("/garage/attachments/:id", downloadAttachment(db))
+	group.Delete("/garage/attachments/:id", deleteAttachment(db))
+	group.Get("/garage/warranties", listExpiringWarranties(db))
+	group.Get("/garage/reports/value", garageValueReport(db))
+	group.Get("/garage/reports/inventory", garageInventoryReport(db))
 }
 
 // ---------- SPACES HANDLERS ----------
 
+// GET /garage/spaces?limit=50&cursor=...&sort=-name&name_prefix=gar
+// The body is an array; the next page's cursor comes in X-Next-Cursor / Link.
+// Without limit or cursor every space is returned.
 func listGarageSpaces(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		rows, err := db.Query(`SELECT id, name, description, location, created_at FROM garage_spaces ORDER BY id`)
+		page, err := parseOptionalListPage(c, garageSpaceSorts, "id")
+		if err != nil {
+			return err
+		}
+
+		conds := []string{"deleted_at IS NULL"}
+		args := []any{}
+		if v := c.Query("name_prefix"); v != "" {
+			args = append(args, escapeLike(v)+"%")
+			conds = append(conds, fmt.Sprintf("name ILIKE $%d", len(args)))
+		}
+		if cond, a := page.where(args); cond != "" {
+			conds, args = append(conds, cond), a
+		}
+
+		q := `SELECT ` + garageSpaceColumns + page.sortKeyColumn() + ` FROM garage_spaces`
+		q += ` WHERE ` + strings.Join(conds, " AND ")
+		q += page.orderLimit()
+
+		rows, err := db.Query(q, args...)
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
 		defer rows.Close()
 
-		var spaces []GarageSpace
+		spaces := []GarageSpace{}
+		var n int
+		var lastKey string
 		for rows.Next() {
-			var s GarageSpace
-			var desc, loc *string
-			var created time.Time
-
-			if err := rows.Scan(&s.ID, &s.Name, &desc, &loc, &created); err != nil {
+			var key string
+			s, err := scanGarageSpace(keyedRow{rows, &key})
+			if err != nil {
 				return fiber.ErrInternalServerError
 			}
-			s.Description = desc
-			s.Location = loc
-			s.CreatedAt = created.UTC().Format(time.RFC3339)
+			if n++; page.full(n) {
+				break
+			}
 			spaces = append(spaces, s)
+			lastKey = key
 		}
 
+		if len(spaces) > 0 {
+			setNextPage(c, page.next(n, lastKey, spaces[len(spaces)-1].ID))
+		}
 		return c.JSON(spaces)
 	}
 }
 
-func createGarageSpace(db *sql.DB) fiber.Handler {
+// GET /garage/spaces/:id   (ETag / If-None-Match aware)
+func getGarageSpace(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var body struct {
-			Name        string  `json:"name"`
-			Description *string `json:"description"`
-			Location    *string `json:"location"`
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		s, err := scanGarageSpace(db.QueryRow(`SELECT `+garageSpaceColumns+` FROM garage_spaces WHERE id = $1 AND deleted_at IS NULL`, id))
+		if err != nil {
+			return dbError(err, "space not found")
 		}
 
+		return sendWithETag(c, s.Version, s)
+	}
+}
+
+// newGarageSpace is the body of POST /garage/spaces (and of sync creates).
+type newGarageSpace struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Location    *string `json:"location"`
+}
+
+func (s *newGarageSpace) validate() error {
+	var v validator
+	v.required(s.Name, "name")
+	v.maxLen(&s.Name, maxShortText, "name")
+	v.maxLen(s.Description, maxLongText, "description")
+	v.maxLen(s.Location, maxShortText, "location")
+	return v.err()
+}
+
+func createGarageSpace(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body newGarageSpace
 		if err := c.BodyParser(&body); err != nil {
 			return fiber.ErrBadRequest
 		}
-		if body.Name == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "name is required")
+		if err := body.validate(); err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
 		}
+		defer tx.Rollback()
 
 		var id int64
-		err := db.QueryRow(`
+		err = tx.QueryRow(`
 			INSERT INTO garage_spaces (name, description, location)
 			VALUES ($1, $2, $3)
 			RETURNING id
 		`, body.Name, body.Description, body.Location).Scan(&id)
 		if err != nil {
+			return dbError(err, "")
+		}
+
+		s, err := loadGarageSpace(tx, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := requestActor(c).record(tx, "space.create", "space", id, nil, s); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
 			return fiber.ErrInternalServerError
 		}
 
@@ -99,17 +372,58 @@ func createGarageSpace(db *sql.DB) fiber.Handler {
 
 // ---------- ITEMS HANDLERS ----------
 
+// GET /garage/items?limit=50&cursor=...&sort=-updated_at&fields=id,name,quantity
+// Filters: space_id, updated_since (RFC3339 or YYYY-MM-DD), quantity_lt, name_prefix.
+// Paged like listGarageSpaces.
 func listGarageItems(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		spaceID := c.Query("space_id") // optional filter
+		page, err := parseOptionalListPage(c, garageItemSorts, "i.id")
+		if err != nil {
+			return err
+		}
 
-		q := `SELECT id, space_id, name, quantity, notes, created_at, updated_at FROM garage_items`
+		conds := []string{"i.deleted_at IS NULL"}
 		args := []any{}
-		if spaceID != "" {
-			q += ` WHERE space_id = $1`
-			args = append(args, spaceID)
+		if v := c.Query("space_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid space_id")
+			}
+			args = append(args, id)
+			conds = append(conds, fmt.Sprintf("i.space_id = $%d", len(args)))
+		}
+		if v := c.Query("updated_since"); v != "" {
+			t, err := parseFlexibleTime(v)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "updated_since must be RFC3339 or YYYY-MM-DD")
+			}
+			args = append(args, t)
+			conds = append(conds, fmt.Sprintf("i.updated_at >= $%d", len(args)))
+		}
+		if v := c.Query("quantity_lt"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "quantity_lt must be a number")
+			}
+			args = append(args, n)
+			conds = append(conds, fmt.Sprintf("i.quantity < $%d", len(args)))
+		}
+		if v := c.Query("name_prefix"); v != "" {
+			args = append(args, escapeLike(v)+"%")
+			conds = append(conds, fmt.Sprintf("i.name ILIKE $%d", len(args)))
+		}
+		if cond, a := page.where(args); cond != "" {
+			conds, args = append(conds, cond), a
 		}
-		q += ` ORDER BY id`
+
+		var fields []string
+		if v := c.Query("fields"); v != "" {
+			fields = strings.Split(v, ",")
+		}
+
+		q := `SELECT ` + garageItemColumns + page.sortKeyColumn() + garageItemFrom
+		q += ` WHERE ` + strings.Join(conds, " AND ")
+		q += page.orderLimit()
 
 		rows, err := db.Query(q, args...)
 		if err != nil {
@@ -117,52 +431,232 @@ func listGarageItems(db *sql.DB) fiber.Handler {
 		}
 		defer rows.Close()
 
-		var items []GarageItem
+		items := []any{}
+		var n int
+		var lastKey string
+		var lastID int64
 		for rows.Next() {
-			var it GarageItem
-			var notes *string
-			var created, updated time.Time
+			var key string
+			it, err := scanGarageItem(keyedRow{rows, &key})
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			if n++; page.full(n) {
+				break
+			}
+			lastKey, lastID = key, it.ID
 
-			if err := rows.Scan(&it.ID, &it.SpaceID, &it.Name, &it.Quantity, &notes, &created, &updated); err != nil {
+			out, err := pickFields(it, fields)
+			if err != nil {
 				return fiber.ErrInternalServerError
 			}
-			it.Notes = notes
-			it.CreatedAt = created.UTC().Format(time.RFC3339)
-			it.UpdatedAt = updated.UTC().Format(time.RFC3339)
-			items = append(items, it)
+			items = append(items, out)
 		}
 
+		setNextPage(c, page.next(n, lastKey, lastID))
 		return c.JSON(items)
 	}
 }
 
+// newGarageItem is the body of POST /garage/items (and of imports / batch creates).
+type newGarageItem struct {
+	SpaceID  int64   `json:"space_id"`
+	Name     string  `json:"name"`
+	Quantity int     `json:"quantity"`
+	Notes    *string `json:"notes"`
+
+	MinQuantity     *int `json:"min_quantity"`
+	ReorderQuantity *int `json:"reorder_quantity"`
+
+	purchaseInfo
+}
+
+// validate checks a create body; a zero quantity must already be defaulted.
+func (it *newGarageItem) validate() error {
+	var v validator
+	v.check(it.SpaceID > 0, "space_id", "is required")
+	v.required(it.Name, "name")
+	v.maxLen(&it.Name, maxShortText, "name")
+	v.minInt(&it.Quantity, 0, "quantity")
+	v.maxLen(it.Notes, maxLongText, "notes")
+	v.minInt(it.MinQuantity, 0, "min_quantity")
+	v.minInt(it.ReorderQuantity, 1, "reorder_quantity")
+	it.purchaseInfo.parse(&v)
+	return v.err()
+}
+
+// insertGarageItem creates the item with quantity 0 and books the opening
+// stock through the ledger, so quantity always equals the sum of movements.
+func insertGarageItem(tx *sql.Tx, it newGarageItem, actorID *int64) (int64, error) {
+	if err := requireLiveSpace(tx, it.SpaceID); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := tx.QueryRow(`
+		INSERT INTO garage_items (space_id, name, quantity, notes, min_quantity, reorder_quantity,
+		                          purchase_date, vendor, purchase_price, currency, serial_number, warranty_expires)
+		VALUES ($1, $2, 0, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`, it.SpaceID, it.Name, it.Notes, it.MinQuantity, it.ReorderQuantity,
+		it.purchaseDate, it.Vendor, it.PurchasePrice, it.Currency, it.SerialNumber,
+		it.warrantyExpires).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if it.Quantity != 0 {
+		initialNote := "initial stock"
+		if _, err := applyStockMovement(tx, id, it.Quantity, "adjustment", &initialNote, actorID); err != nil {
+			return 0, err
+		}
+	}
+
+	// stock only raises alerts as it falls; an item can start out short
+	if it.MinQuantity != nil {
+		if _, err := recordLowStockAlert(tx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// garageItemPatch is the body of PUT /garage/items/:id; nil fields are left alone.
+type garageItemPatch struct {
+	SpaceID  *int64  `json:"space_id"`
+	Name     *string `json:"name"`
+	Quantity *int    `json:"quantity"`
+	Notes    *string `json:"notes"`
+
+	MinQuantity     *int `json:"min_quantity"`
+	ReorderQuantity *int `json:"reorder_quantity"`
+
+	purchaseInfo
+}
+
+func (p *garageItemPatch) validate() error {
+	var v validator
+	if p.SpaceID != nil {
+		v.check(*p.SpaceID > 0, "space_id", "must be a valid id")
+	}
+	if p.Name != nil {
+		v.required(*p.Name, "name")
+		v.maxLen(p.Name, maxShortText, "name")
+	}
+	v.minInt(p.Quantity, 0, "quantity")
+	v.maxLen(p.Notes, maxLongText, "notes")
+	v.minInt(p.MinQuantity, 0, "min_quantity")
+	v.minInt(p.ReorderQuantity, 1, "reorder_quantity")
+	p.purchaseInfo.parse(&v)
+	return v.err()
+}
+
+// updateGarageItemTx applies a patch inside the caller's transaction and
+// returns the item's new version. ifMatch (an If-Match value, "" to skip the
+// check) must match the current version or errVersionMismatch is returned.
+// Returns sql.ErrNoRows when the item doesn't exist.
+func updateGarageItemTx(tx *sql.Tx, id int64, p garageItemPatch, ifMatch string, actorID *int64) (int, error) {
+	var current, version int
+	err := tx.QueryRow(`
+		SELECT quantity, version FROM garage_items WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, id).Scan(&current, &version)
+	if err != nil {
+		return 0, err
+	}
+	if ifMatch != "" && !etagMatches(ifMatch, version) {
+		return 0, errVersionMismatch
+	}
+	if p.SpaceID != nil {
+		if err := requireLiveSpace(tx, *p.SpaceID); err != nil {
+			return 0, err
+		}
+	}
+
+	// quantity is derived from the ledger: a new value becomes an adjustment movement
+	if p.Quantity != nil {
+		if delta := *p.Quantity - current; delta != 0 {
+			if _, err := applyStockMovement(tx, id, delta, "adjustment", nil, actorID); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// simplu: update full row (ai putea face și patch dinamic, dar nu e obligatoriu acum)
+	err = tx.QueryRow(`
+		UPDATE garage_items
+		SET space_id = COALESCE($1, space_id),
+		    name     = COALESCE($2, name),
+		    notes    = COALESCE($3, notes),
+		    min_quantity     = COALESCE($4, min_quantity),
+		    reorder_quantity = COALESCE($5, reorder_quantity),
+		    purchase_date    = COALESCE($6, purchase_date),
+		    vendor           = COALESCE($7, vendor),
+		    purchase_price   = COALESCE($8, purchase_price),
+		    currency         = COALESCE($9, currency),
+		    serial_number    = COALESCE($10, serial_number),
+		    warranty_expires = COALESCE($11, warranty_expires),
+		    -- a new warranty date deserves a new reminder
+		    warranty_reminded_at = CASE WHEN $11::date IS NULL THEN warranty_reminded_at END,
+		    updated_at = NOW()
+		WHERE id = $12
+		RETURNING version
+	`, p.SpaceID, p.Name, p.Notes, p.MinQuantity, p.ReorderQuantity,
+		p.purchaseDate, p.Vendor, p.PurchasePrice, p.Currency, p.SerialNumber,
+		p.warrantyExpires, id).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	// a raised minimum can put the item below it without any stock moving
+	if p.MinQuantity != nil {
+		if _, err := recordLowStockAlert(tx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return version, nil
+}
+
 func createGarageItem(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var body struct {
-			SpaceID  int64   `json:"space_id"`
-			Name     string  `json:"name"`
-			Quantity int     `json:"quantity"`
-			Notes    *string `json:"notes"`
-		}
+		var body newGarageItem
 		if err := c.BodyParser(&body); err != nil {
 			return fiber.ErrBadRequest
 		}
-		if body.Name == "" || body.SpaceID == 0 {
-			return fiber.NewError(fiber.StatusBadRequest, "space_id and name are required")
-		}
 		if body.Quantity == 0 {
 			body.Quantity = 1
 		}
+		if err := body.validate(); err != nil {
+			return err
+		}
 
-		var id int64
-		err := db.QueryRow(`
-			INSERT INTO garage_items (space_id, name, quantity, notes)
-			VALUES ($1, $2, $3, $4)
-			RETURNING id
-		`, body.SpaceID, body.Name, body.Quantity, body.Notes).Scan(&id)
+		actor := requestActor(c)
+
+		tx, err := db.Begin()
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
+		defer tx.Rollback()
+
+		id, err := insertGarageItem(tx, body, actor.userID)
+		if err != nil {
+			return dbError(err, "")
+		}
+
+		it, err := loadGarageItem(tx, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := actor.record(tx, "item.create", "item", id, nil, it); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := webhooks.Enqueue(tx, "item.created", it); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
 
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": id})
 	}
@@ -176,30 +670,54 @@ func updateGarageItem(db *sql.DB) fiber.Handler {
 			return fiber.ErrBadRequest
 		}
 
-		var body struct {
-			SpaceID  *int64  `json:"space_id"`
-			Name     *string `json:"name"`
-			Quantity *int    `json:"quantity"`
-			Notes    *string `json:"notes"`
-		}
+		var body garageItemPatch
 		if err := c.BodyParser(&body); err != nil {
 			return fiber.ErrBadRequest
 		}
+		if err := body.validate(); err != nil {
+			return err
+		}
+		ifMatch, err := requireIfMatch(c)
+		if err != nil {
+			return err
+		}
+
+		actor := requestActor(c)
 
-		// simplu: update full row (ai putea face și patch dinamic, dar nu e obligatoriu acum)
-		_, err = db.Exec(`
-			UPDATE garage_items
-			SET space_id = COALESCE($1, space_id),
-			    name     = COALESCE($2, name),
-			    quantity = COALESCE($3, quantity),
-			    notes    = COALESCE($4, notes),
-			    updated_at = NOW()
-			WHERE id = $5
-		`, body.SpaceID, body.Name, body.Quantity, body.Notes, id)
+		tx, err := db.Begin()
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
+		defer tx.Rollback()
 
+		before, err := loadGarageItem(tx, id)
+		if err != nil {
+			return dbError(err, "item not found")
+		}
+
+		version, err := updateGarageItemTx(tx, id, body, ifMatch, actor.userID)
+		if err == errVersionMismatch {
+			return fiber.NewError(fiber.StatusPreconditionFailed, err.Error())
+		}
+		if err != nil {
+			return dbError(err, "item not found")
+		}
+
+		after, err := loadGarageItem(tx, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := actor.record(tx, "item.update", "item", id, before, after); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := webhooks.Enqueue(tx, "item.updated", after); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		c.Set(fiber.HeaderETag, etagFor(version))
 		return c.SendStatus(fiber.StatusNoContent)
 	}
 }
@@ -211,12 +729,65 @@ func deleteGarageItem(db *sql.DB) fiber.Handler {
 		if err != nil || id <= 0 {
 			return fiber.ErrBadRequest
 		}
+		ifMatch, err := requireIfMatch(c)
+		if err != nil {
+			return err
+		}
 
-		_, err = db.Exec(`DELETE FROM garage_items WHERE id = $1`, id)
+		tx, err := db.Begin()
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
+		defer tx.Rollback()
+
+		before, err := loadGarageItem(tx, id)
+		if err != nil {
+			return dbError(err, "item not found")
+		}
+
+		// soft delete: the item goes to the trash and can be restored until purged
+		err = trashGarageItem(tx, id, ifMatch)
+		if err == errVersionMismatch {
+			return fiber.NewError(fiber.StatusPreconditionFailed, err.Error())
+		}
+		if err != nil {
+			return dbError(err, "item not found")
+		}
+
+		after, err := loadGarageItem(tx, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := requestActor(c).record(tx, "item.delete", "item", id, before, after); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := webhooks.Enqueue(tx, "item.deleted", after); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
 
 		return c.SendStatus(fiber.StatusNoContent)
 	}
 }
+
+// GET /garage/items/:id   (ETag / If-None-Match aware)
+func getGarageItem(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		it, err := scanGarageItem(db.QueryRow(`SELECT `+garageItemColumns+garageItemFrom+` WHERE i.id = $1 AND i.deleted_at IS NULL`, id))
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "item not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return sendWithETag(c, it.Version, it)
+	}
+}
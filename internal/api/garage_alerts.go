@@ -0,0 +1,165 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+	"github.com/gofiber/fiber/v2"
+)
+
+type GarageAlert struct {
+	ID              int64   `json:"id"`
+	ItemID          int64   `json:"item_id"`
+	ItemName        string  `json:"item_name"`
+	SpaceName       string  `json:"space_name"`
+	Kind            string  `json:"kind"`
+	Quantity        int     `json:"quantity"`     // stock when the alert fired
+	MinQuantity     int     `json:"min_quantity"` // threshold when the alert fired
+	ReorderQuantity *int    `json:"reorder_quantity,omitempty"`
+	CreatedAt       string  `json:"created_at"`
+	NotifiedAt      *string `json:"notified_at,omitempty"`
+	AcknowledgedAt  *string `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy  *int64  `json:"acknowledged_by,omitempty"`
+}
+
+// recordLowStockAlert opens a low_stock alert when the item sits below its
+// min_quantity and queues a stock.low webhook for it. Items that already have
+// an open alert are left alone, so repeated withdrawals don't pile up
+// duplicates. Reports whether a new alert was created.
+func recordLowStockAlert(tx *sql.Tx, itemID int64) (bool, error) {
+	var ev lowStockEvent
+	err := tx.QueryRow(`
+		WITH alert AS (
+			INSERT INTO garage_alerts (item_id, kind, quantity, min_quantity)
+			SELECT id, 'low_stock', quantity, min_quantity
+			FROM garage_items
+			WHERE id = $1 AND deleted_at IS NULL AND min_quantity IS NOT NULL AND quantity < min_quantity
+			ON CONFLICT (item_id) WHERE acknowledged_at IS NULL DO NOTHING
+			RETURNING id, item_id, quantity, min_quantity
+		)
+		SELECT a.id, a.item_id, a.quantity, a.min_quantity, i.reorder_quantity, i.name
+		FROM alert a
+		JOIN garage_items i ON i.id = a.item_id
+	`, itemID).Scan(&ev.AlertID, &ev.ItemID, &ev.Quantity, &ev.MinQuantity, &ev.ReorderQuantity, &ev.ItemName)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := webhooks.Enqueue(tx, "stock.low", ev); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lowStockEvent is the data of a stock.low webhook.
+type lowStockEvent struct {
+	AlertID         int64  `json:"alert_id"`
+	ItemID          int64  `json:"item_id"`
+	ItemName        string `json:"item_name"`
+	Quantity        int    `json:"quantity"`
+	MinQuantity     int    `json:"min_quantity"`
+	ReorderQuantity *int   `json:"reorder_quantity,omitempty"`
+}
+
+// ---------- ALERTS HANDLERS ----------
+
+// GET /garage/alerts?status=open|acknowledged|all
+func listGarageAlerts(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q := `
+			SELECT a.id, a.item_id, i.name, s.name, a.kind, a.quantity, a.min_quantity, i.reorder_quantity,
+			       a.created_at, a.notified_at, a.acknowledged_at, a.acknowledged_by
+			FROM garage_alerts a
+			JOIN garage_items i ON i.id = a.item_id AND i.deleted_at IS NULL
+			JOIN garage_spaces s ON s.id = i.space_id`
+
+		switch c.Query("status", "open") {
+		case "open":
+			q += ` WHERE a.acknowledged_at IS NULL`
+		case "acknowledged":
+			q += ` WHERE a.acknowledged_at IS NOT NULL`
+		case "all":
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "status must be open, acknowledged or all")
+		}
+		q += ` ORDER BY a.created_at DESC, a.id DESC`
+
+		rows, err := db.Query(q)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		alerts := []GarageAlert{}
+		for rows.Next() {
+			var a GarageAlert
+			var created time.Time
+			var notified, acked *time.Time
+
+			if err := rows.Scan(&a.ID, &a.ItemID, &a.ItemName, &a.SpaceName, &a.Kind, &a.Quantity, &a.MinQuantity,
+				&a.ReorderQuantity, &created, &notified, &acked, &a.AcknowledgedBy); err != nil {
+				return fiber.ErrInternalServerError
+			}
+			a.CreatedAt = created.UTC().Format(time.RFC3339)
+			a.NotifiedAt = formatOptionalTime(notified)
+			a.AcknowledgedAt = formatOptionalTime(acked)
+			alerts = append(alerts, a)
+		}
+
+		return c.JSON(alerts)
+	}
+}
+
+// POST /garage/alerts/:id/ack
+func acknowledgeGarageAlert(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		actor := requestActor(c)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		var itemID int64
+		err = tx.QueryRow(`
+			UPDATE garage_alerts
+			SET acknowledged_at = NOW(),
+			    acknowledged_by = $1
+			WHERE id = $2 AND acknowledged_at IS NULL
+			RETURNING item_id
+		`, actor.userID, id).Scan(&itemID)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "alert not found or already acknowledged")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if err := actor.record(tx, "item.acknowledge_alert", "item", itemID, nil, fiber.Map{"alert_id": id}); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.UTC().Format(time.RFC3339)
+	return &s
+}
@@ -0,0 +1,573 @@
+package api
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Garage backups are gzipped tar archives:
+//
+//	manifest.json          format, version, counts
+//	spaces.json            []exportSpace
+//	items.json             []exportItem
+//	movements.json         []exportMovement
+//	attachments.json       []exportAttachment (metadata)
+//	attachments/<id>       one blob per attachment
+//
+// IDs inside the archive are the source instance's; restore remaps them.
+// Users are not part of the backup, so actor/uploader references are dropped.
+// There is no tags section: items have no tags in this schema yet (notes is
+// the closest thing). When they get them, tags.json comes with them; older
+// servers skip files they don't know.
+
+const (
+	backupFormat  = "blaccend-garage"
+	backupVersion = 1
+)
+
+type backupManifest struct {
+	Format     string         `json:"format"`
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Counts     map[string]int `json:"counts"`
+}
+
+type exportSpace struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description,omitempty"`
+	Location    *string   `json:"location,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type exportItem struct {
+	ID              int64     `json:"id"`
+	SpaceID         int64     `json:"space_id"`
+	Name            string    `json:"name"`
+	Quantity        int       `json:"quantity"`
+	Notes           *string   `json:"notes,omitempty"`
+	MinQuantity     *int      `json:"min_quantity,omitempty"`
+	ReorderQuantity *int      `json:"reorder_quantity,omitempty"`
+	UsageHours      float64   `json:"usage_hours"`
+	PurchaseDate    *string   `json:"purchase_date,omitempty"`
+	Vendor          *string   `json:"vendor,omitempty"`
+	PurchasePrice   *float64  `json:"purchase_price,omitempty"`
+	Currency        *string   `json:"currency,omitempty"`
+	SerialNumber    *string   `json:"serial_number,omitempty"`
+	WarrantyExpires *string   `json:"warranty_expires,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type exportMovement struct {
+	ItemID        int64     `json:"item_id"`
+	Delta         int       `json:"delta"`
+	Reason        string    `json:"reason"`
+	Note          *string   `json:"note,omitempty"`
+	QuantityAfter int       `json:"quantity_after"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type exportAttachment struct {
+	ID          int64     `json:"id"`
+	ItemID      int64     `json:"item_id"`
+	Kind        string    `json:"kind"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	SizeBytes   int       `json:"size_bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type RestoreResult struct {
+	Mode        string `json:"mode"`
+	Spaces      int    `json:"spaces"`
+	Items       int    `json:"items"`
+	Movements   int    `json:"movements"`
+	Attachments int    `json:"attachments"`
+}
+
+// ---------- EXPORT ----------
+
+// GET /garage/export
+// Spaces, items, movements and attachments; no tags, since items don't have
+// any yet (see the format above). The JSON sections are small next to the attachments, so they are built
+// first (the manifest needs their counts); blobs are then streamed one by one.
+func exportGarage(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only the garage owner can export backups"); err != nil {
+			return err
+		}
+
+		sections, counts, attachments, err := collectBackupSections(db)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		manifest, err := json.MarshalIndent(backupManifest{
+			Format:     backupFormat,
+			Version:    backupVersion,
+			ExportedAt: time.Now().UTC(),
+			Counts:     counts,
+		}, "", "  ")
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		filename := fmt.Sprintf("garage-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+		c.Set(fiber.HeaderContentType, "application/gzip")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			gz := gzip.NewWriter(w)
+			tw := tar.NewWriter(gz)
+
+			if err := writeBackup(db, tw, manifest, sections, attachments); err != nil {
+				log.Printf("garage export: %v", err)
+				return
+			}
+			if err := tw.Close(); err != nil {
+				log.Printf("garage export: %v", err)
+				return
+			}
+			if err := gz.Close(); err != nil {
+				log.Printf("garage export: %v", err)
+				return
+			}
+			w.Flush()
+		})
+
+		return nil
+	}
+}
+
+type backupSection struct {
+	name string
+	data []byte
+}
+
+func collectBackupSections(db *sql.DB) ([]backupSection, map[string]int, []exportAttachment, error) {
+	counts := map[string]int{}
+
+	spaces := []exportSpace{}
+	rows, err := db.Query(`SELECT id, name, description, location, created_at FROM garage_spaces WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for rows.Next() {
+		var s exportSpace
+		if err := rows.Scan(&s.ID, &s.Name, &s.Description, &s.Location, &s.CreatedAt); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		spaces = append(spaces, s)
+	}
+	rows.Close()
+	counts["spaces"] = len(spaces)
+
+	items := []exportItem{}
+	rows, err = db.Query(`
+		SELECT id, space_id, name, quantity, notes, min_quantity, reorder_quantity, usage_hours,
+		       purchase_date::text, vendor, purchase_price, currency, serial_number, warranty_expires::text,
+		       created_at, updated_at
+		FROM garage_items
+		WHERE deleted_at IS NULL
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for rows.Next() {
+		var it exportItem
+		if err := rows.Scan(&it.ID, &it.SpaceID, &it.Name, &it.Quantity, &it.Notes, &it.MinQuantity, &it.ReorderQuantity,
+			&it.UsageHours, &it.PurchaseDate, &it.Vendor, &it.PurchasePrice, &it.Currency, &it.SerialNumber,
+			&it.WarrantyExpires, &it.CreatedAt, &it.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	counts["items"] = len(items)
+
+	movements := []exportMovement{}
+	rows, err = db.Query(`
+		SELECT m.item_id, m.delta, m.reason, m.note, m.quantity_after, m.created_at
+		FROM stock_movements m
+		JOIN garage_items i ON i.id = m.item_id AND i.deleted_at IS NULL
+		ORDER BY m.id
+	`)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for rows.Next() {
+		var m exportMovement
+		if err := rows.Scan(&m.ItemID, &m.Delta, &m.Reason, &m.Note, &m.QuantityAfter, &m.CreatedAt); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		movements = append(movements, m)
+	}
+	rows.Close()
+	counts["movements"] = len(movements)
+
+	attachments := []exportAttachment{}
+	rows, err = db.Query(`
+		SELECT a.id, a.item_id, a.kind, a.filename, a.content_type, a.size_bytes, a.created_at
+		FROM garage_attachments a
+		JOIN garage_items i ON i.id = a.item_id AND i.deleted_at IS NULL
+		ORDER BY a.id
+	`)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for rows.Next() {
+		var a exportAttachment
+		if err := rows.Scan(&a.ID, &a.ItemID, &a.Kind, &a.Filename, &a.ContentType, &a.SizeBytes, &a.CreatedAt); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	rows.Close()
+	counts["attachments"] = len(attachments)
+
+	sections := []backupSection{}
+	for _, s := range []struct {
+		name string
+		v    any
+	}{
+		{"spaces.json", spaces},
+		{"items.json", items},
+		{"movements.json", movements},
+		{"attachments.json", attachments},
+	} {
+		data, err := json.Marshal(s.v)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sections = append(sections, backupSection{name: s.name, data: data})
+	}
+
+	return sections, counts, attachments, nil
+}
+
+func writeBackup(db *sql.DB, tw *tar.Writer, manifest []byte, sections []backupSection, attachments []exportAttachment) error {
+	now := time.Now().UTC()
+	writeFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := writeFile("manifest.json", manifest); err != nil {
+		return err
+	}
+	for _, s := range sections {
+		if err := writeFile(s.name, s.data); err != nil {
+			return err
+		}
+	}
+
+	// exactly the attachments in attachments.json: live items only, and none
+	// uploaded since; one query per blob keeps at most one in memory
+	for _, a := range attachments {
+		var data []byte
+		err := db.QueryRow(`SELECT data FROM garage_attachments WHERE id = $1`, a.ID).Scan(&data)
+		if err == sql.ErrNoRows {
+			continue // deleted while exporting
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeFile(fmt.Sprintf("attachments/%d", a.ID), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ---------- RESTORE ----------
+
+// unbackedTables hang off items but aren't in the archive, so replacing the
+// garage deletes them. That's fine for history (returned loans, acknowledged
+// alerts, logs of the items being replaced); rows still in use are not.
+var unbackedTables = []struct{ table, active, name string }{
+	{"garage_loans", "returned_at IS NULL", "open loans"},
+	{"garage_reservations", "cancelled_at IS NULL AND ends_at > NOW()", "upcoming reservations"},
+	{"maintenance_plans", "TRUE", "maintenance plans"},
+	{"garage_alerts", "acknowledged_at IS NULL", "open alerts"},
+}
+
+// checkReplaceable refuses a replace restore while any unbackedTables hold
+// active rows. The tables stay locked against writes until tx ends.
+func checkReplaceable(tx *sql.Tx) error {
+	var held []string
+	for _, t := range unbackedTables {
+		if _, err := tx.Exec(`LOCK TABLE ` + t.table + ` IN SHARE MODE`); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM ` + t.table + ` WHERE ` + t.active + `)`).Scan(&exists); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if exists {
+			held = append(held, t.name)
+		}
+	}
+	if len(held) > 0 {
+		return fiber.NewError(fiber.StatusConflict,
+			"replace would delete "+strings.Join(held, ", ")+", which backups don't include; restore with mode=merge instead")
+	}
+	return nil
+}
+
+// POST /garage/restore?mode=merge|replace   (body: the archive, raw or as multipart file=)
+// merge adds everything next to the existing garage under new IDs; replace
+// wipes the current garage first, and is refused while it has open loans,
+// upcoming reservations, maintenance plans or open alerts; their history goes
+// with the replaced items. Either way it's one transaction.
+func restoreGarage(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only the garage owner can restore backups"); err != nil {
+			return err
+		}
+
+		mode := c.Query("mode", "merge")
+		if mode != "merge" && mode != "replace" {
+			return fiber.NewError(fiber.StatusBadRequest, "mode must be merge or replace")
+		}
+
+		var archive io.Reader = bytes.NewReader(c.Body())
+		if fh, err := c.FormFile("file"); err == nil {
+			f, err := fh.Open()
+			if err != nil {
+				return fiber.ErrBadRequest
+			}
+			defer f.Close()
+			archive = f
+		}
+
+		gz, err := gzip.NewReader(archive)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "archive must be a .tar.gz garage export")
+		}
+		defer gz.Close()
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		if mode == "replace" {
+			if err := checkReplaceable(tx); err != nil {
+				return err
+			}
+			// items, movements and attachments all cascade from spaces
+			if _, err := tx.Exec(`DELETE FROM garage_spaces`); err != nil {
+				return fiber.ErrInternalServerError
+			}
+		}
+
+		res, err := restoreBackup(tx, tar.NewReader(gz))
+		if err != nil {
+			var bad errBadBackup
+			if errors.As(err, &bad) {
+				return fiber.NewError(fiber.StatusBadRequest, bad.Error())
+			}
+			log.Printf("garage restore: %v", err)
+			return fiber.ErrInternalServerError
+		}
+		res.Mode = mode
+
+		if err := requestActor(c).recordEvent(tx, "garage.restore", res); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.JSON(res)
+	}
+}
+
+// errBadBackup is a problem with the archive itself rather than the database.
+type errBadBackup struct{ msg string }
+
+func (e errBadBackup) Error() string { return e.msg }
+
+func badBackup(format string, args ...any) error {
+	return errBadBackup{msg: fmt.Sprintf(format, args...)}
+}
+
+// restoreBackup reads the archive in the order exportGarage writes it; each
+// section only refers to IDs from sections before it.
+func restoreBackup(tx *sql.Tx, tr *tar.Reader) (RestoreResult, error) {
+	var res RestoreResult
+	spaceIDs := map[int64]int64{}
+	itemIDs := map[int64]int64{}
+	attachments := map[int64]exportAttachment{}
+	seenManifest := false
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, badBackup("corrupt archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if !seenManifest {
+			if hdr.Name != "manifest.json" {
+				return res, badBackup("manifest.json must be the first file")
+			}
+			var m backupManifest
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return res, badBackup("invalid manifest.json")
+			}
+			if m.Format != backupFormat {
+				return res, badBackup("not a garage export")
+			}
+			if m.Version < 1 || m.Version > backupVersion {
+				return res, badBackup("unsupported backup version %d", m.Version)
+			}
+			seenManifest = true
+			continue
+		}
+
+		switch {
+		case hdr.Name == "spaces.json":
+			var spaces []exportSpace
+			if err := json.NewDecoder(tr).Decode(&spaces); err != nil {
+				return res, badBackup("invalid spaces.json")
+			}
+			for _, s := range spaces {
+				var id int64
+				err := tx.QueryRow(`
+					INSERT INTO garage_spaces (name, description, location, created_at)
+					VALUES ($1, $2, $3, $4)
+					RETURNING id
+				`, s.Name, s.Description, s.Location, s.CreatedAt).Scan(&id)
+				if err != nil {
+					return res, err
+				}
+				spaceIDs[s.ID] = id
+			}
+			res.Spaces = len(spaces)
+
+		case hdr.Name == "items.json":
+			var items []exportItem
+			if err := json.NewDecoder(tr).Decode(&items); err != nil {
+				return res, badBackup("invalid items.json")
+			}
+			for _, it := range items {
+				spaceID, ok := spaceIDs[it.SpaceID]
+				if !ok {
+					return res, badBackup("item %d refers to unknown space %d", it.ID, it.SpaceID)
+				}
+				// quantity is restored as-is; the movements below are its history
+				var id int64
+				err := tx.QueryRow(`
+					INSERT INTO garage_items (space_id, name, quantity, notes, min_quantity, reorder_quantity,
+					                          usage_hours, purchase_date, vendor, purchase_price, currency,
+					                          serial_number, warranty_expires, created_at, updated_at)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8::date, $9, $10, $11, $12, $13::date, $14, $15)
+					RETURNING id
+				`, spaceID, it.Name, it.Quantity, it.Notes, it.MinQuantity, it.ReorderQuantity,
+					it.UsageHours, it.PurchaseDate, it.Vendor, it.PurchasePrice, it.Currency,
+					it.SerialNumber, it.WarrantyExpires, it.CreatedAt, it.UpdatedAt).Scan(&id)
+				if err != nil {
+					return res, err
+				}
+				itemIDs[it.ID] = id
+			}
+			res.Items = len(items)
+
+		case hdr.Name == "movements.json":
+			var movements []exportMovement
+			if err := json.NewDecoder(tr).Decode(&movements); err != nil {
+				return res, badBackup("invalid movements.json")
+			}
+			for _, m := range movements {
+				itemID, ok := itemIDs[m.ItemID]
+				if !ok {
+					return res, badBackup("movement refers to unknown item %d", m.ItemID)
+				}
+				_, err := tx.Exec(`
+					INSERT INTO stock_movements (item_id, delta, reason, note, quantity_after, created_at)
+					VALUES ($1, $2, $3, $4, $5, $6)
+				`, itemID, m.Delta, m.Reason, m.Note, m.QuantityAfter, m.CreatedAt)
+				if err != nil {
+					return res, err
+				}
+			}
+			res.Movements = len(movements)
+
+		case hdr.Name == "attachments.json":
+			var list []exportAttachment
+			if err := json.NewDecoder(tr).Decode(&list); err != nil {
+				return res, badBackup("invalid attachments.json")
+			}
+			for _, a := range list {
+				attachments[a.ID] = a
+			}
+
+		case strings.HasPrefix(hdr.Name, "attachments/"):
+			oldID, err := strconv.ParseInt(strings.TrimPrefix(hdr.Name, "attachments/"), 10, 64)
+			if err != nil {
+				return res, badBackup("unexpected file %s", hdr.Name)
+			}
+			a, ok := attachments[oldID]
+			if !ok {
+				return res, badBackup("%s has no entry in attachments.json", hdr.Name)
+			}
+			itemID, ok := itemIDs[a.ItemID]
+			if !ok {
+				return res, badBackup("attachment %d refers to unknown item %d", a.ID, a.ItemID)
+			}
+			if hdr.Size > maxAttachmentBytes {
+				return res, badBackup("%s is too large", hdr.Name)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return res, badBackup("corrupt archive: %v", err)
+			}
+			_, err = tx.Exec(`
+				INSERT INTO garage_attachments (item_id, kind, filename, content_type, size_bytes, data, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+			`, itemID, a.Kind, a.Filename, a.ContentType, len(data), data, a.CreatedAt)
+			if err != nil {
+				return res, err
+			}
+			res.Attachments++
+
+		default:
+			// files from newer minor additions are ignored rather than rejected
+		}
+	}
+
+	if !seenManifest {
+		return res, badBackup("empty archive")
+	}
+	return res, nil
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const maxBatchOps = 500
+
+// batchOp is one entry of POST /garage/items/batch:
+//
+//	{"op": "create", "item": {"space_id": 1, "name": "Drill"}}
+//	{"op": "update", "id": 7, "version": 4, "item": {"quantity": 3}}
+//	{"op": "move",   "id": 7, "version": 4, "space_id": 2}
+//	{"op": "delete", "id": 7, "version": 4}     (moves it to the trash)
+//
+// version is required on everything but create and must match the item's
+// current version (its ETag), like If-Match on the single-item endpoints.
+// A mismatch fails the batch with 412.
+type batchOp struct {
+	Op      string          `json:"op"`
+	ID      int64           `json:"id"`
+	Version *int            `json:"version"`
+	SpaceID int64           `json:"space_id"`
+	Item    json.RawMessage `json:"item"`
+}
+
+type BatchResult struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	ID      int64  `json:"id,omitempty"`
+	Version int    `json:"version,omitempty"` // after the operation; absent for deletes
+	Status  string `json:"status"`            // ok | failed | rolled_back | not_run
+	Error   string `json:"error,omitempty"`
+}
+
+// errBatchOp is a per-operation failure reported back to the client.
+type errBatchOp struct{ msg string }
+
+func (e errBatchOp) Error() string { return e.msg }
+
+// POST /garage/items/batch   {"operations": [...]}
+// All operations run in one transaction: either every one succeeds, or the
+// first failure is reported and nothing is applied.
+func batchGarageItems(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			Operations []batchOp `json:"operations"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		if len(body.Operations) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "operations is required")
+		}
+		if len(body.Operations) > maxBatchOps {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("at most %d operations per batch", maxBatchOps))
+		}
+
+		actor := requestActor(c)
+
+		results := make([]BatchResult, len(body.Operations))
+		for i, op := range body.Operations {
+			results[i] = BatchResult{Index: i, Op: op.Op, ID: op.ID, Status: "not_run"}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		for i, op := range body.Operations {
+			id, version, err := applyBatchOp(tx, op, actor)
+			if err != nil {
+				status := fiber.StatusUnprocessableEntity
+				var opErr errBatchOp
+				switch {
+				case err == errVersionMismatch:
+					status = fiber.StatusPreconditionFailed
+					opErr = errBatchOp{err.Error()}
+				case !errors.As(err, &opErr):
+					return fiber.ErrInternalServerError
+				}
+				results[i].Status = "failed"
+				results[i].Error = opErr.msg
+				for j := 0; j < i; j++ {
+					results[j].Status = "rolled_back"
+				}
+				return c.Status(status).JSON(fiber.Map{"applied": false, "results": results})
+			}
+			results[i].ID = id
+			results[i].Version = version
+			results[i].Status = "ok"
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.JSON(fiber.Map{"applied": true, "results": results})
+	}
+}
+
+// webhook event each batch op fires
+var itemWebhookEvents = map[string]string{
+	"create": "item.created",
+	"update": "item.updated",
+	"move":   "item.updated",
+	"delete": "item.deleted",
+}
+
+// applyBatchOp runs one operation and returns the id and new version of the
+// item it touched. Problems the client can fix come back as errBatchOp, a
+// stale version as errVersionMismatch.
+func applyBatchOp(tx *sql.Tx, op batchOp, actor auditActor) (int64, int, error) {
+	if _, ok := itemWebhookEvents[op.Op]; !ok {
+		return 0, 0, errBatchOp{"op must be create, update, move or delete"}
+	}
+	ifMatch := ""
+	if op.Op != "create" {
+		if op.ID <= 0 {
+			return 0, 0, errBatchOp{"id is required"}
+		}
+		if op.Version == nil {
+			return 0, 0, errBatchOp{"version is required"}
+		}
+		ifMatch = etagFor(*op.Version)
+	}
+
+	var before *GarageItem
+	if op.Op != "create" {
+		it, err := loadGarageItem(tx, op.ID)
+		if err == sql.ErrNoRows {
+			return 0, 0, errBatchOp{"item not found"}
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if it.Version != *op.Version {
+			return 0, 0, errVersionMismatch
+		}
+		before = &it
+	}
+
+	var err error
+	id := op.ID
+	action := "item." + op.Op
+	switch op.Op {
+	case "create":
+		var it newGarageItem
+		if len(op.Item) == 0 || json.Unmarshal(op.Item, &it) != nil {
+			return 0, 0, errBatchOp{"item must be an object"}
+		}
+		if it.Quantity == 0 {
+			it.Quantity = 1
+		}
+		if err := it.validate(); err != nil {
+			return 0, 0, errBatchOp{err.Error()}
+		}
+		id, err = insertGarageItem(tx, it, actor.userID)
+
+	case "update":
+		var p garageItemPatch
+		if len(op.Item) == 0 || json.Unmarshal(op.Item, &p) != nil {
+			return 0, 0, errBatchOp{"item must be an object"}
+		}
+		if err := p.validate(); err != nil {
+			return 0, 0, errBatchOp{err.Error()}
+		}
+		_, err = updateGarageItemTx(tx, op.ID, p, ifMatch, actor.userID)
+
+	case "move":
+		if op.SpaceID <= 0 {
+			return 0, 0, errBatchOp{"space_id is required"}
+		}
+		action = "item.update"
+		_, err = updateGarageItemTx(tx, op.ID, garageItemPatch{SpaceID: &op.SpaceID}, ifMatch, actor.userID)
+
+	case "delete":
+		err = trashGarageItem(tx, op.ID, ifMatch)
+
+	default:
+		return 0, 0, errBatchOp{"op must be create, update, move or delete"}
+	}
+
+	if err == errVersionMismatch {
+		return 0, 0, err
+	}
+	if err != nil {
+		return 0, 0, batchOpError(err)
+	}
+
+	// read back: a create's opening stock movement has already bumped the version
+	after, err := loadGarageItem(tx, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	var b any
+	if before != nil {
+		b = *before
+	}
+	if err := actor.record(tx, action, "item", id, b, after); err != nil {
+		return 0, 0, err
+	}
+	if err := webhooks.Enqueue(tx, itemWebhookEvents[op.Op], after); err != nil {
+		return 0, 0, err
+	}
+	if op.Op == "delete" {
+		return id, 0, nil
+	}
+	return id, after.Version, nil
+}
+
+// batchOpError turns the errors a client can fix into errBatchOp.
+func batchOpError(err error) error {
+	var pgErr *pgconn.PgError
+	var ve *ValidationError
+	switch {
+	case err == sql.ErrNoRows:
+		return errBatchOp{"item not found"}
+	case err == errInsufficientStock, err == errStockLentOut, err == errVersionMismatch:
+		return errBatchOp{err.Error()}
+	case errors.As(err, &ve):
+		return errBatchOp{ve.Error()}
+	case errors.As(err, &pgErr) && pgErr.Code == "23503":
+		return errBatchOp{"space not found"}
+	case errors.As(err, &pgErr) && pgErr.Code == "23514":
+		return errBatchOp{"value out of range: " + pgErr.ConstraintName}
+	}
+	return err
+}
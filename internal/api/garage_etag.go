@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Items and spaces carry a version column (bumped by a trigger on every
+// UPDATE); its value is the resource's ETag. Writes must send it back in
+// If-Match so two people editing the same item can't clobber each other.
+
+var errVersionMismatch = errors.New("the resource was modified by someone else")
+
+func etagFor(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// etagMatches compares an If-Match header with version. It accepts "*" and
+// comma-separated lists; weak tags (W/"3") never match, since If-Match uses
+// the strong comparison (RFC 9110 13.1.1).
+func etagMatches(header string, version int) bool {
+	return matchETag(header, version, false)
+}
+
+// etagNotModified reports whether an If-None-Match header lists version,
+// i.e. the client's copy is current. Weak tags count here.
+func etagNotModified(header string, version int) bool {
+	return matchETag(header, version, true)
+}
+
+func matchETag(header string, version int, weak bool) bool {
+	want := etagFor(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// requireIfMatch returns the If-Match header, or 428 when it's missing.
+func requireIfMatch(c *fiber.Ctx) (string, error) {
+	h := c.Get(fiber.HeaderIfMatch)
+	if h == "" {
+		return "", fiber.NewError(fiber.StatusPreconditionRequired, "If-Match header with the item's ETag is required")
+	}
+	return h, nil
+}
+
+// sendWithETag answers a GET: 304 when the client's copy is current,
+// otherwise the body with its ETag.
+func sendWithETag(c *fiber.Ctx, version int, body any) error {
+	c.Set(fiber.HeaderETag, etagFor(version))
+	if h := c.Get(fiber.HeaderIfNoneMatch); h != "" && etagNotModified(h, version) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return c.JSON(body)
+}
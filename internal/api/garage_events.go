@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/changelog"
+	"github.com/BlaccStacc/blaccend/internal/security"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Live item/space changes as Server-Sent Events. The garage is shared, so
+// every signed-in user gets every change. Each event carries the change's
+// seq as its id; reconnecting with Last-Event-ID replays what was missed
+// from the change log. Events only say what changed (type, id, version);
+// clients fetch the record if they need it.
+
+const eventStreamPing = 25 * time.Second
+
+// eventStreamAuth accepts the usual Bearer header or, for EventSource (which
+// can't set headers), ?token= from GET /garage/events/url.
+func eventStreamAuth(db *sql.DB) fiber.Handler {
+	bearer := AuthMiddleware(db)
+	return func(c *fiber.Ctx) error {
+		token := c.Query("token")
+		if token == "" {
+			return bearer(c)
+		}
+
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return c.Status(500).JSON(fiber.Map{"error": "JWT misconfigured"})
+		}
+		claims, err := security.ParseJWT(token, secret)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid stream token"})
+		}
+		if typ, _ := claims["typ"].(string); typ != "events" {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid token type"})
+		}
+		if !sessionCurrent(db, claims) {
+			return c.Status(401).JSON(fiber.Map{"error": "session revoked"})
+		}
+		c.Locals("user", claims)
+		return c.Next()
+	}
+}
+
+// GET /garage/events/url   -> { "url": ".../garage/events?token=..." } for EventSource
+func garageEventStreamURL() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("user").(jwt.MapClaims)
+		if !ok {
+			return fiber.ErrUnauthorized
+		}
+
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return fiber.NewError(fiber.StatusInternalServerError, "JWT misconfigured")
+		}
+
+		token, err := security.SignJWT(jwt.MapClaims{
+			"user_id": claims["user_id"],
+			"sv":      claims["sv"],
+			"typ":     "events",
+			"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		}, secret)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.JSON(fiber.Map{
+			"url": fmt.Sprintf("%s/garage/events?token=%s", c.BaseURL(), token),
+		})
+	}
+}
+
+// GET /garage/events   (text/event-stream; Last-Event-ID or ?last_event_id= to resume)
+// Event names are the change types ("item.updated", "space.deleted"...).
+// "reset" means the log no longer reaches back to Last-Event-ID: refetch
+// everything, then keep reading.
+func garageEventStream(db *sql.DB, hub *changelog.Hub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := currentUserID(c); !ok {
+			return fiber.ErrUnauthorized
+		}
+
+		resume := c.Get("Last-Event-ID", c.Query("last_event_id"))
+		var after int64
+		if resume != "" {
+			n, err := strconv.ParseInt(resume, 10, 64)
+			if err != nil || n < 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid Last-Event-ID")
+			}
+			after = n
+		}
+
+		// subscribe before reading the log so nothing falls in between
+		updates, stop := hub.Subscribe()
+		if resume == "" {
+			head, err := changelog.Head(c.Context(), db)
+			if err != nil {
+				stop()
+				return fiber.ErrInternalServerError
+			}
+			after = head
+		}
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		c.Set("X-Accel-Buffering", "no") // nginx: don't buffer the stream
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer stop()
+			streamChanges(w, db, updates, after)
+		})
+		return nil
+	}
+}
+
+// streamChanges replays the log after seq, then forwards live changes until
+// the client goes away or the hub drops it.
+func streamChanges(w *bufio.Writer, db *sql.DB, updates <-chan changelog.Change, seq int64) {
+	ctx := context.Background()
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for {
+		changes, err := changelog.Since(ctx, db, seq, 500)
+		if err == changelog.ErrTooOld {
+			if seq, err = changelog.Head(ctx, db); err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", seq)
+			break
+		}
+		if err != nil {
+			return
+		}
+		for _, ch := range changes {
+			writeChangeEvent(w, ch)
+			seq = ch.Seq
+		}
+		if w.Flush() != nil {
+			return
+		}
+		if len(changes) < 500 {
+			break
+		}
+	}
+
+	ping := time.NewTicker(eventStreamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case ch, ok := <-updates:
+			if !ok {
+				return // fell behind; the client reconnects with Last-Event-ID
+			}
+			if ch.Seq <= seq {
+				continue // already replayed from the log
+			}
+			writeChangeEvent(w, ch)
+			seq = ch.Seq
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func writeChangeEvent(w *bufio.Writer, ch changelog.Change) {
+	data, _ := json.Marshal(ch)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ch.Seq, ch.Type, data)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// iCalendar (RFC 5545) output for the reservations feed.
+
+const icsTimeFormat = "20060102T150405Z"
+
+func buildReservationsICS(reservations []GarageReservation, host string) string {
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//Blaccend//Garage Reservations//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:Garage reservations")
+
+	stamp := time.Now().UTC().Format(icsTimeFormat)
+	for _, r := range reservations {
+		summary := r.ItemName
+		if r.Quantity > 1 {
+			summary = fmt.Sprintf("%s x%d", r.ItemName, r.Quantity)
+		}
+		if r.ReservedByUsername != nil && *r.ReservedByUsername != "" {
+			summary += " (" + *r.ReservedByUsername + ")"
+		}
+
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, fmt.Sprintf("UID:reservation-%d@%s", r.ID, host))
+		writeICSLine(&b, "DTSTAMP:"+stamp)
+		writeICSLine(&b, "DTSTART:"+icsTime(r.StartsAt))
+		writeICSLine(&b, "DTEND:"+icsTime(r.EndsAt))
+		writeICSLine(&b, "SUMMARY:"+escapeICSText(summary))
+		if r.Note != nil && *r.Note != "" {
+			writeICSLine(&b, "DESCRIPTION:"+escapeICSText(*r.Note))
+		}
+		writeICSLine(&b, "STATUS:CONFIRMED")
+		writeICSLine(&b, "END:VEVENT")
+	}
+
+	writeICSLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// icsTime converts the RFC3339 strings used in the API structs to UTC basic format.
+func icsTime(s string) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(icsTimeFormat)
+}
+
+func escapeICSText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// writeICSLine folds content lines longer than 75 octets as the RFC requires,
+// without splitting a UTF-8 sequence.
+func writeICSLine(b *strings.Builder, line string) {
+	max := 75
+	for len(line) > max {
+		cut := max
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		max = 74 // continuation lines start with a space
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
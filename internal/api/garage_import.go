@@ -0,0 +1,830 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/security"
+	"github.com/BlaccStacc/blaccend/internal/services"
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+	"github.com/gofiber/fiber/v2"
+)
+
+// importTargets are the fields an import row can fill. A mapping renames the
+// source column for any of them; unmapped targets are looked up under their own name.
+var importTargets = []string{
+	"space", "name", "quantity", "notes", "min_quantity", "reorder_quantity",
+	"purchase_date", "vendor", "purchase_price", "currency", "serial_number", "warranty_expires",
+}
+
+const (
+	maxImportRows = 50000
+	// imports up to this size run in one transaction; bigger ones become a job
+	importSyncRows = 1000
+	// a job imports this many rows per run of its import.chunk job
+	importChunkRows = 500
+)
+
+var importDuplicateModes = map[string]bool{"skip": true, "update": true, "error": true}
+
+// importRecord is one source row after the column mapping, keyed by target field.
+type importRecord struct {
+	Line   int               `json:"line"`
+	Fields map[string]string `json:"fields"`
+}
+
+// importRow is a validated record.
+type importRow struct {
+	Line     int
+	Space    string
+	Quantity *int // nil when the column is empty: 1 for new items, untouched on update
+	Item     newGarageItem
+}
+
+type ImportRowError struct {
+	Row    int      `json:"row"`
+	Errors []string `json:"errors"`
+}
+
+type ImportResult struct {
+	Rows          int      `json:"rows"`
+	Created       int      `json:"created"`
+	Updated       int      `json:"updated"`
+	Skipped       int      `json:"skipped"`
+	SpacesCreated []string `json:"spaces_created"`
+}
+
+type ImportJob struct {
+	ID            string  `json:"id"`
+	Status        string  `json:"status"` // pending | running | failed | done
+	OnDuplicate   string  `json:"on_duplicate"`
+	TotalRows     int     `json:"total_rows"`
+	ProcessedRows int     `json:"processed_rows"`
+	Created       int     `json:"created"`
+	Updated       int     `json:"updated"`
+	Skipped       int     `json:"skipped"`
+	Error         *string `json:"error,omitempty"`
+	CreatedBy     *int64  `json:"created_by,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+	UpdatedAt     string  `json:"updated_at"`
+}
+
+// errDuplicateItem is returned for an existing name + space when on_duplicate=error.
+type errDuplicateItem struct {
+	line        int
+	space, name string
+}
+
+func (e errDuplicateItem) Error() string {
+	return fmt.Sprintf("row %d: item %q already exists in space %q", e.line, e.name, e.space)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// ---------- PARSING ----------
+
+// readImportRecords decodes a CSV (header row required) or a JSON array of
+// objects and applies the mapping (target field -> source column).
+func readImportRecords(data []byte, format string, mapping map[string]string) ([]importRecord, error) {
+	for target := range mapping {
+		if !isImportTarget(target) {
+			return nil, fmt.Errorf("unknown mapping target %q", target)
+		}
+	}
+
+	var sourceRows []map[string]string
+	firstLine := 1
+	switch format {
+	case "csv":
+		r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		header, err := r.Read()
+		if err == io.EOF {
+			return nil, errors.New("empty CSV")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		for i := range header {
+			header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+		}
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV: %v", err)
+			}
+			row := map[string]string{}
+			for i, v := range rec {
+				if i < len(header) {
+					row[header[i]] = v
+				}
+			}
+			sourceRows = append(sourceRows, row)
+		}
+		firstLine = 2 // line 1 is the header
+
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var objects []map[string]any
+		if err := dec.Decode(&objects); err != nil {
+			return nil, errors.New("JSON body must be an array of objects")
+		}
+		for _, obj := range objects {
+			row := map[string]string{}
+			for k, v := range obj {
+				switch v := v.(type) {
+				case nil:
+				case string:
+					row[strings.ToLower(k)] = v
+				default:
+					row[strings.ToLower(k)] = fmt.Sprint(v)
+				}
+			}
+			sourceRows = append(sourceRows, row)
+		}
+
+	default:
+		return nil, errors.New("format must be csv or json")
+	}
+
+	if len(sourceRows) > maxImportRows {
+		return nil, fmt.Errorf("at most %d rows per import", maxImportRows)
+	}
+
+	records := make([]importRecord, 0, len(sourceRows))
+	for i, src := range sourceRows {
+		fields := map[string]string{}
+		for _, target := range importTargets {
+			source := target
+			if m, ok := mapping[target]; ok {
+				source = strings.ToLower(strings.TrimSpace(m))
+			}
+			if v := strings.TrimSpace(src[source]); v != "" {
+				fields[target] = v
+			}
+		}
+		records = append(records, importRecord{Line: firstLine + i, Fields: fields})
+	}
+	return records, nil
+}
+
+func isImportTarget(s string) bool {
+	for _, t := range importTargets {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseImportRecord validates one record; all problems are reported, not just the first.
+func parseImportRecord(rec importRecord) (importRow, []string) {
+	row := importRow{Line: rec.Line}
+	var problems []string
+	f := rec.Fields
+
+	optString := func(key string) *string {
+		if v, ok := f[key]; ok {
+			return &v
+		}
+		return nil
+	}
+	optInt := func(key string, min int) *int {
+		v, ok := f[key]
+		if !ok {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min {
+			problems = append(problems, fmt.Sprintf("%s must be a whole number >= %d", key, min))
+			return nil
+		}
+		return &n
+	}
+
+	row.Space = f["space"]
+	if row.Space == "" {
+		problems = append(problems, "space is required")
+	}
+	row.Item.Name = f["name"]
+	if row.Item.Name == "" {
+		problems = append(problems, "name is required")
+	}
+	row.Quantity = optInt("quantity", 0)
+	row.Item.Notes = optString("notes")
+	row.Item.MinQuantity = optInt("min_quantity", 0)
+	row.Item.ReorderQuantity = optInt("reorder_quantity", 1)
+
+	p := &row.Item.purchaseInfo
+	p.PurchaseDate = optString("purchase_date")
+	p.Vendor = optString("vendor")
+	p.Currency = optString("currency")
+	p.SerialNumber = optString("serial_number")
+	p.WarrantyExpires = optString("warranty_expires")
+	if v, ok := f["purchase_price"]; ok {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			problems = append(problems, "purchase_price must be a number")
+		} else {
+			p.PurchasePrice = &price
+		}
+	}
+	var v validator
+	v.maxLen(&row.Item.Name, maxShortText, "name")
+	v.maxLen(row.Item.Notes, maxLongText, "notes")
+	p.parse(&v)
+	for _, field := range importTargets {
+		if msg, ok := v.fields[field]; ok {
+			problems = append(problems, field+" "+msg)
+		}
+	}
+
+	return row, problems
+}
+
+func parseImportRecords(records []importRecord) ([]importRow, []ImportRowError) {
+	rows := make([]importRow, 0, len(records))
+	rowErrors := []ImportRowError{}
+	for _, rec := range records {
+		row, problems := parseImportRecord(rec)
+		if len(problems) > 0 {
+			rowErrors = append(rowErrors, ImportRowError{Row: rec.Line, Errors: problems})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors
+}
+
+// ---------- APPLYING ----------
+
+func findSpaceByName(q queryRower, name string) (int64, bool, error) {
+	var id int64
+	err := q.QueryRow(`
+		SELECT id FROM garage_spaces WHERE lower(name) = lower($1) AND deleted_at IS NULL ORDER BY id LIMIT 1
+	`, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+func findItemByName(q queryRower, spaceID int64, name string) (int64, bool, error) {
+	var id int64
+	err := q.QueryRow(`
+		SELECT id FROM garage_items
+		WHERE space_id = $1 AND lower(name) = lower($2) AND deleted_at IS NULL
+		ORDER BY id LIMIT 1
+	`, spaceID, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// applyImportRows writes rows inside tx. Spaces are created on first use and
+// items already present in the same space (by name) follow onDuplicate.
+func applyImportRows(tx *sql.Tx, rows []importRow, onDuplicate string, actor auditActor) (ImportResult, error) {
+	res := ImportResult{Rows: len(rows), SpacesCreated: []string{}}
+	spaces := map[string]int64{}
+
+	for _, row := range rows {
+		key := strings.ToLower(row.Space)
+		spaceID, ok := spaces[key]
+		if !ok {
+			id, found, err := findSpaceByName(tx, row.Space)
+			if err != nil {
+				return res, err
+			}
+			if !found {
+				if err := tx.QueryRow(`
+					INSERT INTO garage_spaces (name) VALUES ($1) RETURNING id
+				`, row.Space).Scan(&id); err != nil {
+					return res, err
+				}
+				s, err := loadGarageSpace(tx, id)
+				if err != nil {
+					return res, err
+				}
+				if err := actor.record(tx, "space.create", "space", id, nil, s); err != nil {
+					return res, err
+				}
+				res.SpacesCreated = append(res.SpacesCreated, row.Space)
+			}
+			spaces[key] = id
+			spaceID = id
+		}
+
+		existing, found, err := findItemByName(tx, spaceID, row.Item.Name)
+		if err != nil {
+			return res, err
+		}
+
+		switch {
+		case !found:
+			it := row.Item
+			it.SpaceID = spaceID
+			it.Quantity = 1
+			if row.Quantity != nil {
+				it.Quantity = *row.Quantity
+			}
+			id, err := insertGarageItem(tx, it, actor.userID)
+			if err != nil {
+				return res, fmt.Errorf("row %d: %w", row.Line, err)
+			}
+			after, err := loadGarageItem(tx, id)
+			if err != nil {
+				return res, err
+			}
+			if err := actor.record(tx, "item.create", "item", id, nil, after); err != nil {
+				return res, err
+			}
+			if err := webhooks.Enqueue(tx, "item.created", after); err != nil {
+				return res, err
+			}
+			res.Created++
+
+		case onDuplicate == "update":
+			patch := garageItemPatch{
+				Quantity:        row.Quantity,
+				Notes:           row.Item.Notes,
+				MinQuantity:     row.Item.MinQuantity,
+				ReorderQuantity: row.Item.ReorderQuantity,
+				purchaseInfo:    row.Item.purchaseInfo,
+			}
+			before, err := loadGarageItem(tx, existing)
+			if err != nil {
+				return res, err
+			}
+			if _, err := updateGarageItemTx(tx, existing, patch, "", actor.userID); err != nil {
+				return res, fmt.Errorf("row %d: %w", row.Line, err)
+			}
+			after, err := loadGarageItem(tx, existing)
+			if err != nil {
+				return res, err
+			}
+			if err := actor.record(tx, "item.update", "item", existing, before, after); err != nil {
+				return res, err
+			}
+			if err := webhooks.Enqueue(tx, "item.updated", after); err != nil {
+				return res, err
+			}
+			res.Updated++
+
+		case onDuplicate == "error":
+			return res, errDuplicateItem{line: row.Line, space: row.Space, name: row.Item.Name}
+
+		default:
+			res.Skipped++
+		}
+	}
+
+	return res, nil
+}
+
+// previewImport is the dry run: same decisions as applyImportRows, nothing written.
+func previewImport(db *sql.DB, rows []importRow, onDuplicate string) (ImportResult, []ImportRowError, error) {
+	res := ImportResult{Rows: len(rows), SpacesCreated: []string{}}
+	rowErrors := []ImportRowError{}
+	spaces := map[string]int64{} // 0 = will be created
+	seen := map[string]bool{}    // space + name already in this file
+
+	for _, row := range rows {
+		spaceKey := strings.ToLower(row.Space)
+		spaceID, ok := spaces[spaceKey]
+		if !ok {
+			id, found, err := findSpaceByName(db, row.Space)
+			if err != nil {
+				return res, nil, err
+			}
+			if !found {
+				res.SpacesCreated = append(res.SpacesCreated, row.Space)
+			}
+			spaces[spaceKey] = id
+			spaceID = id
+		}
+
+		itemKey := spaceKey + "\x00" + strings.ToLower(row.Item.Name)
+		duplicate := seen[itemKey]
+		if !duplicate && spaceID != 0 {
+			_, found, err := findItemByName(db, spaceID, row.Item.Name)
+			if err != nil {
+				return res, nil, err
+			}
+			duplicate = found
+		}
+		seen[itemKey] = true
+
+		switch {
+		case !duplicate:
+			res.Created++
+		case onDuplicate == "update":
+			res.Updated++
+		case onDuplicate == "error":
+			rowErrors = append(rowErrors, ImportRowError{
+				Row:    row.Line,
+				Errors: []string{fmt.Sprintf("item %q already exists in space %q", row.Item.Name, row.Space)},
+			})
+		default:
+			res.Skipped++
+		}
+	}
+
+	return res, rowErrors, nil
+}
+
+// ---------- HANDLERS ----------
+
+// POST /garage/import?format=csv|json&dry_run=true&on_duplicate=skip|update|error
+// Body is the raw file, or multipart with file=<file> and optional
+// format / mapping fields. mapping is JSON: {"name": "Item", "space": "Room"}.
+func importGarageItems(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		format := strings.ToLower(c.Query("format"))
+		mappingJSON := c.Query("mapping")
+		var data []byte
+
+		if fh, err := c.FormFile("file"); err == nil {
+			f, err := fh.Open()
+			if err != nil {
+				return fiber.ErrBadRequest
+			}
+			defer f.Close()
+			if data, err = io.ReadAll(f); err != nil {
+				return fiber.ErrBadRequest
+			}
+			if v := c.FormValue("format"); v != "" {
+				format = strings.ToLower(v)
+			}
+			if format == "" && strings.HasSuffix(strings.ToLower(fh.Filename), ".csv") {
+				format = "csv"
+			}
+			if v := c.FormValue("mapping"); v != "" {
+				mappingJSON = v
+			}
+		} else {
+			data = c.Body()
+		}
+
+		if format == "" {
+			format = "json"
+			if strings.HasPrefix(c.Get(fiber.HeaderContentType), "text/csv") {
+				format = "csv"
+			}
+		}
+
+		mapping := map[string]string{}
+		if mappingJSON != "" {
+			if err := json.Unmarshal([]byte(mappingJSON), &mapping); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "mapping must be a JSON object of target field to source column")
+			}
+		}
+
+		onDuplicate := c.Query("on_duplicate", "skip")
+		if !importDuplicateModes[onDuplicate] {
+			return fiber.NewError(fiber.StatusBadRequest, "on_duplicate must be skip, update or error")
+		}
+		dryRun := c.QueryBool("dry_run", false)
+
+		records, err := readImportRecords(data, format, mapping)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if len(records) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "nothing to import")
+		}
+
+		rows, rowErrors := parseImportRecords(records)
+
+		if dryRun {
+			res, dupErrors, err := previewImport(db, rows, onDuplicate)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			rowErrors = append(rowErrors, dupErrors...)
+			return c.JSON(fiber.Map{
+				"dry_run": true,
+				"valid":   len(rowErrors) == 0,
+				"result":  res,
+				"errors":  rowErrors,
+			})
+		}
+
+		// all or nothing: a file with bad rows is rejected before touching the DB
+		if len(rowErrors) > 0 {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"errors": rowErrors})
+		}
+
+		actor := requestActor(c)
+
+		if len(records) > importSyncRows {
+			// chunks commit one by one, so a duplicate found halfway would
+			// leave the file half imported; check the whole file first
+			if onDuplicate == "error" {
+				_, dupErrors, err := previewImport(db, rows, onDuplicate)
+				if err != nil {
+					return fiber.ErrInternalServerError
+				}
+				if len(dupErrors) > 0 {
+					return c.Status(fiber.StatusConflict).JSON(fiber.Map{"errors": dupErrors})
+				}
+			}
+
+			job, err := createImportJob(db, records, onDuplicate, actor.userID)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			return c.Status(fiber.StatusAccepted).JSON(job)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		res, err := applyImportRows(tx, rows, onDuplicate, actor)
+		var dup errDuplicateItem
+		if errors.As(err, &dup) {
+			return fiber.NewError(fiber.StatusConflict, dup.Error())
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.JSON(res)
+	}
+}
+
+// GET /garage/import/:id
+func getImportJob(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		job, err := loadOwnImportJob(c, db)
+		if err != nil {
+			return err
+		}
+		return c.JSON(job)
+	}
+}
+
+// POST /garage/import/:id/resume
+// Restarts a failed job from its last committed chunk. Rows of earlier chunks
+// are never read again; with on_duplicate=error the file was checked before
+// the job started, so a duplicate here is an item created since, and the job
+// fails again until it is renamed or removed.
+func resumeImportJob(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		job, err := loadOwnImportJob(c, db)
+		if err != nil {
+			return err
+		}
+		switch job.Status {
+		case "done":
+			return fiber.NewError(fiber.StatusConflict, "import job already finished")
+		case "pending", "running":
+			return fiber.NewError(fiber.StatusConflict, "import job is still running")
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		// only one resume wins when two race
+		res, err := tx.Exec(`
+			UPDATE import_jobs SET status = 'pending', error = NULL, updated_at = NOW()
+			WHERE id = $1 AND status = 'failed'
+		`, job.ID)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fiber.NewError(fiber.StatusConflict, "import job is still running")
+		}
+		if err := services.Enqueue(tx, importChunkJob, importChunkPayload{ID: job.ID}); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		job.Status = "pending"
+		job.Error = nil
+		return c.Status(fiber.StatusAccepted).JSON(job)
+	}
+}
+
+// loadOwnImportJob loads the :id job for the user who started it, or for an
+// owner.
+func loadOwnImportJob(c *fiber.Ctx, db *sql.DB) (ImportJob, error) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return ImportJob{}, fiber.ErrUnauthorized
+	}
+
+	job, err := loadImportJob(db, c.Params("id"))
+	if err == sql.ErrNoRows {
+		return job, fiber.NewError(fiber.StatusNotFound, "import job not found")
+	}
+	if err != nil {
+		return job, fiber.ErrInternalServerError
+	}
+
+	if job.CreatedBy == nil || *job.CreatedBy != uid {
+		owner, err := isGarageOwner(db, uid)
+		if err != nil {
+			return job, fiber.ErrInternalServerError
+		}
+		if !owner {
+			return job, fiber.NewError(fiber.StatusForbidden, "only whoever started the import or an owner can see it")
+		}
+	}
+	return job, nil
+}
+
+// ---------- JOBS ----------
+
+// Big imports run on the job queue, one chunk of importChunkRows per
+// import.chunk job. Each chunk commits together with the job's progress and
+// the job for the next chunk, so a crash loses nothing and applies nothing
+// twice.
+
+const importChunkJob = "import.chunk"
+
+// importChunkPayload is the payload of an import.chunk job.
+type importChunkPayload struct {
+	ID string `json:"id"`
+}
+
+// RegisterJobs registers the handlers for the jobs the API queues.
+func RegisterJobs(r *services.Runner, db *sql.DB) {
+	r.Handle(importChunkJob, func(ctx context.Context, job services.Job) error {
+		var p importChunkPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return err
+		}
+		return runImportChunk(ctx, db, p.ID)
+	})
+}
+
+func createImportJob(db *sql.DB, records []importRecord, onDuplicate string, actorID *int64) (ImportJob, error) {
+	id, err := security.NewURLSafeToken(12)
+	if err != nil {
+		return ImportJob{}, err
+	}
+	payload, err := json.Marshal(records)
+	if err != nil {
+		return ImportJob{}, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return ImportJob{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO import_jobs (id, on_duplicate, records, total_rows, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+	`, id, onDuplicate, payload, len(records), actorID)
+	if err != nil {
+		return ImportJob{}, err
+	}
+	if err := services.Enqueue(tx, importChunkJob, importChunkPayload{ID: id}); err != nil {
+		return ImportJob{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ImportJob{}, err
+	}
+
+	return loadImportJob(db, id)
+}
+
+func loadImportJob(db *sql.DB, id string) (ImportJob, error) {
+	var j ImportJob
+	var created, updated time.Time
+	err := db.QueryRow(`
+		SELECT id, status, on_duplicate, total_rows, processed_rows, created_count, updated_count,
+		       skipped_count, error, created_by, created_at, updated_at
+		FROM import_jobs
+		WHERE id = $1
+	`, id).Scan(&j.ID, &j.Status, &j.OnDuplicate, &j.TotalRows, &j.ProcessedRows, &j.Created, &j.Updated,
+		&j.Skipped, &j.Error, &j.CreatedBy, &created, &updated)
+	if err != nil {
+		return j, err
+	}
+	j.CreatedAt = created.UTC().Format(time.RFC3339)
+	j.UpdatedAt = updated.UTC().Format(time.RFC3339)
+	return j, nil
+}
+
+// runImportChunk imports the job's next chunk. A chunk that fails fails the
+// job, which then waits for a resume; the queue only retries when the
+// failure couldn't even be recorded.
+func runImportChunk(ctx context.Context, db *sql.DB, id string) error {
+	cause := importChunk(ctx, db, id)
+	if cause == nil {
+		return nil
+	}
+
+	log.Printf("import job %s: %v", id, cause)
+	_, err := db.ExecContext(ctx, `
+		UPDATE import_jobs SET status = 'failed', error = $2, updated_at = NOW() WHERE id = $1
+	`, id, cause.Error())
+	return err
+}
+
+func importChunk(ctx context.Context, db *sql.DB, id string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the row lock keeps a second run of the same job waiting here
+	var payload []byte
+	var status, onDuplicate string
+	var processed int
+	var createdBy *int64
+	err = tx.QueryRow(`
+		SELECT status, records, on_duplicate, processed_rows, created_by FROM import_jobs WHERE id = $1 FOR UPDATE
+	`, id).Scan(&status, &payload, &onDuplicate, &processed, &createdBy)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != "pending" && status != "running" {
+		return nil // finished, or failed and waiting for a resume
+	}
+
+	var records []importRecord
+	if err := json.Unmarshal(payload, &records); err != nil {
+		return err
+	}
+	end := processed + importChunkRows
+	if end > len(records) {
+		end = len(records)
+	}
+
+	rows, rowErrors := parseImportRecords(records[processed:end])
+	if len(rowErrors) > 0 {
+		return fmt.Errorf("row %d: %s", rowErrors[0].Row, strings.Join(rowErrors[0].Errors, "; "))
+	}
+
+	// jobs run detached from the request: entries only carry who started it
+	res, err := applyImportRows(tx, rows, onDuplicate, auditActor{userID: createdBy})
+	if err != nil {
+		return err
+	}
+
+	status = "running"
+	if end == len(records) {
+		status = "done"
+	}
+	_, err = tx.Exec(`
+		UPDATE import_jobs
+		SET status = $2,
+		    processed_rows = $3,
+		    created_count = created_count + $4,
+		    updated_count = updated_count + $5,
+		    skipped_count = skipped_count + $6,
+		    records = CASE WHEN $2 = 'done' THEN '[]'::jsonb ELSE records END,
+		    updated_at = NOW()
+		WHERE id = $1
+	`, id, status, end, res.Created, res.Updated, res.Skipped)
+	if err != nil {
+		return err
+	}
+	if status == "running" {
+		if err := services.Enqueue(tx, importChunkJob, importChunkPayload{ID: id}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
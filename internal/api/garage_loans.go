@@ -0,0 +1,316 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type GarageLoan struct {
+	ID               int64   `json:"id"`
+	ItemID           int64   `json:"item_id"`
+	ItemName         string  `json:"item_name"`
+	Quantity         int     `json:"quantity"`
+	BorrowerUserID   *int64  `json:"borrower_user_id,omitempty"`
+	BorrowerUsername *string `json:"borrower_username,omitempty"`
+	BorrowerContact  *string `json:"borrower_contact,omitempty"`
+	LentBy           *int64  `json:"lent_by,omitempty"`
+	Note             *string `json:"note,omitempty"`
+	CheckedOutAt     string  `json:"checked_out_at"`
+	DueAt            *string `json:"due_at,omitempty"`
+	ReturnedAt       *string `json:"returned_at,omitempty"`
+	Overdue          bool    `json:"overdue"`
+}
+
+const loanColumns = `
+	l.id, l.item_id, i.name, l.quantity, l.borrower_user_id, u.username, l.borrower_contact,
+	l.lent_by, l.note, l.checked_out_at, l.due_at, l.returned_at`
+
+const loanFrom = `
+	FROM garage_loans l
+	JOIN garage_items i ON i.id = l.item_id AND i.deleted_at IS NULL
+	LEFT JOIN users u ON u.id = l.borrower_user_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLoan(row rowScanner) (GarageLoan, error) {
+	var l GarageLoan
+	var checkedOut time.Time
+	var due, returned *time.Time
+
+	err := row.Scan(&l.ID, &l.ItemID, &l.ItemName, &l.Quantity, &l.BorrowerUserID, &l.BorrowerUsername,
+		&l.BorrowerContact, &l.LentBy, &l.Note, &checkedOut, &due, &returned)
+	if err != nil {
+		return l, err
+	}
+	l.CheckedOutAt = checkedOut.UTC().Format(time.RFC3339)
+	l.DueAt = formatOptionalTime(due)
+	l.ReturnedAt = formatOptionalTime(returned)
+	l.Overdue = returned == nil && due != nil && due.Before(time.Now())
+	return l, nil
+}
+
+// parseFlexibleTime accepts a full RFC3339 timestamp or a plain YYYY-MM-DD date (midnight UTC).
+func parseFlexibleTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, errors.New("expected RFC3339 timestamp or YYYY-MM-DD date")
+	}
+	return t, nil
+}
+
+// checkedOutQuantity returns how many units of the item are currently lent out.
+func checkedOutQuantity(tx *sql.Tx, itemID int64) (int, error) {
+	var out int
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(quantity), 0)
+		FROM garage_loans
+		WHERE item_id = $1 AND returned_at IS NULL
+	`, itemID).Scan(&out)
+	return out, err
+}
+
+// ---------- LOANS HANDLERS ----------
+
+// POST /garage/items/:id/checkout
+// Body: { "quantity": 1, "borrower_user_id": 7 | "borrower_contact": "neighbour at nr. 12", "due_at": "2025-06-01", "note": "..." }
+func checkOutGarageItem(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var body struct {
+			Quantity        int     `json:"quantity"`
+			BorrowerUserID  *int64  `json:"borrower_user_id"`
+			BorrowerContact *string `json:"borrower_contact"`
+			DueAt           *string `json:"due_at"`
+			Note            *string `json:"note"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		if body.Quantity == 0 {
+			body.Quantity = 1
+		}
+		if body.Quantity < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "quantity must be positive")
+		}
+		if body.BorrowerUserID == nil && (body.BorrowerContact == nil || *body.BorrowerContact == "") {
+			return fiber.NewError(fiber.StatusBadRequest, "borrower_user_id or borrower_contact is required")
+		}
+
+		var dueAt *time.Time
+		if body.DueAt != nil && *body.DueAt != "" {
+			t, err := parseFlexibleTime(*body.DueAt)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "due_at: "+err.Error())
+			}
+			dueAt = &t
+		}
+
+		actor := requestActor(c)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		// lock the item so two check-outs can't both take the last unit
+		var qty int
+		err = tx.QueryRow(`SELECT quantity FROM garage_items WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&qty)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "item not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		out, err := checkedOutQuantity(tx, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		// units someone reserved for right now aren't free to lend either
+		reserved, err := reservedNow(tx, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if available := qty - out - reserved; body.Quantity > available {
+			return fiber.NewError(fiber.StatusConflict, "only "+strconv.Itoa(available)+" available")
+		}
+
+		if body.BorrowerUserID != nil {
+			var exists bool
+			if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, *body.BorrowerUserID).Scan(&exists); err != nil {
+				return fiber.ErrInternalServerError
+			}
+			if !exists {
+				return fiber.NewError(fiber.StatusBadRequest, "borrower user not found")
+			}
+		}
+
+		var loanID int64
+		err = tx.QueryRow(`
+			INSERT INTO garage_loans (item_id, quantity, borrower_user_id, borrower_contact, lent_by, note, due_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, id, body.Quantity, body.BorrowerUserID, body.BorrowerContact, actor.userID, body.Note, dueAt).Scan(&loanID)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		loan, err := scanLoan(tx.QueryRow(`SELECT `+loanColumns+loanFrom+` WHERE l.id = $1`, loanID))
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if err := actor.record(tx, "item.checkout", "item", id, nil, loan); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(loan)
+	}
+}
+
+// POST /garage/loans/:id/checkin
+// Body (optional): { "quantity": 2 }  -> partial return, the rest stays on loan
+func checkInGarageLoan(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var body struct {
+			Quantity *int `json:"quantity"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return fiber.ErrBadRequest
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		var loaned int
+		err = tx.QueryRow(`
+			SELECT quantity FROM garage_loans
+			WHERE id = $1 AND returned_at IS NULL
+			FOR UPDATE
+		`, id).Scan(&loaned)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "open loan not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		returning := loaned
+		if body.Quantity != nil {
+			returning = *body.Quantity
+		}
+		if returning <= 0 || returning > loaned {
+			return fiber.NewError(fiber.StatusBadRequest, "quantity must be between 1 and "+strconv.Itoa(loaned))
+		}
+
+		if returning == loaned {
+			_, err = tx.Exec(`UPDATE garage_loans SET returned_at = NOW() WHERE id = $1`, id)
+		} else {
+			// partial return: split off a closed row for the returned units
+			_, err = tx.Exec(`
+				INSERT INTO garage_loans (item_id, quantity, borrower_user_id, borrower_contact, lent_by, note, checked_out_at, due_at, returned_at)
+				SELECT item_id, $2, borrower_user_id, borrower_contact, lent_by, note, checked_out_at, due_at, NOW()
+				FROM garage_loans WHERE id = $1
+			`, id, returning)
+			if err == nil {
+				_, err = tx.Exec(`UPDATE garage_loans SET quantity = quantity - $2 WHERE id = $1`, id, returning)
+			}
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		loan, err := scanLoan(tx.QueryRow(`SELECT `+loanColumns+loanFrom+` WHERE l.id = $1`, id))
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		err = requestActor(c).record(tx, "item.checkin", "item", loan.ItemID, nil,
+			fiber.Map{"loan_id": id, "quantity": returning, "still_out": loaned - returning})
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.JSON(loan)
+	}
+}
+
+// GET /garage/loans?status=open|returned|all&overdue=true&item_id=1
+func listGarageLoans(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q := `SELECT ` + loanColumns + loanFrom + ` WHERE TRUE`
+		args := []any{}
+
+		status := c.Query("status", "open")
+		if c.QueryBool("overdue") {
+			status = "open"
+			q += ` AND l.due_at < NOW()`
+		}
+		switch status {
+		case "open":
+			q += ` AND l.returned_at IS NULL`
+		case "returned":
+			q += ` AND l.returned_at IS NOT NULL`
+		case "all":
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "status must be open, returned or all")
+		}
+
+		if v := c.Query("item_id"); v != "" {
+			itemID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid item_id")
+			}
+			args = append(args, itemID)
+			q += ` AND l.item_id = $` + strconv.Itoa(len(args))
+		}
+		q += ` ORDER BY l.checked_out_at DESC, l.id DESC`
+
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		loans := []GarageLoan{}
+		for rows.Next() {
+			l, err := scanLoan(rows)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			loans = append(loans, l)
+		}
+
+		return c.JSON(loans)
+	}
+}
@@ -0,0 +1,522 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type MaintenancePlan struct {
+	ID            int64    `json:"id"`
+	ItemID        int64    `json:"item_id"`
+	ItemName      string   `json:"item_name"`
+	Title         string   `json:"title"`
+	Description   *string  `json:"description,omitempty"`
+	ScheduleKind  string   `json:"schedule_kind"` // days | hours | rrule
+	IntervalDays  *int     `json:"interval_days,omitempty"`
+	IntervalHours *float64 `json:"interval_hours,omitempty"`
+	RRule         *string  `json:"rrule,omitempty"`
+	StartsAt      string   `json:"starts_at"`
+	LastDoneAt    *string  `json:"last_done_at,omitempty"`
+	LastDoneHours *float64 `json:"last_done_hours,omitempty"`
+	NextDueAt     *string  `json:"next_due_at,omitempty"`
+	NextDueHours  *float64 `json:"next_due_hours,omitempty"`
+	UsageHours    float64  `json:"usage_hours"` // item's current meter reading
+	Status        string   `json:"status"`      // ok | due_soon | overdue | finished
+	CreatedAt     string   `json:"created_at"`
+}
+
+type MaintenanceLog struct {
+	ID          int64    `json:"id"`
+	PlanID      int64    `json:"plan_id"`
+	ItemID      int64    `json:"item_id"`
+	PerformedAt string   `json:"performed_at"`
+	PerformedBy *int64   `json:"performed_by,omitempty"`
+	Notes       *string  `json:"notes,omitempty"`
+	Cost        *float64 `json:"cost,omitempty"`
+	Currency    *string  `json:"currency,omitempty"`
+	UsageHours  *float64 `json:"usage_hours,omitempty"`
+	CreatedAt   string   `json:"created_at"`
+}
+
+const maintenancePlanColumns = `
+	p.id, p.item_id, i.name, p.title, p.description, p.schedule_kind, p.interval_days, p.interval_hours,
+	p.rrule, p.starts_at, p.last_done_at, p.last_done_hours, p.next_due_at, p.next_due_hours,
+	i.usage_hours, p.created_at`
+
+const maintenancePlanFrom = `
+	FROM maintenance_plans p
+	JOIN garage_items i ON i.id = p.item_id AND i.deleted_at IS NULL`
+
+func scanMaintenancePlan(row rowScanner) (MaintenancePlan, error) {
+	var p MaintenancePlan
+	var starts, created time.Time
+	var lastDone, nextDue *time.Time
+
+	err := row.Scan(&p.ID, &p.ItemID, &p.ItemName, &p.Title, &p.Description, &p.ScheduleKind, &p.IntervalDays,
+		&p.IntervalHours, &p.RRule, &starts, &lastDone, &p.LastDoneHours, &nextDue, &p.NextDueHours,
+		&p.UsageHours, &created)
+	if err != nil {
+		return p, err
+	}
+	p.StartsAt = starts.UTC().Format(time.RFC3339)
+	p.LastDoneAt = formatOptionalTime(lastDone)
+	p.NextDueAt = formatOptionalTime(nextDue)
+	p.CreatedAt = created.UTC().Format(time.RFC3339)
+
+	now := time.Now()
+	switch {
+	case nextDue == nil && p.NextDueHours == nil:
+		p.Status = "finished"
+	case nextDue != nil && nextDue.Before(now),
+		p.NextDueHours != nil && p.UsageHours >= *p.NextDueHours:
+		p.Status = "overdue"
+	case nextDue != nil && nextDue.Before(now.AddDate(0, 0, services.MaintenanceSoonDays)),
+		p.NextDueHours != nil && p.IntervalHours != nil && p.UsageHours >= *p.NextDueHours-*p.IntervalHours*services.MaintenanceSoonFraction:
+		p.Status = "due_soon"
+	default:
+		p.Status = "ok"
+	}
+	return p, nil
+}
+
+// ---------- MAINTENANCE HANDLERS ----------
+
+// POST /garage/items/:id/maintenance
+// Body: { "title": "Oil change", "schedule_kind": "hours", "interval_hours": 50 }
+//
+//	{ "title": "Extinguisher inspection", "schedule_kind": "rrule", "rrule": "FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=1" }
+func createMaintenancePlan(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var body struct {
+			Title         string   `json:"title"`
+			Description   *string  `json:"description"`
+			ScheduleKind  string   `json:"schedule_kind"`
+			IntervalDays  *int     `json:"interval_days"`
+			IntervalHours *float64 `json:"interval_hours"`
+			RRule         *string  `json:"rrule"`
+			StartsAt      *string  `json:"starts_at"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		if body.Title == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "title is required")
+		}
+
+		sched := services.MaintenanceSchedule{
+			Kind:          body.ScheduleKind,
+			IntervalDays:  body.IntervalDays,
+			IntervalHours: body.IntervalHours,
+			RRule:         body.RRule,
+			StartsAt:      time.Now().UTC(),
+		}
+		if body.StartsAt != nil && *body.StartsAt != "" {
+			t, err := parseFlexibleTime(*body.StartsAt)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "starts_at: "+err.Error())
+			}
+			sched.StartsAt = t
+		}
+		if err := sched.Validate(); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		actor := requestActor(c)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		var usage float64
+		err = tx.QueryRow(`SELECT usage_hours FROM garage_items WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&usage)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "item not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		nextAt, nextHours, err := sched.NextDue(nil, nil, nil, usage)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		var planID int64
+		err = tx.QueryRow(`
+			INSERT INTO maintenance_plans (item_id, title, description, schedule_kind, interval_days, interval_hours,
+			                               rrule, starts_at, last_done_hours, next_due_at, next_due_hours, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			RETURNING id
+		`, id, body.Title, body.Description, sched.Kind, sched.IntervalDays, sched.IntervalHours, sched.RRule,
+			sched.StartsAt, hoursBaseline(sched, usage), nextAt, nextHours, actor.userID).Scan(&planID)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		p, err := scanMaintenancePlan(tx.QueryRow(`SELECT `+maintenancePlanColumns+maintenancePlanFrom+` WHERE p.id = $1`, planID))
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if err := actor.record(tx, "item.create_maintenance_plan", "item", id, nil, p); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(p)
+	}
+}
+
+// hoursBaseline records the meter reading an hour-based plan starts counting from.
+func hoursBaseline(s services.MaintenanceSchedule, usage float64) *float64 {
+	if s.Kind != "hours" {
+		return nil
+	}
+	return &usage
+}
+
+// GET /garage/items/:id/maintenance
+func listItemMaintenancePlans(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		rows, err := db.Query(`SELECT `+maintenancePlanColumns+maintenancePlanFrom+`
+			WHERE p.item_id = $1
+			ORDER BY p.next_due_at NULLS LAST, p.id`, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		plans := []MaintenancePlan{}
+		for rows.Next() {
+			p, err := scanMaintenancePlan(rows)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			plans = append(plans, p)
+		}
+
+		return c.JSON(plans)
+	}
+}
+
+// GET /garage/maintenance?within_days=30
+// Overdue and upcoming plans across the whole garage, most urgent first.
+func listUpcomingMaintenance(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		within := c.QueryInt("within_days", 30)
+		if within < 0 || within > 3650 {
+			return fiber.NewError(fiber.StatusBadRequest, "within_days must be between 0 and 3650")
+		}
+
+		rows, err := db.Query(`SELECT `+maintenancePlanColumns+maintenancePlanFrom+`
+			WHERE (p.next_due_at IS NOT NULL AND p.next_due_at <= NOW() + make_interval(days => $1))
+			   OR (p.next_due_hours IS NOT NULL AND i.usage_hours >= p.next_due_hours - p.interval_hours * $2)
+			ORDER BY p.next_due_at NULLS FIRST, p.id`, within, services.MaintenanceSoonFraction)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		plans := []MaintenancePlan{}
+		for rows.Next() {
+			p, err := scanMaintenancePlan(rows)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			plans = append(plans, p)
+		}
+
+		return c.JSON(plans)
+	}
+}
+
+// DELETE /garage/maintenance/:id
+func deleteMaintenancePlan(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		p, err := scanMaintenancePlan(tx.QueryRow(`SELECT `+maintenancePlanColumns+maintenancePlanFrom+` WHERE p.id = $1 FOR UPDATE OF p`, id))
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "maintenance plan not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if _, err := tx.Exec(`DELETE FROM maintenance_plans WHERE id = $1`, id); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := requestActor(c).record(tx, "item.delete_maintenance_plan", "item", p.ItemID, p, nil); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// POST /garage/maintenance/:id/log
+// Body: { "performed_at": "2025-05-02", "notes": "10W-30", "cost": 45.5, "currency": "RON", "usage_hours": 151.5 }
+// Records completed work and moves the plan's next due date/reading forward.
+func logMaintenance(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var body struct {
+			PerformedAt *string  `json:"performed_at"`
+			Notes       *string  `json:"notes"`
+			Cost        *float64 `json:"cost"`
+			Currency    *string  `json:"currency"`
+			UsageHours  *float64 `json:"usage_hours"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		performedAt := time.Now().UTC()
+		if body.PerformedAt != nil && *body.PerformedAt != "" {
+			t, err := parseFlexibleTime(*body.PerformedAt)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "performed_at: "+err.Error())
+			}
+			performedAt = t
+		}
+		if body.Cost != nil && *body.Cost < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "cost cannot be negative")
+		}
+		if body.Currency != nil {
+			cur := strings.ToUpper(*body.Currency)
+			if len(cur) != 3 {
+				return fiber.NewError(fiber.StatusBadRequest, "currency must be a 3-letter ISO code")
+			}
+			body.Currency = &cur
+		}
+		if body.UsageHours != nil && *body.UsageHours < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "usage_hours cannot be negative")
+		}
+
+		actor := requestActor(c)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		var (
+			itemID  int64
+			sched   services.MaintenanceSchedule
+			usage   float64
+			prevDue *time.Time
+		)
+		err = tx.QueryRow(`
+			SELECT p.item_id, p.schedule_kind, p.interval_days, p.interval_hours, p.rrule, p.starts_at, p.next_due_at, i.usage_hours
+			FROM maintenance_plans p
+			JOIN garage_items i ON i.id = p.item_id AND i.deleted_at IS NULL
+			WHERE p.id = $1
+			FOR UPDATE OF p
+		`, id).Scan(&itemID, &sched.Kind, &sched.IntervalDays, &sched.IntervalHours, &sched.RRule, &sched.StartsAt, &prevDue, &usage)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "maintenance plan not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		// a reading on the log also advances the item's meter
+		doneHours := usage
+		if body.UsageHours != nil {
+			doneHours = *body.UsageHours
+			if doneHours > usage {
+				if _, err := tx.Exec(`UPDATE garage_items SET usage_hours = $1, updated_at = NOW() WHERE id = $2`, doneHours, itemID); err != nil {
+					return fiber.ErrInternalServerError
+				}
+			}
+		}
+
+		var l MaintenanceLog
+		var performed, created time.Time
+		err = tx.QueryRow(`
+			INSERT INTO maintenance_logs (plan_id, item_id, performed_at, performed_by, notes, cost, currency, usage_hours)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id, plan_id, item_id, performed_at, performed_by, notes, cost, currency, usage_hours, created_at
+		`, id, itemID, performedAt, actor.userID, body.Notes, body.Cost, body.Currency, doneHours).Scan(
+			&l.ID, &l.PlanID, &l.ItemID, &performed, &l.PerformedBy, &l.Notes, &l.Cost, &l.Currency, &l.UsageHours, &created)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		l.PerformedAt = performed.UTC().Format(time.RFC3339)
+		l.CreatedAt = created.UTC().Format(time.RFC3339)
+
+		nextAt, nextHours, err := sched.NextDue(prevDue, &performedAt, &doneHours, doneHours)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		_, err = tx.Exec(`
+			UPDATE maintenance_plans
+			SET last_done_at = $1,
+			    last_done_hours = $2,
+			    next_due_at = $3,
+			    next_due_hours = $4,
+			    last_reminded_at = NULL
+			WHERE id = $5
+		`, performedAt, doneHours, nextAt, nextHours, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if err := actor.record(tx, "item.log_maintenance", "item", itemID, nil, l); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(l)
+	}
+}
+
+// GET /garage/maintenance/:id/log
+func listMaintenanceLog(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		rows, err := db.Query(`
+			SELECT id, plan_id, item_id, performed_at, performed_by, notes, cost, currency, usage_hours, created_at
+			FROM maintenance_logs
+			WHERE plan_id = $1
+			ORDER BY performed_at DESC, id DESC
+		`, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		logs := []MaintenanceLog{}
+		for rows.Next() {
+			var l MaintenanceLog
+			var performed, created time.Time
+			if err := rows.Scan(&l.ID, &l.PlanID, &l.ItemID, &performed, &l.PerformedBy, &l.Notes, &l.Cost,
+				&l.Currency, &l.UsageHours, &created); err != nil {
+				return fiber.ErrInternalServerError
+			}
+			l.PerformedAt = performed.UTC().Format(time.RFC3339)
+			l.CreatedAt = created.UTC().Format(time.RFC3339)
+			logs = append(logs, l)
+		}
+
+		return c.JSON(logs)
+	}
+}
+
+// POST /garage/items/:id/usage
+// Body: { "hours": 2.5 }  -> adds to the hour meter
+//
+//	{ "reading": 152.0 } -> sets the meter (can't go backwards)
+func recordItemUsage(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var body struct {
+			Hours   *float64 `json:"hours"`
+			Reading *float64 `json:"reading"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		if (body.Hours == nil) == (body.Reading == nil) {
+			return fiber.NewError(fiber.StatusBadRequest, "send either hours or reading")
+		}
+		if body.Hours != nil && *body.Hours <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "hours must be positive")
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		var before, usage float64
+		err = tx.QueryRow(`SELECT usage_hours FROM garage_items WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&before)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "item not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if body.Hours != nil {
+			err = tx.QueryRow(`
+				UPDATE garage_items
+				SET usage_hours = usage_hours + $1, updated_at = NOW()
+				WHERE id = $2
+				RETURNING usage_hours
+			`, *body.Hours, id).Scan(&usage)
+		} else {
+			err = tx.QueryRow(`
+				UPDATE garage_items
+				SET usage_hours = $1, updated_at = NOW()
+				WHERE id = $2 AND usage_hours <= $1
+				RETURNING usage_hours
+			`, *body.Reading, id).Scan(&usage)
+		}
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "item not found or reading lower than current meter")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		err = requestActor(c).record(tx, "item.usage", "item", id,
+			fiber.Map{"usage_hours": before}, fiber.Map{"usage_hours": usage})
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.JSON(fiber.Map{"item_id": id, "usage_hours": usage})
+	}
+}
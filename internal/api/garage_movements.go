@@ -0,0 +1,199 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type StockMovement struct {
+	ID            int64   `json:"id"`
+	ItemID        int64   `json:"item_id"`
+	Delta         int     `json:"delta"`
+	Reason        string  `json:"reason"`
+	Note          *string `json:"note,omitempty"`
+	ActorID       *int64  `json:"actor_id,omitempty"`
+	ActorUsername *string `json:"actor_username,omitempty"`
+	QuantityAfter int     `json:"quantity_after"`
+	CreatedAt     string  `json:"created_at"`
+}
+
+var movementReasons = map[string]bool{
+	"purchase":   true,
+	"used":       true,
+	"lost":       true,
+	"adjustment": true,
+}
+
+var (
+	errInsufficientStock = errors.New("quantity cannot go below zero")
+	errStockLentOut      = errors.New("quantity cannot go below what is lent out")
+)
+
+// applyStockMovement appends a movement to the ledger and moves the item's
+// quantity by the same delta. It must run inside the caller's transaction so
+// the ledger and garage_items.quantity never drift apart. Units out on loan
+// can't be written off: the quantity never drops below them.
+func applyStockMovement(tx *sql.Tx, itemID int64, delta int, reason string, note *string, actorID *int64) (StockMovement, error) {
+	m := StockMovement{ItemID: itemID, Delta: delta, Reason: reason, Note: note, ActorID: actorID}
+
+	var qty int
+	err := tx.QueryRow(`SELECT quantity FROM garage_items WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, itemID).Scan(&qty)
+	if err != nil {
+		return m, err
+	}
+	if qty+delta < 0 {
+		return m, errInsufficientStock
+	}
+	if delta < 0 {
+		out, err := checkedOutQuantity(tx, itemID)
+		if err != nil {
+			return m, err
+		}
+		if qty+delta < out {
+			return m, errStockLentOut
+		}
+	}
+	m.QuantityAfter = qty + delta
+
+	var created time.Time
+	err = tx.QueryRow(`
+		INSERT INTO stock_movements (item_id, delta, reason, note, actor_id, quantity_after)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, itemID, delta, reason, note, actorID, m.QuantityAfter).Scan(&m.ID, &created)
+	if err != nil {
+		return m, err
+	}
+	m.CreatedAt = created.UTC().Format(time.RFC3339)
+
+	_, err = tx.Exec(`
+		UPDATE garage_items
+		SET quantity = $1,
+		    updated_at = NOW()
+		WHERE id = $2
+	`, m.QuantityAfter, itemID)
+	if err != nil {
+		return m, err
+	}
+
+	if delta < 0 {
+		if _, err := recordLowStockAlert(tx, itemID); err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+// ---------- MOVEMENTS HANDLERS ----------
+
+// POST /garage/items/:id/movements
+// Body: { "delta": -3, "reason": "used", "note": "deck repair" }
+func createStockMovement(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var body struct {
+			Delta  int     `json:"delta"`
+			Reason string  `json:"reason"`
+			Note   *string `json:"note"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		if body.Delta == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "delta must be non-zero")
+		}
+		if !movementReasons[body.Reason] {
+			return fiber.NewError(fiber.StatusBadRequest, "reason must be one of purchase, used, lost, adjustment")
+		}
+
+		actor := requestActor(c)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		m, err := applyStockMovement(tx, id, body.Delta, body.Reason, body.Note, actor.userID)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "item not found")
+		}
+		if err == errInsufficientStock || err == errStockLentOut {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if err := actor.record(tx, "item.stock_movement", "item", id, nil, m); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := queueItemEvent(tx, "item.updated", id); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(m)
+	}
+}
+
+// GET /garage/items/:id/movements?limit=100
+// Newest first.
+func listStockMovements(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		limit := c.QueryInt("limit", 100)
+		if limit <= 0 || limit > 500 {
+			limit = 100
+		}
+
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM garage_items WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if !exists {
+			return fiber.NewError(fiber.StatusNotFound, "item not found")
+		}
+
+		rows, err := db.Query(`
+			SELECT m.id, m.item_id, m.delta, m.reason, m.note, m.actor_id, u.username, m.quantity_after, m.created_at
+			FROM stock_movements m
+			LEFT JOIN users u ON u.id = m.actor_id
+			WHERE m.item_id = $1
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT $2
+		`, id, limit)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		movements := []StockMovement{}
+		for rows.Next() {
+			var m StockMovement
+			var created time.Time
+			if err := rows.Scan(&m.ID, &m.ItemID, &m.Delta, &m.Reason, &m.Note, &m.ActorID, &m.ActorUsername, &m.QuantityAfter, &created); err != nil {
+				return fiber.ErrInternalServerError
+			}
+			m.CreatedAt = created.UTC().Format(time.RFC3339)
+			movements = append(movements, m)
+		}
+
+		return c.JSON(movements)
+	}
+}
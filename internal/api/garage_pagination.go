@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// sortColumn is a column clients may sort a list by. cast is the Postgres
+// type used to compare the cursor value against it.
+type sortColumn struct {
+	expr string
+	cast string
+}
+
+var garageItemSorts = map[string]sortColumn{
+	"id":         {"i.id", "bigint"},
+	"name":       {"i.name", "text"},
+	"quantity":   {"i.quantity", "int"},
+	"created_at": {"i.created_at", "timestamptz"},
+	"updated_at": {"i.updated_at", "timestamptz"},
+}
+
+var garageSpaceSorts = map[string]sortColumn{
+	"id":         {"id", "bigint"},
+	"name":       {"name", "text"},
+	"created_at": {"created_at", "timestamptz"},
+}
+
+// pageCursor points just past the last row of a page. It is opaque to
+// clients (base64 JSON) and only valid for the sort it was issued for.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func (pc pageCursor) encode() string {
+	b, _ := json.Marshal(pc)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// listPage is a parsed limit/sort/cursor; idExpr is the table's id column.
+type listPage struct {
+	limit  int
+	sort   string // as given, e.g. "-updated_at"
+	col    sortColumn
+	desc   bool
+	idExpr string
+	cursor *pageCursor
+}
+
+// parseListPage reads ?limit=&sort=&cursor=. sort is a whitelisted column,
+// prefixed with "-" for descending; ties are broken by id.
+func parseListPage(c *fiber.Ctx, sorts map[string]sortColumn, idExpr string) (listPage, error) {
+	p := listPage{limit: defaultPageSize, sort: c.Query("sort", "id"), idExpr: idExpr}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return p, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+		}
+		p.limit = n
+	}
+
+	key := strings.TrimPrefix(p.sort, "-")
+	p.desc = key != p.sort
+	col, ok := sorts[key]
+	if !ok {
+		allowed := make([]string, 0, len(sorts))
+		for k := range sorts {
+			allowed = append(allowed, k)
+		}
+		sort.Strings(allowed)
+		return p, fiber.NewError(fiber.StatusBadRequest, "sort must be one of: "+strings.Join(allowed, ", "))
+	}
+	p.col = col
+
+	if v := c.Query("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		var pc pageCursor
+		if err != nil || json.Unmarshal(raw, &pc) != nil {
+			return p, fiber.NewError(fiber.StatusBadRequest, "invalid cursor")
+		}
+		if pc.Sort != p.sort {
+			return p, fiber.NewError(fiber.StatusBadRequest, "cursor was issued for a different sort")
+		}
+		p.cursor = &pc
+	}
+
+	return p, nil
+}
+
+// parseOptionalListPage is parseListPage for lists that returned everything
+// before they were paginated: without ?limit= or ?cursor= there is no limit,
+// so old clients still get the whole list.
+func parseOptionalListPage(c *fiber.Ctx, sorts map[string]sortColumn, idExpr string) (listPage, error) {
+	p, err := parseListPage(c, sorts, idExpr)
+	if c.Query("limit") == "" && c.Query("cursor") == "" {
+		p.limit = 0
+	}
+	return p, err
+}
+
+// setNextPage advertises the following page in headers, for lists whose
+// body is a bare array: X-Next-Cursor, and a Link rel="next" with the same
+// query and the new cursor.
+func setNextPage(c *fiber.Ctx, next *string) {
+	if next == nil {
+		return
+	}
+	q, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	q.Set("cursor", *next)
+	c.Set("X-Next-Cursor", *next)
+	c.Set(fiber.HeaderLink, fmt.Sprintf(`<%s%s?%s>; rel="next"`, c.BaseURL(), c.Path(), q.Encode()))
+}
+
+// where returns the keyset condition (empty on the first page), appending its args.
+func (p listPage) where(args []any) (string, []any) {
+	if p.cursor == nil {
+		return "", args
+	}
+	op := ">"
+	if p.desc {
+		op = "<"
+	}
+	args = append(args, p.cursor.Value, p.cursor.ID)
+	return fmt.Sprintf("(%s, %s) %s ($%d::%s, $%d::bigint)",
+		p.col.expr, p.idExpr, op, len(args)-1, p.col.cast, len(args)), args
+}
+
+// orderLimit returns the ORDER BY / LIMIT tail. One extra row is fetched to
+// know whether there is a next page.
+func (p listPage) orderLimit() string {
+	dir := "ASC"
+	if p.desc {
+		dir = "DESC"
+	}
+	q := fmt.Sprintf(" ORDER BY %s %s, %s %s", p.col.expr, dir, p.idExpr, dir)
+	if p.limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", p.limit+1)
+	}
+	return q
+}
+
+// full reports whether the n-th row read is past the end of the page.
+func (p listPage) full(n int) bool {
+	return p.limit > 0 && n > p.limit
+}
+
+// sortKeyColumn selects the sort value as text so the cursor keeps full
+// precision (timestamps are only rendered to the second in responses).
+func (p listPage) sortKeyColumn() string {
+	return fmt.Sprintf(", (%s)::text", p.col.expr)
+}
+
+// next returns the cursor for the following page, or nil on the last one.
+// n is how many rows were read; lastKey/lastID belong to the last row kept.
+func (p listPage) next(n int, lastKey string, lastID int64) *string {
+	if !p.full(n) {
+		return nil
+	}
+	s := pageCursor{Sort: p.sort, Value: lastKey, ID: lastID}.encode()
+	return &s
+}
+
+// keyedRow scans a row whose last column is the sort key added by sortKeyColumn.
+type keyedRow struct {
+	rowScanner
+	key *string
+}
+
+func (k keyedRow) Scan(dest ...any) error {
+	return k.rowScanner.Scan(append(dest, k.key)...)
+}
+
+// escapeLike makes s safe to use as a literal LIKE prefix.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// pickFields trims v (anything marshalling to a JSON object) down to the
+// given keys, for ?fields=id,name,quantity. No fields keeps everything.
+func pickFields(v any, fields []string) (any, error) {
+	if len(fields) == 0 {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var full map[string]json.RawMessage
+	if err := json.Unmarshal(b, &full); err != nil {
+		return nil, err
+	}
+	out := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if raw, ok := full[f]; ok {
+			out[f] = raw
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,446 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+	"github.com/gofiber/fiber/v2"
+)
+
+// PATCH /garage/items/:id and /garage/spaces/:id take either
+//
+//	Content-Type: application/merge-patch+json   (RFC 7396, also plain application/json)
+//	Content-Type: application/json-patch+json    (RFC 6902)
+//
+// The patch is applied to the resource's JSON representation; the fields that
+// changed are then validated and written with an UPDATE built only from the
+// whitelisted columns below. Unlike PUT, an explicit null clears a field.
+
+// patchField is a writable field: its column and how to turn a JSON value
+// into a database value (or a validation message).
+type patchField struct {
+	column  string
+	convert func(v any) (any, string)
+}
+
+var garageItemPatchFields = map[string]patchField{
+	"space_id":         {"space_id", idValue},
+	"name":             {"name", textValue(maxShortText, false)},
+	"quantity":         {"quantity", intValue(0, false)}, // goes through the ledger
+	"notes":            {"notes", textValue(maxLongText, true)},
+	"min_quantity":     {"min_quantity", intValue(0, true)},
+	"reorder_quantity": {"reorder_quantity", intValue(1, true)},
+	"purchase_date":    {"purchase_date", dateValue},
+	"vendor":           {"vendor", textValue(maxShortText, true)},
+	"purchase_price":   {"purchase_price", priceValue},
+	"currency":         {"currency", currencyValue},
+	"serial_number":    {"serial_number", textValue(maxShortText, true)},
+	"warranty_expires": {"warranty_expires", dateValue},
+}
+
+var garageSpacePatchFields = map[string]patchField{
+	"name":        {"name", textValue(maxShortText, false)},
+	"description": {"description", textValue(maxLongText, true)},
+	"location":    {"location", textValue(maxShortText, true)},
+}
+
+// ---------- value converters ----------
+
+func idValue(v any) (any, string) {
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) || f <= 0 {
+		return nil, "must be a valid id"
+	}
+	return int64(f), ""
+}
+
+func intValue(min int, nullable bool) func(any) (any, string) {
+	return func(v any) (any, string) {
+		if v == nil {
+			if nullable {
+				return nil, ""
+			}
+			return nil, "cannot be null"
+		}
+		f, ok := v.(float64)
+		if !ok || f != math.Trunc(f) || f > math.MaxInt32 {
+			return nil, "must be a whole number"
+		}
+		if int(f) < min {
+			return nil, "must be >= " + strconv.Itoa(min)
+		}
+		return int(f), ""
+	}
+}
+
+func textValue(max int, nullable bool) func(any) (any, string) {
+	return func(v any) (any, string) {
+		if v == nil {
+			if nullable {
+				return nil, ""
+			}
+			return nil, "cannot be null"
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, "must be a string"
+		}
+		if !nullable && strings.TrimSpace(s) == "" {
+			return nil, "is required"
+		}
+		if len([]rune(s)) > max {
+			return nil, "is too long"
+		}
+		return s, ""
+	}
+}
+
+func dateValue(v any) (any, string) {
+	if v == nil {
+		return nil, ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, "must be YYYY-MM-DD"
+	}
+	t, err := time.Parse(dateFormat, s)
+	if err != nil {
+		return nil, "must be YYYY-MM-DD"
+	}
+	return t, ""
+}
+
+func priceValue(v any) (any, string) {
+	if v == nil {
+		return nil, ""
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return nil, "must be a number"
+	}
+	if f < 0 {
+		return nil, "cannot be negative"
+	}
+	return f, ""
+}
+
+func currencyValue(v any) (any, string) {
+	if v == nil {
+		return nil, ""
+	}
+	s, ok := v.(string)
+	if !ok || len(s) != 3 {
+		return nil, "must be a 3-letter ISO code"
+	}
+	return strings.ToUpper(s), ""
+}
+
+// ---------- applying the patch ----------
+
+// patchChanges applies the request's patch to resource (anything that
+// marshals to a JSON object) and returns the changed writable fields,
+// converted for the database. Read-only or unknown fields that the patch
+// touches are validation errors.
+func patchChanges(c *fiber.Ctx, resource any, fields map[string]patchField) (map[string]any, error) {
+	before, err := patchDocument(resource, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	var patched any
+	switch mediaType {
+	case "application/json-patch+json":
+		var ops []jsonPatchOp
+		if err := json.Unmarshal(c.Body(), &ops); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "JSON Patch body must be an array of operations")
+		}
+		patched, err = applyJSONPatch(before, ops)
+		var pe errPatch
+		if errors.As(err, &pe) {
+			// RFC 5789: a patch that can't be applied to this resource
+			return nil, fiber.NewError(fiber.StatusConflict, pe.msg)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	case "application/merge-patch+json", "application/json":
+		var patch any
+		if err := json.Unmarshal(c.Body(), &patch); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid JSON")
+		}
+		if _, ok := patch.(map[string]any); !ok {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "merge patch must be a JSON object")
+		}
+		patched = applyMergePatch(deepCopyJSON(before), patch)
+
+	default:
+		c.Set("Accept-Patch", "application/merge-patch+json, application/json-patch+json")
+		return nil, fiber.NewError(fiber.StatusUnsupportedMediaType,
+			"use application/merge-patch+json or application/json-patch+json")
+	}
+
+	after, ok := patched.(map[string]any)
+	if !ok {
+		return nil, &ValidationError{Fields: map[string]string{"document": "must stay a JSON object"}}
+	}
+	return changedFields(before, after, fields)
+}
+
+// patchDocument is resource as a JSON object for a patch to work on.
+func patchDocument(resource any, fields map[string]patchField) (map[string]any, error) {
+	raw, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	// omitempty hides null fields; put them back so patches can address them
+	for name := range fields {
+		if _, ok := doc[name]; !ok {
+			doc[name] = nil
+		}
+	}
+	return doc, nil
+}
+
+// changedFields returns the writable fields that differ between the two
+// documents, converted for the database.
+func changedFields(before, after map[string]any, fields map[string]patchField) (map[string]any, error) {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+
+	var v validator
+	changes := map[string]any{}
+	for k := range keys {
+		if reflect.DeepEqual(before[k], after[k]) {
+			continue
+		}
+		f, writable := fields[k]
+		switch {
+		case writable:
+			value, msg := f.convert(after[k])
+			if msg != "" {
+				v.add(k, msg)
+				continue
+			}
+			changes[k] = value
+		case hasKey(before, k):
+			v.add(k, "is read-only")
+		default:
+			v.add(k, "is not a known field")
+		}
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func hasKey(m map[string]any, k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+// buildPatchUpdate returns "col = $1, col2 = $2" for the changes (skipping
+// the names in skip) plus the args. Columns only ever come from fields.
+func buildPatchUpdate(changes map[string]any, fields map[string]patchField, skip ...string) (string, []any) {
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		skipped := false
+		for _, s := range skip {
+			skipped = skipped || s == name
+		}
+		if !skipped {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	sets := make([]string, 0, len(names))
+	args := make([]any, 0, len(names))
+	for _, name := range names {
+		args = append(args, changes[name])
+		sets = append(sets, fmt.Sprintf("%s = $%d", fields[name].column, len(args)))
+	}
+	return strings.Join(sets, ", "), args
+}
+
+// applyGarageItemChanges writes changedFields output to the (locked, live)
+// item it. The PATCH handler and sync updates share it.
+func applyGarageItemChanges(tx *sql.Tx, it GarageItem, changes map[string]any, actorID *int64) error {
+	if sid, ok := changes["space_id"]; ok {
+		if err := requireLiveSpace(tx, sid.(int64)); err != nil {
+			return err
+		}
+	}
+
+	// quantity is derived from the ledger: a new value becomes an adjustment movement
+	if q, ok := changes["quantity"]; ok {
+		if _, err := applyStockMovement(tx, it.ID, q.(int)-it.Quantity, "adjustment", nil, actorID); err != nil {
+			return err
+		}
+	}
+
+	sets, args := buildPatchUpdate(changes, garageItemPatchFields, "quantity")
+	if sets != "" {
+		if _, ok := changes["warranty_expires"]; ok {
+			// a new warranty date deserves a new reminder
+			sets += ", warranty_reminded_at = NULL"
+		}
+		args = append(args, it.ID)
+		q := fmt.Sprintf(`UPDATE garage_items SET %s, updated_at = NOW() WHERE id = $%d`, sets, len(args))
+		if _, err := tx.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+
+	// a raised minimum can put the item below it without any stock moving
+	if _, ok := changes["min_quantity"]; ok {
+		if _, err := recordLowStockAlert(tx, it.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyGarageSpaceChanges is applyGarageItemChanges for spaces.
+func applyGarageSpaceChanges(tx *sql.Tx, id int64, changes map[string]any) error {
+	sets, args := buildPatchUpdate(changes, garageSpacePatchFields)
+	if sets == "" {
+		return nil
+	}
+	args = append(args, id)
+	_, err := tx.Exec(fmt.Sprintf(`UPDATE garage_spaces SET %s WHERE id = $%d`, sets, len(args)), args...)
+	return err
+}
+
+// ---------- HANDLERS ----------
+
+// PATCH /garage/items/:id   (If-Match required)
+func patchGarageItem(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+		ifMatch, err := requireIfMatch(c)
+		if err != nil {
+			return err
+		}
+
+		actor := requestActor(c)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		it, err := scanGarageItem(tx.QueryRow(`SELECT `+garageItemColumns+garageItemFrom+` WHERE i.id = $1 AND i.deleted_at IS NULL FOR UPDATE OF i`, id))
+		if err != nil {
+			return dbError(err, "item not found")
+		}
+		if !etagMatches(ifMatch, it.Version) {
+			return fiber.NewError(fiber.StatusPreconditionFailed, errVersionMismatch.Error())
+		}
+
+		changes, err := patchChanges(c, it, garageItemPatchFields)
+		if err != nil {
+			return err
+		}
+		if err := applyGarageItemChanges(tx, it, changes, actor.userID); err != nil {
+			var ve *ValidationError
+			if errors.As(err, &ve) {
+				return err
+			}
+			return dbError(err, "item not found")
+		}
+
+		after, err := loadGarageItem(tx, id)
+		if err != nil {
+			return dbError(err, "item not found")
+		}
+		if err := actor.record(tx, "item.update", "item", id, it, after); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := webhooks.Enqueue(tx, "item.updated", after); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		c.Set(fiber.HeaderETag, etagFor(after.Version))
+		return c.JSON(after)
+	}
+}
+
+// PATCH /garage/spaces/:id   (If-Match required)
+func patchGarageSpace(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+		ifMatch, err := requireIfMatch(c)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		s, err := scanGarageSpace(tx.QueryRow(`SELECT `+garageSpaceColumns+` FROM garage_spaces WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id))
+		if err != nil {
+			return dbError(err, "space not found")
+		}
+		if !etagMatches(ifMatch, s.Version) {
+			return fiber.NewError(fiber.StatusPreconditionFailed, errVersionMismatch.Error())
+		}
+
+		changes, err := patchChanges(c, s, garageSpacePatchFields)
+		if err != nil {
+			return err
+		}
+
+		if err := applyGarageSpaceChanges(tx, id, changes); err != nil {
+			return dbError(err, "space not found")
+		}
+
+		after, err := loadGarageSpace(tx, id)
+		if err != nil {
+			return dbError(err, "space not found")
+		}
+		if err := requestActor(c).record(tx, "space.update", "space", id, s, after); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		c.Set(fiber.HeaderETag, etagFor(after.Version))
+		return c.JSON(after)
+	}
+}
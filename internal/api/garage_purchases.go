@@ -0,0 +1,380 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type GarageAttachment struct {
+	ID          int64  `json:"id"`
+	ItemID      int64  `json:"item_id"`
+	Kind        string `json:"kind"` // receipt | warranty | photo | manual | other
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int    `json:"size_bytes"`
+	UploadedBy  *int64 `json:"uploaded_by,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+var attachmentKinds = map[string]bool{
+	"receipt":  true,
+	"warranty": true,
+	"photo":    true,
+	"manual":   true,
+	"other":    true,
+}
+
+// per file; the app-wide body limit (BODY_LIMIT_MB) is larger so restores fit
+const maxAttachmentBytes = 4 * 1024 * 1024
+
+type ExpiringWarranty struct {
+	ItemID          int64   `json:"item_id"`
+	ItemName        string  `json:"item_name"`
+	SpaceName       string  `json:"space_name"`
+	SerialNumber    *string `json:"serial_number,omitempty"`
+	Vendor          *string `json:"vendor,omitempty"`
+	WarrantyExpires string  `json:"warranty_expires"`
+	DaysLeft        int     `json:"days_left"`
+}
+
+type ValueTotal struct {
+	Currency string  `json:"currency"`
+	Total    float64 `json:"total"`
+	Items    int     `json:"items"` // priced items counted in this total
+}
+
+type SpaceValue struct {
+	SpaceID   int64        `json:"space_id"`
+	SpaceName string       `json:"space_name"`
+	Totals    []ValueTotal `json:"totals"`
+}
+
+// ---------- ATTACHMENTS HANDLERS ----------
+
+// POST /garage/items/:id/attachments   (multipart: file=<binary>, kind=receipt)
+func uploadItemAttachment(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		kind := c.FormValue("kind", "other")
+		if !attachmentKinds[kind] {
+			return fiber.NewError(fiber.StatusBadRequest, "kind must be receipt, warranty, photo, manual or other")
+		}
+
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "file is required")
+		}
+		if fh.Size > maxAttachmentBytes {
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, "attachment too large")
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		contentType := fh.Header.Get(fiber.HeaderContentType)
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType = http.DetectContentType(data)
+		}
+
+		actor := requestActor(c)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM garage_items WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if !exists {
+			return fiber.NewError(fiber.StatusNotFound, "item not found")
+		}
+
+		a := GarageAttachment{ItemID: id, Kind: kind, Filename: fh.Filename, ContentType: contentType, SizeBytes: len(data), UploadedBy: actor.userID}
+		var created time.Time
+		err = tx.QueryRow(`
+			INSERT INTO garage_attachments (item_id, kind, filename, content_type, size_bytes, data, uploaded_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, created_at
+		`, id, kind, fh.Filename, contentType, len(data), data, actor.userID).Scan(&a.ID, &created)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		a.CreatedAt = created.UTC().Format(time.RFC3339)
+
+		if err := actor.record(tx, "item.upload_attachment", "item", id, nil, a); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(a)
+	}
+}
+
+// GET /garage/items/:id/attachments   (metadata only)
+func listItemAttachments(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		rows, err := db.Query(`
+			SELECT a.id, a.item_id, a.kind, a.filename, a.content_type, a.size_bytes, a.uploaded_by, a.created_at
+			FROM garage_attachments a
+			JOIN garage_items i ON i.id = a.item_id AND i.deleted_at IS NULL
+			WHERE a.item_id = $1
+			ORDER BY a.id
+		`, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		attachments := []GarageAttachment{}
+		for rows.Next() {
+			var a GarageAttachment
+			var created time.Time
+			if err := rows.Scan(&a.ID, &a.ItemID, &a.Kind, &a.Filename, &a.ContentType, &a.SizeBytes, &a.UploadedBy, &created); err != nil {
+				return fiber.ErrInternalServerError
+			}
+			a.CreatedAt = created.UTC().Format(time.RFC3339)
+			attachments = append(attachments, a)
+		}
+
+		return c.JSON(attachments)
+	}
+}
+
+// GET /garage/attachments/:id   (the file itself)
+func downloadAttachment(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var filename, contentType string
+		var data []byte
+		err = db.QueryRow(`
+			SELECT a.filename, a.content_type, a.data
+			FROM garage_attachments a
+			JOIN garage_items i ON i.id = a.item_id AND i.deleted_at IS NULL
+			WHERE a.id = $1
+		`, id).Scan(&filename, &contentType, &data)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "attachment not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		// the type is whatever the uploader claimed; only ones a browser
+		// can't run script from are shown inline, the rest are downloaded
+		disposition := "attachment"
+		if inlineContentTypes[strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))] {
+			disposition = "inline"
+		}
+		c.Set(fiber.HeaderContentType, contentType)
+		c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+		c.Set(fiber.HeaderContentDisposition, contentDisposition(disposition, filename))
+		return c.Send(data)
+	}
+}
+
+// inlineContentTypes are the attachment types served inline.
+var inlineContentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// contentDisposition builds a Content-Disposition header (RFC 6266) with an
+// ASCII fallback filename and the exact one as filename*.
+func contentDisposition(disposition, filename string) string {
+	fallback := []byte(filename)
+	for i, b := range fallback {
+		if b < 0x20 || b >= 0x7f || b == '"' || b == '\\' {
+			fallback[i] = '_'
+		}
+	}
+
+	var encoded strings.Builder
+	for _, b := range []byte(filename) {
+		// attr-char of RFC 8187; everything else is percent-encoded
+		if 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback, encoded.String())
+}
+
+// DELETE /garage/attachments/:id
+func deleteAttachment(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		var a GarageAttachment
+		var created time.Time
+		err = tx.QueryRow(`
+			DELETE FROM garage_attachments WHERE id = $1
+			RETURNING id, item_id, kind, filename, content_type, size_bytes, uploaded_by, created_at
+		`, id).Scan(&a.ID, &a.ItemID, &a.Kind, &a.Filename, &a.ContentType, &a.SizeBytes, &a.UploadedBy, &created)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "attachment not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		a.CreatedAt = created.UTC().Format(time.RFC3339)
+
+		if err := requestActor(c).record(tx, "item.delete_attachment", "item", a.ItemID, a, nil); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// ---------- WARRANTY & VALUE HANDLERS ----------
+
+// GET /garage/warranties?within_days=30
+func listExpiringWarranties(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		within := c.QueryInt("within_days", 30)
+		if within < 0 || within > 3650 {
+			return fiber.NewError(fiber.StatusBadRequest, "within_days must be between 0 and 3650")
+		}
+
+		rows, err := db.Query(`
+			SELECT i.id, i.name, s.name, i.serial_number, i.vendor, i.warranty_expires,
+			       i.warranty_expires - CURRENT_DATE
+			FROM garage_items i
+			JOIN garage_spaces s ON s.id = i.space_id
+			WHERE i.deleted_at IS NULL
+			  AND i.warranty_expires BETWEEN CURRENT_DATE AND CURRENT_DATE + $1::int
+			ORDER BY i.warranty_expires, i.id
+		`, within)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		warranties := []ExpiringWarranty{}
+		for rows.Next() {
+			var w ExpiringWarranty
+			var expires time.Time
+			if err := rows.Scan(&w.ItemID, &w.ItemName, &w.SpaceName, &w.SerialNumber, &w.Vendor, &expires, &w.DaysLeft); err != nil {
+				return fiber.ErrInternalServerError
+			}
+			w.WarrantyExpires = expires.Format(dateFormat)
+			warranties = append(warranties, w)
+		}
+
+		return c.JSON(warranties)
+	}
+}
+
+// GET /garage/reports/value
+// Purchase value (price x quantity) per space and for the whole garage,
+// one total per currency since we don't convert between them.
+func garageValueReport(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rows, err := db.Query(`
+			SELECT s.id, s.name, i.currency, SUM(i.purchase_price * i.quantity), COUNT(*)
+			FROM garage_items i
+			JOIN garage_spaces s ON s.id = i.space_id
+			WHERE i.deleted_at IS NULL AND i.purchase_price IS NOT NULL AND i.currency IS NOT NULL
+			GROUP BY s.id, s.name, i.currency
+			ORDER BY s.id, i.currency
+		`)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		spaces := []SpaceValue{}
+		overall := map[string]*ValueTotal{}
+		var currencies []string
+		for rows.Next() {
+			var spaceID int64
+			var spaceName string
+			var t ValueTotal
+			if err := rows.Scan(&spaceID, &spaceName, &t.Currency, &t.Total, &t.Items); err != nil {
+				return fiber.ErrInternalServerError
+			}
+
+			if len(spaces) == 0 || spaces[len(spaces)-1].SpaceID != spaceID {
+				spaces = append(spaces, SpaceValue{SpaceID: spaceID, SpaceName: spaceName})
+			}
+			last := &spaces[len(spaces)-1]
+			last.Totals = append(last.Totals, t)
+
+			if overall[t.Currency] == nil {
+				overall[t.Currency] = &ValueTotal{Currency: t.Currency}
+				currencies = append(currencies, t.Currency)
+			}
+			overall[t.Currency].Total += t.Total
+			overall[t.Currency].Items += t.Items
+		}
+
+		totals := []ValueTotal{}
+		for _, cur := range currencies {
+			totals = append(totals, *overall[cur])
+		}
+
+		var unpriced int
+		if err := db.QueryRow(`
+			SELECT COUNT(*) FROM garage_items
+			WHERE deleted_at IS NULL AND (purchase_price IS NULL OR currency IS NULL)
+		`).Scan(&unpriced); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.JSON(fiber.Map{
+			"spaces":         spaces,
+			"totals":         totals,
+			"unpriced_items": unpriced,
+		})
+	}
+}
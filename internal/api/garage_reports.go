@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/report"
+	"github.com/gofiber/fiber/v2"
+)
+
+// thumbnails in the PDF are drawn at 60pt; 160px keeps them sharp when printed
+const reportThumbnailPx = 160
+
+// GET /garage/reports/inventory?format=pdf|csv&space_id=1&depreciation_rate=0.2
+// Home-inventory report for insurers. Rows are read from a cursor and written
+// straight to the response, so large garages never sit in memory.
+func garageInventoryReport(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		format := c.Query("format", "pdf")
+		if format != "pdf" && format != "csv" {
+			return fiber.NewError(fiber.StatusBadRequest, "format must be pdf or csv")
+		}
+
+		rate := 0.2
+		if v := c.Query("depreciation_rate"); v != "" {
+			r, err := strconv.ParseFloat(v, 64)
+			if err != nil || r < 0 || r >= 1 {
+				return fiber.NewError(fiber.StatusBadRequest, "depreciation_rate must be in [0, 1)")
+			}
+			rate = r
+		}
+
+		scope := "All spaces"
+		var spaceID *int64
+		if v := c.Query("space_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid space_id")
+			}
+			var name string
+			err = db.QueryRow(`SELECT name FROM garage_spaces WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&name)
+			if err == sql.ErrNoRows {
+				return fiber.NewError(fiber.StatusNotFound, "space not found")
+			}
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			spaceID = &id
+			scope = "Space: " + name
+		}
+
+		now := time.Now().UTC()
+		baseURL := c.BaseURL()
+		filename := fmt.Sprintf("inventory-%s.%s", now.Format("2006-01-02"), format)
+		if format == "pdf" {
+			c.Set(fiber.HeaderContentType, "application/pdf")
+		} else {
+			c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		}
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+		// the stream writer runs after the handler returns, so it can't use the
+		// request context; a failed flush is how we learn the client went away
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			flush := func() error {
+				if err := w.Flush(); err != nil {
+					// before rows.Close, so the query is cancelled rather than drained
+					cancel()
+					return err
+				}
+				return nil
+			}
+
+			var out report.InventoryWriter
+			if format == "pdf" {
+				subtitle := fmt.Sprintf("%s - generated %s UTC - values depreciated %.0f%%/year",
+					scope, now.Format("2006-01-02 15:04"), rate*100)
+				out = report.NewInventoryPDF(w, "Home inventory", subtitle)
+			} else {
+				var err error
+				if out, err = report.NewInventoryCSV(w); err != nil {
+					log.Printf("inventory report: %v", err)
+					return
+				}
+			}
+
+			if err := streamInventory(ctx, db, out, flush, spaceID, rate, now, baseURL, format == "pdf"); err != nil {
+				log.Printf("inventory report: %v", err)
+				return
+			}
+			if err := out.Close(); err != nil {
+				log.Printf("inventory report: %v", err)
+			}
+			flush()
+		})
+
+		return nil
+	}
+}
+
+// streamInventory writes the rows to out, calling flush after each one; it
+// stops as soon as flush fails.
+func streamInventory(ctx context.Context, db *sql.DB, out report.InventoryWriter, flush func() error, spaceID *int64, rate float64, now time.Time, baseURL string, withThumbnails bool) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT i.id, i.name, s.name, s.location, i.quantity, i.serial_number, i.vendor, i.purchase_date,
+		       i.purchase_price, i.currency, i.warranty_expires,
+		       (SELECT a.id FROM garage_attachments a
+		        WHERE a.item_id = i.id AND a.kind = 'photo' AND a.content_type LIKE 'image/%'
+		        ORDER BY a.id LIMIT 1)
+		FROM garage_items i
+		JOIN garage_spaces s ON s.id = i.space_id
+		WHERE i.deleted_at IS NULL AND ($1::bigint IS NULL OR i.space_id = $1)
+		ORDER BY s.name, s.id, i.name, i.id
+	`, spaceID)
+	if err != nil {
+		return fmt.Errorf("query items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r report.InventoryRow
+		var spaceName string
+		var location *string
+		var photoID *int64
+
+		if err := rows.Scan(&r.ItemID, &r.Name, &spaceName, &location, &r.Quantity, &r.SerialNumber, &r.Vendor,
+			&r.PurchaseDate, &r.PurchasePrice, &r.Currency, &r.WarrantyExpires, &photoID); err != nil {
+			return fmt.Errorf("scan item: %w", err)
+		}
+
+		r.Breadcrumb = spaceName
+		if location != nil && *location != "" {
+			r.Breadcrumb += " > " + *location
+		}
+		if r.PurchasePrice != nil {
+			v := report.Depreciate(*r.PurchasePrice, r.PurchaseDate, rate, now) * float64(r.Quantity)
+			r.EstimatedValue = &v
+		}
+
+		if photoID != nil {
+			r.PhotoURL = fmt.Sprintf("%s/garage/attachments/%d", baseURL, *photoID)
+			if withThumbnails {
+				var data []byte
+				err := db.QueryRowContext(ctx, `SELECT data FROM garage_attachments WHERE id = $1`, *photoID).Scan(&data)
+				if err == nil {
+					r.Thumbnail, r.ThumbnailWidth, r.ThumbnailHeight, err = report.Thumbnail(data, reportThumbnailPx)
+				}
+				if err != nil {
+					// a broken photo shouldn't sink the whole report
+					log.Printf("inventory report: thumbnail for attachment %d: %v", *photoID, err)
+				}
+			}
+		}
+
+		if err := out.WriteRow(r); err != nil {
+			return fmt.Errorf("write row: %w", err)
+		}
+		if err := flush(); err != nil {
+			return fmt.Errorf("client went away: %w", err)
+		}
+	}
+
+	return rows.Err()
+}
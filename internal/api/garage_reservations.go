@@ -0,0 +1,441 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/security"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type GarageReservation struct {
+	ID                 int64   `json:"id"`
+	ItemID             int64   `json:"item_id"`
+	ItemName           string  `json:"item_name"`
+	Quantity           int     `json:"quantity"`
+	ReservedBy         *int64  `json:"reserved_by,omitempty"`
+	ReservedByUsername *string `json:"reserved_by_username,omitempty"`
+	StartsAt           string  `json:"starts_at"`
+	EndsAt             string  `json:"ends_at"`
+	Note               *string `json:"note,omitempty"`
+	CreatedAt          string  `json:"created_at"`
+	CancelledAt        *string `json:"cancelled_at,omitempty"`
+}
+
+const reservationColumns = `
+	r.id, r.item_id, i.name, r.quantity, r.reserved_by, u.username, r.starts_at, r.ends_at,
+	r.note, r.created_at, r.cancelled_at`
+
+const reservationFrom = `
+	FROM garage_reservations r
+	JOIN garage_items i ON i.id = r.item_id AND i.deleted_at IS NULL
+	LEFT JOIN users u ON u.id = r.reserved_by`
+
+func scanReservation(row rowScanner) (GarageReservation, error) {
+	var r GarageReservation
+	var starts, ends, created time.Time
+	var cancelled *time.Time
+
+	err := row.Scan(&r.ID, &r.ItemID, &r.ItemName, &r.Quantity, &r.ReservedBy, &r.ReservedByUsername,
+		&starts, &ends, &r.Note, &created, &cancelled)
+	if err != nil {
+		return r, err
+	}
+	r.StartsAt = starts.UTC().Format(time.RFC3339)
+	r.EndsAt = ends.UTC().Format(time.RFC3339)
+	r.CreatedAt = created.UTC().Format(time.RFC3339)
+	r.CancelledAt = formatOptionalTime(cancelled)
+	return r, nil
+}
+
+var errOverbooked = errors.New("not enough quantity available for that window")
+
+// reservedPeak returns the highest number of units booked at any single
+// instant of [start, end). Bookings only change at their start points, so it
+// is enough to sum the active ones at each start inside the window.
+func reservedPeak(tx *sql.Tx, itemID int64, start, end time.Time) (int, error) {
+	var peak int
+	err := tx.QueryRow(`
+		WITH overlapping AS (
+			SELECT starts_at, ends_at, quantity
+			FROM garage_reservations
+			WHERE item_id = $1 AND cancelled_at IS NULL
+			  AND starts_at < $3 AND ends_at > $2
+		), points AS (
+			SELECT GREATEST(starts_at, $2) AS t FROM overlapping
+			UNION
+			SELECT $2::timestamptz
+		)
+		SELECT COALESCE(MAX((
+			SELECT COALESCE(SUM(o.quantity), 0)
+			FROM overlapping o
+			WHERE o.starts_at <= p.t AND o.ends_at > p.t
+		)), 0)
+		FROM points p
+	`, itemID, start, end).Scan(&peak)
+	return peak, err
+}
+
+// reservedNow returns how many units of the item are booked by reservations
+// running right now.
+func reservedNow(tx *sql.Tx, itemID int64) (int, error) {
+	var n int
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(quantity), 0)
+		FROM garage_reservations
+		WHERE item_id = $1 AND cancelled_at IS NULL
+		  AND starts_at <= NOW() AND ends_at > NOW()
+	`, itemID).Scan(&n)
+	return n, err
+}
+
+// reserveItem books quantity units of an item for [start, end). Loans that are
+// still out during the window (no due date counts as "out indefinitely") are
+// subtracted from the stock as well. It runs SERIALIZABLE and locks the item
+// row, so two concurrent requests for the last unit can't both succeed.
+func reserveItem(ctx context.Context, db *sql.DB, itemID int64, quantity int, start, end time.Time, note *string, actor auditActor) (int64, error) {
+	const maxAttempts = 3
+
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		id, err := tryReserveItem(ctx, db, itemID, quantity, start, end, note, actor)
+		if !isSerializationFailure(err) {
+			return id, err
+		}
+		lastErr = err
+	}
+	return 0, lastErr
+}
+
+func tryReserveItem(ctx context.Context, db *sql.DB, itemID int64, quantity int, start, end time.Time, note *string, actor auditActor) (int64, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var stock int
+	if err := tx.QueryRow(`SELECT quantity FROM garage_items WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, itemID).Scan(&stock); err != nil {
+		return 0, err
+	}
+
+	var lent int
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(quantity), 0)
+		FROM garage_loans
+		WHERE item_id = $1 AND returned_at IS NULL
+		  AND checked_out_at < $3 AND (due_at IS NULL OR due_at > $2)
+	`, itemID, start, end).Scan(&lent)
+	if err != nil {
+		return 0, err
+	}
+
+	peak, err := reservedPeak(tx, itemID, start, end)
+	if err != nil {
+		return 0, err
+	}
+	if stock-lent-peak < quantity {
+		return 0, errOverbooked
+	}
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO garage_reservations (item_id, quantity, reserved_by, starts_at, ends_at, note)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, itemID, quantity, actor.userID, start, end, note).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := scanReservation(tx.QueryRow(`SELECT `+reservationColumns+reservationFrom+` WHERE r.id = $1`, id))
+	if err != nil {
+		return 0, err
+	}
+	if err := actor.record(tx, "item.reserve", "item", itemID, nil, r); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "40001"
+}
+
+// ---------- RESERVATIONS HANDLERS ----------
+
+// POST /garage/items/:id/reservations
+// Body: { "quantity": 1, "starts_at": "2025-06-07T08:00:00Z", "ends_at": "2025-06-07T18:00:00Z", "note": "moving day" }
+func createGarageReservation(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var body struct {
+			Quantity int     `json:"quantity"`
+			StartsAt string  `json:"starts_at"`
+			EndsAt   string  `json:"ends_at"`
+			Note     *string `json:"note"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		if body.Quantity == 0 {
+			body.Quantity = 1
+		}
+		if body.Quantity < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "quantity must be positive")
+		}
+
+		start, err := parseFlexibleTime(body.StartsAt)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "starts_at: "+err.Error())
+		}
+		end, err := parseFlexibleTime(body.EndsAt)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "ends_at: "+err.Error())
+		}
+		if !end.After(start) {
+			return fiber.NewError(fiber.StatusBadRequest, "ends_at must be after starts_at")
+		}
+		if end.Before(time.Now()) {
+			return fiber.NewError(fiber.StatusBadRequest, "reservation window is in the past")
+		}
+
+		resID, err := reserveItem(c.Context(), db, id, body.Quantity, start, end, body.Note, requestActor(c))
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "item not found")
+		}
+		if err == errOverbooked || isSerializationFailure(err) {
+			return fiber.NewError(fiber.StatusConflict, errOverbooked.Error())
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		r, err := scanReservation(db.QueryRow(`SELECT `+reservationColumns+reservationFrom+` WHERE r.id = $1`, resID))
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(r)
+	}
+}
+
+// GET /garage/reservations?from=2025-06-01&to=2025-07-01&item_id=1
+// Without from/to it lists everything that hasn't ended yet.
+func listGarageReservations(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q := `SELECT ` + reservationColumns + reservationFrom + ` WHERE r.cancelled_at IS NULL`
+		args := []any{}
+
+		from := time.Now()
+		if v := c.Query("from"); v != "" {
+			t, err := parseFlexibleTime(v)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "from: "+err.Error())
+			}
+			from = t
+		}
+		args = append(args, from)
+		q += ` AND r.ends_at > $1`
+
+		if v := c.Query("to"); v != "" {
+			t, err := parseFlexibleTime(v)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "to: "+err.Error())
+			}
+			args = append(args, t)
+			q += ` AND r.starts_at < $` + strconv.Itoa(len(args))
+		}
+
+		if v := c.Query("item_id"); v != "" {
+			itemID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid item_id")
+			}
+			args = append(args, itemID)
+			q += ` AND r.item_id = $` + strconv.Itoa(len(args))
+		}
+		q += ` ORDER BY r.starts_at, r.id`
+
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		reservations := []GarageReservation{}
+		for rows.Next() {
+			r, err := scanReservation(rows)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			reservations = append(reservations, r)
+		}
+
+		return c.JSON(reservations)
+	}
+}
+
+// DELETE /garage/reservations/:id  (cancels; the row is kept for the calendar history)
+func cancelGarageReservation(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		var itemID int64
+		err = tx.QueryRow(`
+			UPDATE garage_reservations
+			SET cancelled_at = NOW()
+			WHERE id = $1 AND cancelled_at IS NULL
+			RETURNING item_id
+		`, id).Scan(&itemID)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(fiber.StatusNotFound, "reservation not found")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if err := requestActor(c).record(tx, "item.cancel_reservation", "item", itemID, nil, fiber.Map{"reservation_id": id}); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// GET /garage/reservations/feed
+// Returns a subscribable calendar URL. Calendar apps can't send a Bearer
+// header, so the URL carries the user's calendar token instead: a random
+// secret that stays the same until it is rotated or revoked.
+func garageCalendarFeedURL(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		uid, ok := currentUserID(c)
+		if !ok {
+			return fiber.ErrUnauthorized
+		}
+
+		token, err := security.NewRandomToken(32)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		// the first request creates the token, later ones get the same one
+		err = db.QueryRow(`
+			UPDATE users SET calendar_token = COALESCE(calendar_token, $2) WHERE id = $1
+			RETURNING calendar_token
+		`, uid, token).Scan(&token)
+		if err != nil {
+			return dbError(err, "user not found")
+		}
+
+		return c.JSON(fiber.Map{"url": calendarFeedURL(c, token)})
+	}
+}
+
+// POST /garage/reservations/feed/rotate
+// Replaces the calendar token; subscriptions to the old URL stop working.
+func rotateGarageCalendarFeed(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		uid, ok := currentUserID(c)
+		if !ok {
+			return fiber.ErrUnauthorized
+		}
+
+		token, err := security.NewRandomToken(32)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		res, err := db.Exec(`UPDATE users SET calendar_token = $2 WHERE id = $1`, uid, token)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fiber.NewError(fiber.StatusNotFound, "user not found")
+		}
+
+		return c.JSON(fiber.Map{"url": calendarFeedURL(c, token)})
+	}
+}
+
+// DELETE /garage/reservations/feed
+// Revokes the calendar token until a new feed URL is requested.
+func revokeGarageCalendarFeed(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		uid, ok := currentUserID(c)
+		if !ok {
+			return fiber.ErrUnauthorized
+		}
+
+		if _, err := db.Exec(`UPDATE users SET calendar_token = NULL WHERE id = $1`, uid); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func calendarFeedURL(c *fiber.Ctx, token string) string {
+	return fmt.Sprintf("%s/garage/reservations.ics?token=%s", c.BaseURL(), url.QueryEscape(token))
+}
+
+// GET /garage/reservations.ics?token=...   (public; authenticated by the calendar token)
+func GarageCalendarHandler(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := c.Query("token")
+		if token == "" {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid feed token"})
+		}
+
+		var valid bool
+		if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE calendar_token = $1)`, token).Scan(&valid); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		if !valid {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid feed token"})
+		}
+
+		// keep a month of history so recent bookings don't vanish from calendars
+		rows, err := db.Query(`SELECT ` + reservationColumns + reservationFrom + `
+			WHERE r.cancelled_at IS NULL AND r.ends_at > NOW() - INTERVAL '30 days'
+			ORDER BY r.starts_at, r.id`)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		defer rows.Close()
+
+		var reservations []GarageReservation
+		for rows.Next() {
+			r, err := scanReservation(rows)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "db error"})
+			}
+			reservations = append(reservations, r)
+		}
+
+		c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `inline; filename="garage-reservations.ics"`)
+		return c.SendString(buildReservationsICS(reservations, c.Hostname()))
+	}
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Deleting an item or space only stamps deleted_at; everything else in the
+// API treats such rows as gone. A space takes its live items to the trash
+// with the same timestamp, which is how restoring the space knows which
+// items to bring back. services.PurgeTrash removes rows for good once they
+// are older than TRASH_RETENTION_DAYS.
+
+// requireLiveSpace fails with a validation error when spaceID is missing or trashed.
+func requireLiveSpace(tx *sql.Tx, spaceID int64) error {
+	var live bool
+	err := tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM garage_spaces WHERE id = $1 AND deleted_at IS NULL)
+	`, spaceID).Scan(&live)
+	if err != nil {
+		return err
+	}
+	if !live {
+		return &ValidationError{Fields: map[string]string{"space_id": "does not exist"}}
+	}
+	return nil
+}
+
+// trashGarageItem moves a live item to the trash. ifMatch ("" to skip) is
+// checked against its version. Returns sql.ErrNoRows for unknown or
+// already-trashed items.
+func trashGarageItem(tx *sql.Tx, id int64, ifMatch string) error {
+	var version int
+	err := tx.QueryRow(`
+		SELECT version FROM garage_items WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, id).Scan(&version)
+	if err != nil {
+		return err
+	}
+	if ifMatch != "" && !etagMatches(ifMatch, version) {
+		return errVersionMismatch
+	}
+
+	_, err = tx.Exec(`UPDATE garage_items SET deleted_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// trashGarageSpace moves a live space and its live items to the trash,
+// recording before (the space as loaded) in the audit log.
+func trashGarageSpace(tx *sql.Tx, before GarageSpace, actor auditActor) error {
+	id := before.ID
+
+	// NOW() is fixed for the transaction, so space and items share the timestamp
+	itemIDs, err := queryIDs(tx, `
+		UPDATE garage_items SET deleted_at = NOW() WHERE space_id = $1 AND deleted_at IS NULL
+		RETURNING id
+	`, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE garage_spaces SET deleted_at = NOW() WHERE id = $1`, id); err != nil {
+		return err
+	}
+
+	after, err := loadGarageSpace(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := actor.record(tx, "space.delete", "space", id, before, after); err != nil {
+		return err
+	}
+	for _, itemID := range itemIDs {
+		err := actor.record(tx, "item.delete", "item", itemID,
+			fiber.Map{"deleted_at": nil}, fiber.Map{"deleted_at": after.DeletedAt, "with_space": id})
+		if err != nil {
+			return err
+		}
+		if err := queueItemEvent(tx, "item.deleted", itemID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DELETE /garage/spaces/:id   (If-Match required; the space and its items go to the trash)
+func deleteGarageSpace(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+		ifMatch, err := requireIfMatch(c)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		before, err := loadGarageSpace(tx, id)
+		if err == nil && before.DeletedAt != nil {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			return dbError(err, "space not found")
+		}
+		if !etagMatches(ifMatch, before.Version) {
+			return fiber.NewError(fiber.StatusPreconditionFailed, errVersionMismatch.Error())
+		}
+
+		if err := trashGarageSpace(tx, before, requestActor(c)); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// POST /garage/items/:id/restore
+func restoreGarageItem(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		before, err := loadGarageItem(tx, id)
+		if err == nil && before.DeletedAt == nil {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			return dbError(err, "item not found in trash")
+		}
+
+		var spaceTrashed bool
+		err = tx.QueryRow(`SELECT deleted_at IS NOT NULL FROM garage_spaces WHERE id = $1`, before.SpaceID).Scan(&spaceTrashed)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if spaceTrashed {
+			return fiber.NewError(fiber.StatusConflict, "the item's space is in the trash; restore the space first")
+		}
+
+		if _, err := tx.Exec(`UPDATE garage_items SET deleted_at = NULL, updated_at = NOW() WHERE id = $1`, id); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		it, err := loadGarageItem(tx, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := requestActor(c).record(tx, "item.restore", "item", id, before, it); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := webhooks.Enqueue(tx, "item.updated", it); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		c.Set(fiber.HeaderETag, etagFor(it.Version))
+		return c.JSON(it)
+	}
+}
+
+// POST /garage/spaces/:id/restore
+// Brings back the space and the items that were trashed together with it;
+// items deleted on their own before that stay in the trash.
+func restoreGarageSpace(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		before, err := loadGarageSpace(tx, id)
+		if err == nil && before.DeletedAt == nil {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			return dbError(err, "space not found in trash")
+		}
+
+		itemIDs, err := queryIDs(tx, `
+			UPDATE garage_items SET deleted_at = NULL, updated_at = NOW()
+			WHERE space_id = $1 AND deleted_at = (SELECT deleted_at FROM garage_spaces WHERE id = $1)
+			RETURNING id
+		`, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		if _, err := tx.Exec(`UPDATE garage_spaces SET deleted_at = NULL WHERE id = $1`, id); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		s, err := loadGarageSpace(tx, id)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		actor := requestActor(c)
+		if err := actor.record(tx, "space.restore", "space", id, before, s); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		for _, itemID := range itemIDs {
+			err := actor.record(tx, "item.restore", "item", itemID,
+				fiber.Map{"deleted_at": before.DeletedAt}, fiber.Map{"deleted_at": nil, "with_space": id})
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			if err := queueItemEvent(tx, "item.updated", itemID); err != nil {
+				return fiber.ErrInternalServerError
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		c.Set(fiber.HeaderETag, etagFor(s.Version))
+		return c.JSON(fiber.Map{"space": s, "restored_items": len(itemIDs)})
+	}
+}
+
+// queryIDs runs a query returning a single id column (e.g. UPDATE ... RETURNING id).
+func queryIDs(tx *sql.Tx, query string, args ...any) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GET /garage/trash?limit=100
+// Newest deletions first.
+func listGarageTrash(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 100)
+		if limit <= 0 || limit > 500 {
+			limit = 100
+		}
+
+		spaceRows, err := db.Query(`
+			SELECT `+garageSpaceColumns+`
+			FROM garage_spaces
+			WHERE deleted_at IS NOT NULL
+			ORDER BY deleted_at DESC, id DESC
+			LIMIT $1
+		`, limit)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer spaceRows.Close()
+
+		spaces := []GarageSpace{}
+		for spaceRows.Next() {
+			s, err := scanGarageSpace(spaceRows)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			spaces = append(spaces, s)
+		}
+
+		itemRows, err := db.Query(`SELECT `+garageItemColumns+garageItemFrom+`
+			WHERE i.deleted_at IS NOT NULL
+			ORDER BY i.deleted_at DESC, i.id DESC
+			LIMIT $1
+		`, limit)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer itemRows.Close()
+
+		items := []GarageItem{}
+		for itemRows.Next() {
+			it, err := scanGarageItem(itemRows)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			items = append(items, it)
+		}
+
+		return c.JSON(fiber.Map{"spaces": spaces, "items": items})
+	}
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The background job queue lives in services; owners can see what it's doing
+// and put dead jobs back in line once whatever broke them is fixed.
+
+// Job is a queued job without its payload, which can carry tokens and
+// addresses.
+type Job struct {
+	ID         int64   `json:"id"`
+	Kind       string  `json:"kind"`
+	Status     string  `json:"status"` // pending, done, dead
+	Attempts   int     `json:"attempts"`
+	RunAt      *string `json:"run_at,omitempty"` // pending only
+	LastError  *string `json:"last_error,omitempty"`
+	CreatedAt  string  `json:"created_at"`
+	FinishedAt *string `json:"finished_at,omitempty"`
+}
+
+const jobColumns = `id, kind, status, attempts, run_at, last_error, created_at, finished_at`
+
+var jobSorts = map[string]sortColumn{
+	"id": {"id", "bigint"},
+}
+
+func scanJob(row rowScanner) (Job, error) {
+	var j Job
+	var runAt, created time.Time
+	var finished *time.Time
+	if err := row.Scan(&j.ID, &j.Kind, &j.Status, &j.Attempts, &runAt, &j.LastError, &created, &finished); err != nil {
+		return j, err
+	}
+	if j.Status == "pending" {
+		s := runAt.UTC().Format(time.RFC3339)
+		j.RunAt = &s
+	}
+	j.CreatedAt = created.UTC().Format(time.RFC3339)
+	j.FinishedAt = formatOptionalTime(finished)
+	return j, nil
+}
+
+// GET /jobs?status=dead&kind=email.&sort=-id&limit=&cursor=
+// Owners only. kind ending in "." matches a prefix.
+func listJobs(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can see background jobs"); err != nil {
+			return err
+		}
+		page, err := parseListPage(c, jobSorts, "id")
+		if err != nil {
+			return err
+		}
+
+		conds := []string{}
+		args := []any{}
+		switch status := c.Query("status"); status {
+		case "":
+		case "pending", "done", "dead":
+			args = append(args, status)
+			conds = append(conds, fmt.Sprintf("status = $%d", len(args)))
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "status must be pending, done or dead")
+		}
+		if kind := c.Query("kind"); kind != "" {
+			if strings.HasSuffix(kind, ".") {
+				args = append(args, escapeLike(kind)+"%")
+				conds = append(conds, fmt.Sprintf("kind LIKE $%d", len(args)))
+			} else {
+				args = append(args, kind)
+				conds = append(conds, fmt.Sprintf("kind = $%d", len(args)))
+			}
+		}
+		if cond, a := page.where(args); cond != "" {
+			conds, args = append(conds, cond), a
+		}
+
+		q := `SELECT ` + jobColumns + page.sortKeyColumn() + ` FROM jobs`
+		if len(conds) > 0 {
+			q += ` WHERE ` + strings.Join(conds, " AND ")
+		}
+		q += page.orderLimit()
+
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		jobs := []Job{}
+		var n int
+		var lastKey string
+		for rows.Next() {
+			var key string
+			j, err := scanJob(keyedRow{rows, &key})
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			if n++; n > page.limit {
+				break
+			}
+			jobs = append(jobs, j)
+			lastKey = key
+		}
+
+		var next *string
+		if len(jobs) > 0 {
+			next = page.next(n, lastKey, jobs[len(jobs)-1].ID)
+		}
+		return c.JSON(fiber.Map{"jobs": jobs, "next_cursor": next})
+	}
+}
+
+// POST /jobs/:id/retry
+// Puts a dead job back in the queue with a fresh set of attempts.
+func retryJob(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can see background jobs"); err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		var status string
+		if err := tx.QueryRow(`SELECT status FROM jobs WHERE id = $1 FOR UPDATE`, id).Scan(&status); err != nil {
+			return dbError(err, "job not found")
+		}
+		if status != "dead" {
+			return fiber.NewError(fiber.StatusConflict, "only dead jobs can be retried")
+		}
+
+		j, err := scanJob(tx.QueryRow(`
+			UPDATE jobs
+			SET status = 'pending', attempts = 0, run_at = NOW(), finished_at = NULL
+			WHERE id = $1
+			RETURNING `+jobColumns, id))
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := requestActor(c).record(tx, "job.retry", "job", id, nil, j); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(j)
+	}
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Documents here are what encoding/json produces when decoding into any:
+// map[string]any, []any, string, float64, bool and nil.
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch: objects merge
+// recursively, null removes a member, anything else replaces.
+func applyMergePatch(target, patch any) any {
+	pm, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	out := map[string]any{}
+	if tm, ok := target.(map[string]any); ok {
+		for k, v := range tm {
+			out[k] = v
+		}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(out, k)
+		} else {
+			out[k] = applyMergePatch(out[k], v)
+		}
+	}
+	return out
+}
+
+// jsonPatchOp is one RFC 6902 operation.
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"` // "null" when the client sent null, empty when absent
+}
+
+// errPatch is a patch the document can't take (bad path, failed test...).
+type errPatch struct{ msg string }
+
+func (e errPatch) Error() string { return e.msg }
+
+func patchErrorf(format string, args ...any) error {
+	return errPatch{msg: fmt.Sprintf(format, args...)}
+}
+
+// applyJSONPatch applies the operations in order; on any failure the
+// original document is left untouched and the error says which op failed.
+func applyJSONPatch(doc any, ops []jsonPatchOp) (any, error) {
+	doc = deepCopyJSON(doc)
+	for i, op := range ops {
+		var err error
+		doc, err = applyJSONPatchOp(doc, op)
+		if err != nil {
+			return nil, patchErrorf("operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyJSONPatchOp(doc any, op jsonPatchOp) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func() (any, error) {
+		if len(op.Value) == 0 {
+			return nil, errors.New("value is required")
+		}
+		var v any
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, errors.New("invalid value")
+		}
+		return v, nil
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+
+	case "remove":
+		return pointerRemove(doc, path)
+
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if isPointerPrefix(from, path) && len(from) < len(path) {
+				return nil, errors.New("cannot move a value into itself")
+			}
+			if doc, err = pointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			v = deepCopyJSON(v)
+		}
+		return pointerAdd(doc, path, v)
+
+	case "test":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(got, v) {
+			return nil, errors.New("test failed")
+		}
+		return doc, nil
+	}
+
+	return nil, errors.New("op must be add, remove, replace, move, copy or test")
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid path %q", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPointerPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func arrayIndex(token string, n int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	max := n - 1
+	if allowEnd {
+		max = n
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func pointerGet(doc any, path []string) (any, error) {
+	for _, t := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("path member %q does not exist", t)
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into %q", t)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd returns doc with v added at path (arrays insert, objects set).
+func pointerAdd(doc any, path []string, v any) (any, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	t, rest := path[0], path[1:]
+
+	switch node := doc.(type) {
+	case map[string]any:
+		if len(rest) == 0 {
+			node[t] = v
+			return node, nil
+		}
+		child, ok := node[t]
+		if !ok {
+			return nil, fmt.Errorf("path member %q does not exist", t)
+		}
+		child, err := pointerAdd(child, rest, v)
+		if err != nil {
+			return nil, err
+		}
+		node[t] = child
+		return node, nil
+
+	case []any:
+		if len(rest) == 0 {
+			i, err := arrayIndex(t, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = v
+			return node, nil
+		}
+		i, err := arrayIndex(t, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := pointerAdd(node[i], rest, v)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("cannot descend into %q", t)
+}
+
+// pointerRemove returns doc without the value at path, which must exist.
+func pointerRemove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	t, rest := path[0], path[1:]
+
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[t]
+		if !ok {
+			return nil, fmt.Errorf("path member %q does not exist", t)
+		}
+		if len(rest) == 0 {
+			delete(node, t)
+			return node, nil
+		}
+		child, err := pointerRemove(child, rest)
+		if err != nil {
+			return nil, err
+		}
+		node[t] = child
+		return node, nil
+
+	case []any:
+		i, err := arrayIndex(t, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			return append(node[:i], node[i+1:]...), nil
+		}
+		child, err := pointerRemove(node[i], rest)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("cannot descend into %q", t)
+}
+
+func deepCopyJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, x := range v {
+			out[k] = deepCopyJSON(x)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, x := range v {
+			out[i] = deepCopyJSON(x)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"database/sql"
+
+	"github.com/BlaccStacc/blaccend/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every user decides how notifications reach them; the delivery side lives
+// in services (Notify and the digest jobs).
+
+type NotificationPreference struct {
+	Event   string `json:"event"`
+	Channel string `json:"channel"` // email, webhook, none
+	Cadence string `json:"cadence"` // instant, daily, weekly
+	Locked  bool   `json:"locked"`  // security: can't be turned off
+}
+
+func notificationPreferenceResponse(p services.NotificationPreference) NotificationPreference {
+	return NotificationPreference{
+		Event:   p.Event,
+		Channel: p.Channel,
+		Cadence: p.Cadence,
+		Locked:  p.Event == services.EventSecurity,
+	}
+}
+
+// GET /notifications/preferences
+// The caller's preference for every event, defaults included.
+func listNotificationPreferences(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return fiber.ErrUnauthorized
+		}
+
+		prefs, err := services.NotificationPreferences(c.Context(), db, userID)
+		if err != nil {
+			return dbError(err, "user not found")
+		}
+		out := make([]NotificationPreference, 0, len(prefs))
+		for _, p := range prefs {
+			out = append(out, notificationPreferenceResponse(p))
+		}
+		return c.JSON(fiber.Map{"preferences": out})
+	}
+}
+
+// PUT /notifications/preferences/:event
+// Body: { "channel": "email", "cadence": "weekly" }
+func updateNotificationPreference(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return fiber.ErrUnauthorized
+		}
+		event := c.Params("event")
+		if !services.IsNotificationEvent(event) {
+			return fiber.NewError(fiber.StatusNotFound, "no such notification event")
+		}
+
+		var body struct {
+			Channel string `json:"channel"`
+			Cadence string `json:"cadence"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		pref := services.NotificationPreference{Event: event, Channel: body.Channel, Cadence: body.Cadence}
+		if err := pref.Validate(); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		if err := services.SetNotificationPreference(db, userID, pref); err != nil {
+			return dbError(err, "user not found")
+		}
+		return c.JSON(notificationPreferenceResponse(pref))
+	}
+}
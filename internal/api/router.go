@@ -1,17 +1,24 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"log"
 
+	"github.com/BlaccStacc/blaccend/internal/audit"
+	"github.com/BlaccStacc/blaccend/internal/changelog"
+	"github.com/BlaccStacc/blaccend/internal/config"
+	"github.com/BlaccStacc/blaccend/internal/geoip"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 )
 
-func RegisterRoutes(app *fiber.App, db *sql.DB) {
+func RegisterRoutes(app *fiber.App, db *sql.DB, cfg *config.Config) {
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "http://localhost:5173",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
+		AllowOrigins:  "http://localhost:5173",
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization, Last-Event-ID, X-Device-ID, If-Match, If-None-Match",
+		AllowMethods:  "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		ExposeHeaders: "ETag, Link, X-Next-Cursor", // If-Match and list paging
 	}))
 
 	app.Get("/health", GetHealth)
@@ -22,19 +29,67 @@ func RegisterRoutes(app *fiber.App, db *sql.DB) {
 	app.Get("/users/:id", GetUser(db))
 
 	// AUTH
+	// countries for new-login alerts; without a database only device and
+	// network are compared
+	geo, err := geoip.Open(cfg.GeoIPDB)
+	if err != nil {
+		log.Printf("geoip disabled: %v", err)
+	}
 	app.Post("/auth/register", RegisterHandler(db))
-	app.Post("/auth/login", LoginHandler(db))        // step 1 login
-	app.Post("/auth/login/2fa", Login2FAHandler(db)) // step 2 login with TOTP
+	app.Post("/auth/login", LoginHandler(db, geo))        // step 1 login
+	app.Post("/auth/login/2fa", Login2FAHandler(db, geo)) // step 2 login with TOTP
 	app.Get("/auth/verify-email", VerifyEmailHandler(db))
+	app.Post("/auth/password/forgot", ForgotPasswordHandler(db))
+	app.Post("/auth/password/reset", ResetPasswordHandler(db))
+	app.Post("/auth/login-alert/deny", DenyLoginHandler(db)) // "this wasn't me"
+
+	// calendar apps can't send a Bearer header; the feed URL carries its own token
+	app.Get("/garage/reservations.ics", GarageCalendarHandler(db))
+
+	// live changes; EventSource can't send headers either, see eventStreamAuth
+	changes := changelog.NewHub(db)
+	go changes.Run(context.Background())
+	app.Get("/garage/events", eventStreamAuth(db), garageEventStream(db, changes))
 
 	// AUTHENTICATED ROUTES
-	protected := app.Group("", AuthMiddleware()) // require JWT
+	protected := app.Group("", AuthMiddleware(db)) // require JWT
 	protected.Get("/auth/me", MeHandler())
+	protected.Put("/auth/me/locale", UpdateLocaleHandler(db))
 
 	// 🔹 TOTP setup (authenticator app)
 	protected.Get("/auth/2fa/setup", TwoFASetupHandler(db))
 	protected.Post("/auth/2fa/confirm", TwoFAConfirmHandler(db))
 
+	// audit log (owners)
+	var auditKey *audit.SigningKey
+	if key, err := audit.LoadSigningKey(cfg); err != nil {
+		log.Printf("audit verification disabled: %v", err)
+	} else {
+		auditKey = &key
+	}
+	protected.Get("/audit", listAuditLog(db))
+	protected.Get("/audit/verify", verifyAuditLog(db, auditKey))
+
+	// outbound webhooks (owners)
+	protected.Get("/webhooks", listWebhookEndpoints(db))
+	protected.Post("/webhooks", createWebhookEndpoint(db))
+	protected.Patch("/webhooks/:id", updateWebhookEndpoint(db))
+	protected.Delete("/webhooks/:id", deleteWebhookEndpoint(db))
+	protected.Get("/webhooks/:id/deliveries", listWebhookDeliveries(db))
+	protected.Post("/webhooks/deliveries/:id/replay", replayWebhookDelivery(db))
+
+	// background jobs (owners)
+	protected.Get("/jobs", listJobs(db))
+	protected.Post("/jobs/:id/retry", retryJob(db))
+
+	// notification preferences (own)
+	protected.Get("/notifications/preferences", listNotificationPreferences(db))
+	protected.Put("/notifications/preferences/:event", updateNotificationPreference(db))
+
+	// offline sync
+	protected.Get("/sync", pullSync(db))
+	protected.Post("/sync", pushSync(db))
+
 	// 🔹 GARAGE STORAGE ROUTES (nou)
 	RegisterGarageRoutes(protected, db)
 }
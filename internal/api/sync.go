@@ -0,0 +1,630 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/BlaccStacc/blaccend/internal/changelog"
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Offline sync for clients that keep a local copy of the garage. GET /sync
+// returns what changed since a token (a change-log seq, see
+// internal/changelog); POST /sync applies the mutations a client queued
+// while offline.
+//
+// Conflict policy, decided per mutation:
+//
+//   - update and delete carry base_version, the version the client last saw.
+//     If the record has moved on since, the server wins: nothing is applied
+//     and the result is "conflict" with the current record in "server", for
+//     the client to reapply its change on top of and resend.
+//   - updating or adjusting a record that was deleted on the server is a
+//     conflict without a server record; the client should drop its copy.
+//   - deleting a record that is already gone is "applied": same outcome.
+//   - creates always apply.
+//   - item "adjust" ({"delta": -2, "reason": "used"}) books a stock movement.
+//     Movements commute, so adjust needs no base_version and never conflicts.
+//   - anything invalid (including stock going below zero) is "rejected" with
+//     an error and changes nothing.
+//
+// Mutations run in order and independently: a conflict or rejection doesn't
+// stop the rest. Applied results are remembered by mutation_id, so resending
+// a batch whose response was lost returns the same results instead of
+// applying anything twice.
+
+const (
+	syncPageSize    = 500
+	maxSyncPageSize = 1000
+)
+
+// syncPage is the body of GET /sync. Items and spaces are full records;
+// deleted lists ids the client should drop.
+type syncPage struct {
+	Spaces  []GarageSpace `json:"spaces"`
+	Items   []GarageItem  `json:"items"`
+	Deleted struct {
+		Spaces []int64 `json:"spaces"`
+		Items  []int64 `json:"items"`
+	} `json:"deleted"`
+	Next    string `json:"next"`     // pass as ?since= next time
+	HasMore bool   `json:"has_more"` // more changes after next; ask again right away
+	Full    bool   `json:"full"`     // a full snapshot: replace local state
+}
+
+// GET /sync?since=<token>&limit=500
+// Without since it returns every live space and item. A token too old for
+// the change log answers 410 Gone: start over without since.
+func pullSync(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", syncPageSize)
+		if limit <= 0 || limit > maxSyncPageSize {
+			limit = syncPageSize
+		}
+
+		var since int64
+		token := c.Query("since")
+		if token != "" {
+			n, err := strconv.ParseInt(token, 10, 64)
+			if err != nil || n < 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid since token")
+			}
+			since = n
+		}
+
+		// one snapshot for the log and the records it points at
+		tx, err := db.BeginTx(c.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		page := syncPage{Spaces: []GarageSpace{}, Items: []GarageItem{}}
+		page.Deleted.Spaces = []int64{}
+		page.Deleted.Items = []int64{}
+
+		if token == "" {
+			head, err := changelog.Head(c.Context(), tx)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			if page.Spaces, err = querySyncSpaces(tx, `deleted_at IS NULL`); err != nil {
+				return fiber.ErrInternalServerError
+			}
+			if page.Items, err = querySyncItems(tx, `i.deleted_at IS NULL`); err != nil {
+				return fiber.ErrInternalServerError
+			}
+			page.Next = strconv.FormatInt(head, 10)
+			page.Full = true
+			return c.JSON(page)
+		}
+
+		changes, err := changelog.Since(c.Context(), tx, since, limit)
+		if err == changelog.ErrTooOld {
+			return fiber.NewError(fiber.StatusGone, "sync token expired, start over without since")
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		// the records as they are now; anything not live any more was deleted
+		spaceIDs, itemIDs := []int64{}, []int64{}
+		seen := map[string]bool{}
+		for _, ch := range changes {
+			key := fmt.Sprintf("%s:%d", ch.EntityType, ch.EntityID)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if ch.EntityType == "space" {
+				spaceIDs = append(spaceIDs, ch.EntityID)
+			} else {
+				itemIDs = append(itemIDs, ch.EntityID)
+			}
+		}
+
+		if len(spaceIDs) > 0 {
+			if page.Spaces, err = querySyncSpaces(tx, `id = ANY($1) AND deleted_at IS NULL`, spaceIDs); err != nil {
+				return fiber.ErrInternalServerError
+			}
+		}
+		if len(itemIDs) > 0 {
+			if page.Items, err = querySyncItems(tx, `i.id = ANY($1) AND i.deleted_at IS NULL`, itemIDs); err != nil {
+				return fiber.ErrInternalServerError
+			}
+		}
+
+		live := map[int64]bool{}
+		for _, s := range page.Spaces {
+			live[s.ID] = true
+		}
+		for _, id := range spaceIDs {
+			if !live[id] {
+				page.Deleted.Spaces = append(page.Deleted.Spaces, id)
+			}
+		}
+		live = map[int64]bool{}
+		for _, it := range page.Items {
+			live[it.ID] = true
+		}
+		for _, id := range itemIDs {
+			if !live[id] {
+				page.Deleted.Items = append(page.Deleted.Items, id)
+			}
+		}
+
+		page.Next = token
+		if len(changes) > 0 {
+			page.Next = strconv.FormatInt(changes[len(changes)-1].Seq, 10)
+		}
+		page.HasMore = len(changes) == limit
+		return c.JSON(page)
+	}
+}
+
+func querySyncSpaces(tx *sql.Tx, where string, args ...any) ([]GarageSpace, error) {
+	rows, err := tx.Query(`SELECT `+garageSpaceColumns+` FROM garage_spaces WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	spaces := []GarageSpace{}
+	for rows.Next() {
+		s, err := scanGarageSpace(rows)
+		if err != nil {
+			return nil, err
+		}
+		spaces = append(spaces, s)
+	}
+	return spaces, rows.Err()
+}
+
+func querySyncItems(tx *sql.Tx, where string, args ...any) ([]GarageItem, error) {
+	rows, err := tx.Query(`SELECT `+garageItemColumns+garageItemFrom+` WHERE `+where+` ORDER BY i.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []GarageItem{}
+	for rows.Next() {
+		it, err := scanGarageItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// syncMutation is one entry of POST /sync:
+//
+//	{"mutation_id": "c1", "type": "space", "op": "create", "data": {"name": "Shed"}}
+//	{"mutation_id": "c2", "type": "item", "op": "create", "space_ref": "c1", "data": {"name": "Saw"}}
+//	{"mutation_id": "c3", "type": "item", "op": "update", "id": 7, "base_version": 4, "data": {"notes": null}}
+//	{"mutation_id": "c4", "type": "item", "op": "adjust", "id": 7, "data": {"delta": -1, "reason": "used"}}
+//	{"mutation_id": "c5", "type": "space", "op": "delete", "id": 3, "base_version": 2}
+//
+// Update data is a merge patch, as PATCH takes it. space_ref names the
+// mutation_id of a space create (in this batch or an earlier one) for items
+// created in a space the server hasn't assigned an id to yet.
+type syncMutation struct {
+	MutationID  string          `json:"mutation_id"` // unique per client
+	Type        string          `json:"type"`        // item | space
+	Op          string          `json:"op"`          // create | update | delete | adjust (items)
+	ID          int64           `json:"id"`
+	BaseVersion *int            `json:"base_version"`
+	SpaceRef    string          `json:"space_ref"`
+	Data        json.RawMessage `json:"data"`
+}
+
+type SyncResult struct {
+	MutationID string `json:"mutation_id"`
+	Status     string `json:"status"` // applied | conflict | rejected
+	ID         int64  `json:"id,omitempty"`
+	Version    int    `json:"version,omitempty"` // after the mutation; absent for deletes
+	Error      string `json:"error,omitempty"`
+	Server     any    `json:"server,omitempty"` // conflicts: the server's record, absent if deleted
+}
+
+func syncApplied(m syncMutation, id int64, version int) (SyncResult, error) {
+	return SyncResult{MutationID: m.MutationID, Status: "applied", ID: id, Version: version}, nil
+}
+
+func syncConflict(m syncMutation, server any, msg string) (SyncResult, error) {
+	return SyncResult{MutationID: m.MutationID, Status: "conflict", ID: m.ID, Error: msg, Server: server}, nil
+}
+
+func syncRejected(m syncMutation, msg string) (SyncResult, error) {
+	return SyncResult{MutationID: m.MutationID, Status: "rejected", ID: m.ID, Error: msg}, nil
+}
+
+// syncFailure rejects the mutation for errors the client can fix and passes
+// anything else through.
+func syncFailure(m syncMutation, err error) (SyncResult, error) {
+	var opErr errBatchOp
+	if errors.As(batchOpError(err), &opErr) {
+		return syncRejected(m, opErr.msg)
+	}
+	return SyncResult{}, err
+}
+
+// POST /sync   {"mutations": [...]}   -> {"results": [...]} in the same order
+func pushSync(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		uid, ok := currentUserID(c)
+		if !ok {
+			return fiber.ErrUnauthorized
+		}
+
+		var body struct {
+			Mutations []syncMutation `json:"mutations"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		if len(body.Mutations) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "mutations is required")
+		}
+		if len(body.Mutations) > maxBatchOps {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("at most %d mutations per request", maxBatchOps))
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		s := syncSession{tx: tx, actor: requestActor(c), userID: uid}
+		results := make([]SyncResult, len(body.Mutations))
+		for i, m := range body.Mutations {
+			if results[i], err = s.apply(m); err != nil {
+				return dbError(err, "")
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(fiber.Map{"results": results})
+	}
+}
+
+// syncSession applies one request's mutations inside its transaction.
+type syncSession struct {
+	tx     *sql.Tx
+	actor  auditActor
+	userID int64
+}
+
+// apply runs m in a savepoint, so a conflict or rejection undoes only m.
+func (s *syncSession) apply(m syncMutation) (SyncResult, error) {
+	if m.MutationID == "" || len(m.MutationID) > maxShortText {
+		return syncRejected(m, fmt.Sprintf("mutation_id is required (at most %d characters)", maxShortText))
+	}
+
+	// a resend of something already applied gets the original result
+	var stored []byte
+	err := s.tx.QueryRow(`
+		SELECT result FROM sync_mutations WHERE user_id = $1 AND mutation_id = $2
+	`, s.userID, m.MutationID).Scan(&stored)
+	if err == nil {
+		var r SyncResult
+		return r, json.Unmarshal(stored, &r)
+	}
+	if err != sql.ErrNoRows {
+		return SyncResult{}, err
+	}
+
+	if _, err := s.tx.Exec(`SAVEPOINT sync_mutation`); err != nil {
+		return SyncResult{}, err
+	}
+	r, err := s.run(m)
+	if err != nil {
+		return r, err
+	}
+	if r.Status != "applied" {
+		_, err := s.tx.Exec(`ROLLBACK TO SAVEPOINT sync_mutation`)
+		return r, err
+	}
+
+	stored, err = json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+	_, err = s.tx.Exec(`
+		INSERT INTO sync_mutations (user_id, mutation_id, action, result)
+		VALUES ($1, $2, $3, $4)
+	`, s.userID, m.MutationID, m.Type+"."+m.Op, string(stored))
+	if err != nil {
+		return r, err
+	}
+	_, err = s.tx.Exec(`RELEASE SAVEPOINT sync_mutation`)
+	return r, err
+}
+
+func (s *syncSession) run(m syncMutation) (SyncResult, error) {
+	if m.Op != "create" && m.ID <= 0 {
+		return syncRejected(m, "id is required")
+	}
+	if (m.Op == "update" || m.Op == "delete") && m.BaseVersion == nil {
+		return syncRejected(m, "base_version is required")
+	}
+
+	switch m.Type + "." + m.Op {
+	case "item.create":
+		return s.createItem(m)
+	case "item.update":
+		return s.updateItem(m)
+	case "item.delete":
+		return s.deleteItem(m)
+	case "item.adjust":
+		return s.adjustItem(m)
+	case "space.create":
+		return s.createSpace(m)
+	case "space.update":
+		return s.updateSpace(m)
+	case "space.delete":
+		return s.deleteSpace(m)
+	}
+	return syncRejected(m, "type must be item or space, op create, update, delete or (items) adjust")
+}
+
+// syncChanges applies data, a merge patch, to resource and returns the
+// changed writable fields.
+func syncChanges(data json.RawMessage, resource any, fields map[string]patchField) (map[string]any, error) {
+	var patch map[string]any
+	if err := json.Unmarshal(data, &patch); err != nil || patch == nil {
+		return nil, errBatchOp{"data must be an object"}
+	}
+	before, err := patchDocument(resource, fields)
+	if err != nil {
+		return nil, err
+	}
+	after := applyMergePatch(deepCopyJSON(before), patch).(map[string]any)
+	return changedFields(before, after, fields)
+}
+
+// ---------- items ----------
+
+func (s *syncSession) createItem(m syncMutation) (SyncResult, error) {
+	var it newGarageItem
+	if err := json.Unmarshal(m.Data, &it); err != nil {
+		return syncRejected(m, "data must be an object")
+	}
+	if m.SpaceRef != "" {
+		err := s.tx.QueryRow(`
+			SELECT (result->>'id')::BIGINT FROM sync_mutations
+			WHERE user_id = $1 AND mutation_id = $2 AND action = 'space.create'
+		`, s.userID, m.SpaceRef).Scan(&it.SpaceID)
+		if err == sql.ErrNoRows {
+			return syncRejected(m, "space_ref is not an applied space create")
+		}
+		if err != nil {
+			return SyncResult{}, err
+		}
+	}
+	if it.Quantity == 0 {
+		it.Quantity = 1
+	}
+	if err := it.validate(); err != nil {
+		return syncFailure(m, err)
+	}
+
+	id, err := insertGarageItem(s.tx, it, s.actor.userID)
+	if err != nil {
+		return syncFailure(m, err)
+	}
+	after, err := loadGarageItem(s.tx, id)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if err := s.actor.record(s.tx, "item.create", "item", id, nil, after); err != nil {
+		return SyncResult{}, err
+	}
+	if err := webhooks.Enqueue(s.tx, "item.created", after); err != nil {
+		return SyncResult{}, err
+	}
+	return syncApplied(m, id, after.Version)
+}
+
+// liveItem loads the item m targets; ok is false when it's gone.
+func (s *syncSession) liveItem(m syncMutation) (it GarageItem, ok bool, err error) {
+	it, err = loadGarageItem(s.tx, m.ID)
+	if err == sql.ErrNoRows {
+		return it, false, nil
+	}
+	return it, err == nil && it.DeletedAt == nil, err
+}
+
+func (s *syncSession) updateItem(m syncMutation) (SyncResult, error) {
+	before, ok, err := s.liveItem(m)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if !ok {
+		return syncConflict(m, nil, "item was deleted")
+	}
+	if before.Version != *m.BaseVersion {
+		return syncConflict(m, before, errVersionMismatch.Error())
+	}
+
+	changes, err := syncChanges(m.Data, before, garageItemPatchFields)
+	if err != nil {
+		return syncFailure(m, err)
+	}
+	if err := applyGarageItemChanges(s.tx, before, changes, s.actor.userID); err != nil {
+		return syncFailure(m, err)
+	}
+
+	after, err := loadGarageItem(s.tx, m.ID)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if err := s.actor.record(s.tx, "item.update", "item", m.ID, before, after); err != nil {
+		return SyncResult{}, err
+	}
+	if err := webhooks.Enqueue(s.tx, "item.updated", after); err != nil {
+		return SyncResult{}, err
+	}
+	return syncApplied(m, m.ID, after.Version)
+}
+
+func (s *syncSession) deleteItem(m syncMutation) (SyncResult, error) {
+	before, ok, err := s.liveItem(m)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if !ok {
+		return syncApplied(m, m.ID, 0)
+	}
+	if before.Version != *m.BaseVersion {
+		return syncConflict(m, before, errVersionMismatch.Error())
+	}
+
+	if err := trashGarageItem(s.tx, m.ID, ""); err != nil {
+		return SyncResult{}, err
+	}
+	after, err := loadGarageItem(s.tx, m.ID)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if err := s.actor.record(s.tx, "item.delete", "item", m.ID, before, after); err != nil {
+		return SyncResult{}, err
+	}
+	if err := webhooks.Enqueue(s.tx, "item.deleted", after); err != nil {
+		return SyncResult{}, err
+	}
+	return syncApplied(m, m.ID, 0)
+}
+
+func (s *syncSession) adjustItem(m syncMutation) (SyncResult, error) {
+	var body struct {
+		Delta  int     `json:"delta"`
+		Reason string  `json:"reason"`
+		Note   *string `json:"note"`
+	}
+	if err := json.Unmarshal(m.Data, &body); err != nil {
+		return syncRejected(m, "data must be an object")
+	}
+	if body.Delta == 0 {
+		return syncRejected(m, "delta must be non-zero")
+	}
+	if !movementReasons[body.Reason] {
+		return syncRejected(m, "reason must be one of purchase, used, lost, adjustment")
+	}
+
+	mv, err := applyStockMovement(s.tx, m.ID, body.Delta, body.Reason, body.Note, s.actor.userID)
+	if err == sql.ErrNoRows {
+		return syncConflict(m, nil, "item was deleted")
+	}
+	if err != nil {
+		return syncFailure(m, err)
+	}
+
+	after, err := loadGarageItem(s.tx, m.ID)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if err := s.actor.record(s.tx, "item.stock_movement", "item", m.ID, nil, mv); err != nil {
+		return SyncResult{}, err
+	}
+	if err := webhooks.Enqueue(s.tx, "item.updated", after); err != nil {
+		return SyncResult{}, err
+	}
+	return syncApplied(m, m.ID, after.Version)
+}
+
+// ---------- spaces ----------
+
+func (s *syncSession) createSpace(m syncMutation) (SyncResult, error) {
+	var sp newGarageSpace
+	if err := json.Unmarshal(m.Data, &sp); err != nil {
+		return syncRejected(m, "data must be an object")
+	}
+	if err := sp.validate(); err != nil {
+		return syncFailure(m, err)
+	}
+
+	var id int64
+	err := s.tx.QueryRow(`
+		INSERT INTO garage_spaces (name, description, location)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, sp.Name, sp.Description, sp.Location).Scan(&id)
+	if err != nil {
+		return syncFailure(m, err)
+	}
+
+	after, err := loadGarageSpace(s.tx, id)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if err := s.actor.record(s.tx, "space.create", "space", id, nil, after); err != nil {
+		return SyncResult{}, err
+	}
+	return syncApplied(m, id, after.Version)
+}
+
+// liveSpace is liveItem for spaces.
+func (s *syncSession) liveSpace(m syncMutation) (sp GarageSpace, ok bool, err error) {
+	sp, err = loadGarageSpace(s.tx, m.ID)
+	if err == sql.ErrNoRows {
+		return sp, false, nil
+	}
+	return sp, err == nil && sp.DeletedAt == nil, err
+}
+
+func (s *syncSession) updateSpace(m syncMutation) (SyncResult, error) {
+	before, ok, err := s.liveSpace(m)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if !ok {
+		return syncConflict(m, nil, "space was deleted")
+	}
+	if before.Version != *m.BaseVersion {
+		return syncConflict(m, before, errVersionMismatch.Error())
+	}
+
+	changes, err := syncChanges(m.Data, before, garageSpacePatchFields)
+	if err != nil {
+		return syncFailure(m, err)
+	}
+	if err := applyGarageSpaceChanges(s.tx, m.ID, changes); err != nil {
+		return syncFailure(m, err)
+	}
+
+	after, err := loadGarageSpace(s.tx, m.ID)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if err := s.actor.record(s.tx, "space.update", "space", m.ID, before, after); err != nil {
+		return SyncResult{}, err
+	}
+	return syncApplied(m, m.ID, after.Version)
+}
+
+func (s *syncSession) deleteSpace(m syncMutation) (SyncResult, error) {
+	before, ok, err := s.liveSpace(m)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if !ok {
+		return syncApplied(m, m.ID, 0)
+	}
+	if before.Version != *m.BaseVersion {
+		return syncConflict(m, before, errVersionMismatch.Error())
+	}
+
+	if err := trashGarageSpace(s.tx, before, s.actor); err != nil {
+		return SyncResult{}, err
+	}
+	return syncApplied(m, m.ID, 0)
+}
@@ -0,0 +1,444 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/security"
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Owners register endpoints; handlers queue events with webhooks.Enqueue in
+// the same transaction as the change and services run the delivery worker.
+// Private/LAN URLs are allowed on purpose: home automation lives there.
+
+type WebhookEndpoint struct {
+	ID          int64    `json:"id"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description *string  `json:"description,omitempty"`
+	Active      bool     `json:"active"`
+	Secret      string   `json:"secret,omitempty"` // only returned when the endpoint is created
+	CreatedBy   *int64   `json:"created_by,omitempty"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int64   `json:"id"`
+	EndpointID     int64   `json:"endpoint_id"`
+	Event          string  `json:"event"`
+	Payload        rawJSON `json:"payload"`
+	Status         string  `json:"status"` // pending, delivered, failed
+	Attempts       int     `json:"attempts"`
+	NextAttemptAt  *string `json:"next_attempt_at,omitempty"` // pending only
+	LastStatusCode *int    `json:"last_status_code,omitempty"`
+	LastError      *string `json:"last_error,omitempty"`
+	ReplayOf       *int64  `json:"replay_of,omitempty"`
+	CreatedAt      string  `json:"created_at"`
+	DeliveredAt    *string `json:"delivered_at,omitempty"`
+}
+
+const webhookEndpointColumns = `id, url, to_json(events)::text, description, active, created_by, created_at, updated_at`
+
+func scanWebhookEndpoint(row rowScanner) (WebhookEndpoint, error) {
+	var e WebhookEndpoint
+	var events string
+	var created, updated time.Time
+	if err := row.Scan(&e.ID, &e.URL, &events, &e.Description, &e.Active, &e.CreatedBy, &created, &updated); err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal([]byte(events), &e.Events); err != nil {
+		return e, err
+	}
+	e.CreatedAt = created.UTC().Format(time.RFC3339)
+	e.UpdatedAt = updated.UTC().Format(time.RFC3339)
+	return e, nil
+}
+
+const webhookDeliveryColumns = `
+	id, endpoint_id, event, payload, status, attempts, next_attempt_at, last_status_code, last_error,
+	replay_of, created_at, delivered_at`
+
+var webhookDeliverySorts = map[string]sortColumn{
+	"id": {"id", "bigint"},
+}
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload []byte
+	var next, created time.Time
+	var delivered *time.Time
+	err := row.Scan(&d.ID, &d.EndpointID, &d.Event, &payload, &d.Status, &d.Attempts, &next, &d.LastStatusCode,
+		&d.LastError, &d.ReplayOf, &created, &delivered)
+	if err != nil {
+		return d, err
+	}
+	d.Payload = payload
+	if d.Status == "pending" {
+		s := next.UTC().Format(time.RFC3339)
+		d.NextAttemptAt = &s
+	}
+	d.CreatedAt = created.UTC().Format(time.RFC3339)
+	if delivered != nil {
+		s := delivered.UTC().Format(time.RFC3339)
+		d.DeliveredAt = &s
+	}
+	return d, nil
+}
+
+// requireOwner fails with 403 unless the caller owns the garage.
+func requireOwner(c *fiber.Ctx, db *sql.DB, msg string) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	owner, err := isGarageOwner(db, uid)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if !owner {
+		return fiber.NewError(fiber.StatusForbidden, msg)
+	}
+	return nil
+}
+
+type webhookEndpointInput struct {
+	URL         *string   `json:"url"`
+	Events      *[]string `json:"events"`
+	Description *string   `json:"description"`
+	Active      *bool     `json:"active"`
+}
+
+// validate checks the fields that are present; creating requires url and events.
+func (in webhookEndpointInput) validate(creating bool) error {
+	var v validator
+	if in.URL != nil || creating {
+		u, err := url.Parse(strings.TrimSpace(deref(in.URL)))
+		v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"url", "must be an absolute http(s) URL")
+	}
+	if in.Events != nil || creating {
+		var events []string
+		if in.Events != nil {
+			events = *in.Events
+		}
+		v.check(len(events) > 0, "events", "must name at least one event")
+		for _, e := range events {
+			v.check(webhooks.IsEvent(e), "events", "must be some of: "+strings.Join(webhooks.Events, ", "))
+		}
+	}
+	v.maxLen(in.Description, maxShortText, "description")
+	return v.err()
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// queueItemEvent queues an item.* event carrying the item as it is now in tx.
+func queueItemEvent(tx *sql.Tx, event string, itemID int64) error {
+	it, err := loadGarageItem(tx, itemID)
+	if err != nil {
+		return err
+	}
+	return webhooks.Enqueue(tx, event, it)
+}
+
+// ---------- WEBHOOK HANDLERS ----------
+
+// GET /webhooks
+func listWebhookEndpoints(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can manage webhooks"); err != nil {
+			return err
+		}
+
+		rows, err := db.Query(`SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints ORDER BY id`)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		endpoints := []WebhookEndpoint{}
+		for rows.Next() {
+			e, err := scanWebhookEndpoint(rows)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			endpoints = append(endpoints, e)
+		}
+		return c.JSON(fiber.Map{"endpoints": endpoints, "events": webhooks.Events})
+	}
+}
+
+// POST /webhooks
+// Body: { "url": "http://homeassistant.local:8123/api/webhook/garage", "events": ["stock.low"], "description": "..." }
+// The signing secret is only shown in this response.
+func createWebhookEndpoint(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can manage webhooks"); err != nil {
+			return err
+		}
+
+		var body webhookEndpointInput
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err := body.validate(true); err != nil {
+			return err
+		}
+		active := true
+		if body.Active != nil {
+			active = *body.Active
+		}
+
+		secret, err := security.NewRandomToken(32)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		actor := requestActor(c)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		e, err := scanWebhookEndpoint(tx.QueryRow(`
+			INSERT INTO webhook_endpoints (url, secret, events, description, active, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING `+webhookEndpointColumns,
+			strings.TrimSpace(*body.URL), secret, *body.Events, body.Description, active, actor.userID))
+		if err != nil {
+			return dbError(err, "")
+		}
+		if err := actor.record(tx, "webhook.create", "webhook", e.ID, nil, e); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		e.Secret = secret
+		return c.Status(fiber.StatusCreated).JSON(e)
+	}
+}
+
+// PATCH /webhooks/:id
+// Body: any of url, events, description, active
+func updateWebhookEndpoint(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can manage webhooks"); err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		var body webhookEndpointInput
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err := body.validate(false); err != nil {
+			return err
+		}
+		if body.URL != nil {
+			u := strings.TrimSpace(*body.URL)
+			body.URL = &u
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		before, err := scanWebhookEndpoint(tx.QueryRow(`
+			SELECT `+webhookEndpointColumns+` FROM webhook_endpoints WHERE id = $1 FOR UPDATE
+		`, id))
+		if err != nil {
+			return dbError(err, "webhook not found")
+		}
+
+		var events any
+		if body.Events != nil {
+			events = *body.Events
+		}
+		after, err := scanWebhookEndpoint(tx.QueryRow(`
+			UPDATE webhook_endpoints
+			SET url         = COALESCE($2, url),
+			    events      = COALESCE($3, events),
+			    description = COALESCE($4, description),
+			    active      = COALESCE($5, active),
+			    updated_at  = NOW()
+			WHERE id = $1
+			RETURNING `+webhookEndpointColumns,
+			id, body.URL, events, body.Description, body.Active))
+		if err != nil {
+			return dbError(err, "webhook not found")
+		}
+		if err := requestActor(c).record(tx, "webhook.update", "webhook", id, before, after); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.JSON(after)
+	}
+}
+
+// DELETE /webhooks/:id   (its delivery log goes with it)
+func deleteWebhookEndpoint(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can manage webhooks"); err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		before, err := scanWebhookEndpoint(tx.QueryRow(`
+			DELETE FROM webhook_endpoints WHERE id = $1 RETURNING `+webhookEndpointColumns, id))
+		if err != nil {
+			return dbError(err, "webhook not found")
+		}
+		if err := requestActor(c).record(tx, "webhook.delete", "webhook", id, before, nil); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// GET /webhooks/:id/deliveries?status=pending|delivered|failed&sort=-id&limit=&cursor=
+func listWebhookDeliveries(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can manage webhooks"); err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+		page, err := parseListPage(c, webhookDeliverySorts, "id")
+		if err != nil {
+			return err
+		}
+
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM webhook_endpoints WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if !exists {
+			return fiber.NewError(fiber.StatusNotFound, "webhook not found")
+		}
+
+		conds := []string{"endpoint_id = $1"}
+		args := []any{id}
+		switch status := c.Query("status"); status {
+		case "":
+		case "pending", "delivered", "failed":
+			args = append(args, status)
+			conds = append(conds, fmt.Sprintf("status = $%d", len(args)))
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "status must be pending, delivered or failed")
+		}
+		if cond, a := page.where(args); cond != "" {
+			conds, args = append(conds, cond), a
+		}
+
+		q := `SELECT ` + webhookDeliveryColumns + page.sortKeyColumn() + ` FROM webhook_deliveries`
+		q += ` WHERE ` + strings.Join(conds, " AND ")
+		q += page.orderLimit()
+
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer rows.Close()
+
+		deliveries := []WebhookDelivery{}
+		var n int
+		var lastKey string
+		for rows.Next() {
+			var key string
+			d, err := scanWebhookDelivery(keyedRow{rows, &key})
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			if n++; n > page.limit {
+				break
+			}
+			deliveries = append(deliveries, d)
+			lastKey = key
+		}
+
+		var next *string
+		if len(deliveries) > 0 {
+			next = page.next(n, lastKey, deliveries[len(deliveries)-1].ID)
+		}
+		return c.JSON(fiber.Map{"deliveries": deliveries, "next_cursor": next})
+	}
+}
+
+// POST /webhooks/deliveries/:id/replay
+// Queues the same payload again as a new delivery (replay_of points back),
+// whatever happened to the original.
+func replayWebhookDelivery(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := requireOwner(c, db, "only owners can manage webhooks"); err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return fiber.ErrBadRequest
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		defer tx.Rollback()
+
+		d, err := scanWebhookDelivery(tx.QueryRow(`
+			INSERT INTO webhook_deliveries (endpoint_id, event, payload, replay_of)
+			SELECT endpoint_id, event, payload, id FROM webhook_deliveries WHERE id = $1
+			RETURNING `+webhookDeliveryColumns, id))
+		if err != nil {
+			return dbError(err, "delivery not found")
+		}
+		err = requestActor(c).record(tx, "webhook.replay", "webhook", d.EndpointID, nil,
+			fiber.Map{"delivery_id": d.ID, "replay_of": id})
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if err := tx.Commit(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(d)
+	}
+}
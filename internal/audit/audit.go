@@ -0,0 +1,111 @@
+package audit
+
+// append-only log of who changed what: auth events and every garage mutation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+)
+
+// Entry is one audit_log row. Before/After are anything that marshals to
+// JSON (usually the maps returned by Diff); nil leaves the column NULL.
+type Entry struct {
+	ActorID    *int64
+	Action     string // e.g. "item.update", "auth.login_failed"
+	EntityType string // "item", "space", "user"...; empty when there is none
+	EntityID   *int64
+	IP         string
+	UserAgent  string
+	Before     any
+	After      any
+}
+
+// Execer is satisfied by *sql.DB and *sql.Tx. Pass the transaction that makes
+// the change so the entry commits or rolls back with it.
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Record appends e to the audit log.
+func Record(ex Execer, e Entry) error {
+	before, err := jsonOrNull(e.Before)
+	if err != nil {
+		return err
+	}
+	after, err := jsonOrNull(e.After)
+	if err != nil {
+		return err
+	}
+
+	_, err = ex.Exec(`
+		INSERT INTO audit_log (actor_id, action, entity_type, entity_id, ip, user_agent, before, after)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8)
+	`, e.ActorID, e.Action, e.EntityType, e.EntityID, e.IP, e.UserAgent, before, after)
+	return err
+}
+
+func jsonOrNull(v any) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(b) == "null" {
+		return nil, nil
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// Diff reduces two snapshots of the same record to the top-level fields that
+// differ. A nil before (create) or after (delete) keeps the other side whole.
+// Fields listed in ignore never appear. A field missing on one side counts
+// as null there, so cleared optional fields show up as changes.
+func Diff(before, after any, ignore ...string) (map[string]any, map[string]any, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := toMap(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, k := range ignore {
+		delete(b, k)
+		delete(a, k)
+	}
+	if b == nil || a == nil {
+		return b, a, nil
+	}
+
+	changedBefore, changedAfter := map[string]any{}, map[string]any{}
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			changedBefore[k], changedAfter[k] = v, a[k]
+		}
+	}
+	for k, v := range a {
+		if _, seen := b[k]; !seen && v != nil {
+			changedBefore[k], changedAfter[k] = nil, v
+		}
+	}
+	return changedBefore, changedAfter, nil
+}
+
+func toMap(v any) (map[string]any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
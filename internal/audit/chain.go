@@ -0,0 +1,272 @@
+package audit
+
+// Hash chain over audit_log. Entries are written unsealed inside the
+// transaction they describe; Seal then gives committed entries consecutive
+// seq numbers and chains them:
+//
+//	hash = sha256(prev_hash + "\n" + canonical JSON of the entry)
+//
+// Chaining afterwards (instead of in Record) keeps the chain in commit order
+// and single-file: a transaction can't know which entry really comes before
+// its own until both have committed. Editing, deleting or reordering sealed
+// entries breaks every hash after them; signed checkpoints pin the tail.
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// sealLockKey serialises sealers across server instances (pg_advisory_xact_lock).
+const sealLockKey = 0x61756469 // "audi"
+
+const sealBatch = 1000
+
+// columns covered by the hash, in canonicalEntry order
+const hashedColumns = `id, actor_id, action, entity_type, entity_id, ip, user_agent,
+	before::text, after::text, created_at`
+
+type canonicalEntry struct {
+	Seq        int64   `json:"seq"`
+	ID         int64   `json:"id"`
+	ActorID    *int64  `json:"actor_id"`
+	Action     string  `json:"action"`
+	EntityType *string `json:"entity_type"`
+	EntityID   *int64  `json:"entity_id"`
+	IP         *string `json:"ip"`
+	UserAgent  *string `json:"user_agent"`
+	Before     *string `json:"before"` // jsonb as Postgres renders it
+	After      *string `json:"after"`
+	CreatedAt  string  `json:"created_at"`
+}
+
+func scanCanonical(row interface{ Scan(...any) error }, e *canonicalEntry) error {
+	var created time.Time
+	err := row.Scan(&e.ID, &e.ActorID, &e.Action, &e.EntityType, &e.EntityID, &e.IP, &e.UserAgent,
+		&e.Before, &e.After, &created)
+	if err != nil {
+		return err
+	}
+	e.CreatedAt = created.UTC().Format(time.RFC3339Nano)
+	return nil
+}
+
+func (e canonicalEntry) hash(prev string) string {
+	h := sha256.New()
+	h.Write([]byte(prev + "\n"))
+	b, _ := json.Marshal(e) // only strings and numbers, can't fail
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Seal chains every committed, unsealed entry and reports how many it sealed.
+func Seal(ctx context.Context, db *sql.DB) (int, error) {
+	total := 0
+	for {
+		n, err := sealBatchOnce(ctx, db)
+		total += n
+		if err != nil || n < sealBatch {
+			return total, err
+		}
+	}
+}
+
+func sealBatchOnce(ctx context.Context, db *sql.DB) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, sealLockKey); err != nil {
+		return 0, err
+	}
+
+	var seq int64
+	var prev string
+	err = tx.QueryRowContext(ctx, `
+		SELECT seq, hash FROM audit_log WHERE seq IS NOT NULL ORDER BY seq DESC LIMIT 1
+	`).Scan(&seq, &prev)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+hashedColumns+`
+		FROM audit_log
+		WHERE seq IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE
+	`, sealBatch)
+	if err != nil {
+		return 0, err
+	}
+	var pending []canonicalEntry
+	for rows.Next() {
+		var e canonicalEntry
+		if err := scanCanonical(rows, &e); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, e := range pending {
+		seq++
+		e.Seq = seq
+		h := e.hash(prev)
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE audit_log SET seq = $1, prev_hash = $2, hash = $3 WHERE id = $4
+		`, seq, prev, h, e.ID); err != nil {
+			return 0, err
+		}
+		prev = h
+	}
+
+	return len(pending), tx.Commit()
+}
+
+// Break is the first place where the chain doesn't hold.
+type Break struct {
+	Seq     int64  `json:"seq"`
+	EntryID int64  `json:"entry_id,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+type Report struct {
+	OK             bool   `json:"ok"`
+	Entries        int64  `json:"entries"`  // sealed entries checked
+	Unsealed       int64  `json:"unsealed"` // written but not chained yet
+	Checkpoints    int    `json:"checkpoints"`
+	LastCheckpoint *int64 `json:"last_checkpoint_seq,omitempty"`
+	PublicKey      string `json:"public_key"` // checkpoints are signed with this (base64 Ed25519)
+	FirstBroken    *Break `json:"first_broken,omitempty"`
+	CheckedAt      string `json:"checked_at"`
+}
+
+// Verify walks the whole chain, recomputing every hash, and checks each
+// checkpoint's signature against key and against the entry it pins.
+func Verify(ctx context.Context, db *sql.DB, key SigningKey) (Report, error) {
+	rep := Report{PublicKey: key.PublicBase64(), CheckedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	checkpoints, err := loadCheckpoints(ctx, db)
+	if err != nil {
+		return rep, err
+	}
+	v, brk := newChainVerifier(key, checkpoints)
+	rep.Checkpoints = len(checkpoints)
+	rep.LastCheckpoint = v.lastCheckpoint
+	if brk != nil {
+		rep.FirstBroken = brk
+		return rep, nil
+	}
+
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log WHERE seq IS NULL`).Scan(&rep.Unsealed); err != nil {
+		return rep, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT seq, prev_hash, hash, `+hashedColumns+`
+		FROM audit_log
+		WHERE seq IS NOT NULL
+		ORDER BY seq
+	`)
+	if err != nil {
+		return rep, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e sealedEntry
+		var created time.Time
+		err := rows.Scan(&e.Seq, &e.PrevHash, &e.Hash, &e.ID, &e.ActorID, &e.Action, &e.EntityType,
+			&e.EntityID, &e.IP, &e.UserAgent, &e.Before, &e.After, &created)
+		if err != nil {
+			return rep, err
+		}
+		e.CreatedAt = created.UTC().Format(time.RFC3339Nano)
+
+		if brk := v.next(e); brk != nil {
+			rep.Entries = v.entries
+			rep.FirstBroken = brk
+			return rep, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return rep, err
+	}
+
+	rep.Entries = v.entries
+	if brk := v.end(); brk != nil {
+		rep.FirstBroken = brk
+		return rep, nil
+	}
+
+	rep.OK = true
+	return rep, nil
+}
+
+// sealedEntry is an entry as stored once sealed.
+type sealedEntry struct {
+	canonicalEntry
+	PrevHash string
+	Hash     string
+}
+
+// chainVerifier checks sealed entries handed to it in seq order.
+type chainVerifier struct {
+	pinned         map[int64]Checkpoint
+	lastCheckpoint *int64
+	entries        int64  // entries that held so far
+	prev           string // hash of the last of them
+}
+
+// newChainVerifier checks the checkpoints' signatures; the break is the
+// first bad one.
+func newChainVerifier(key SigningKey, checkpoints []Checkpoint) (*chainVerifier, *Break) {
+	v := &chainVerifier{pinned: map[int64]Checkpoint{}}
+	for _, cp := range checkpoints {
+		if problem := key.verify(cp); problem != "" {
+			return v, &Break{Seq: cp.Seq, Reason: problem}
+		}
+		v.pinned[cp.Seq] = cp
+		seq := cp.Seq
+		v.lastCheckpoint = &seq
+	}
+	return v, nil
+}
+
+// next checks e against the entries before it and the checkpoints.
+func (v *chainVerifier) next(e sealedEntry) *Break {
+	switch {
+	case e.Seq != v.entries+1:
+		return &Break{Seq: v.entries + 1, Reason: "entry missing (deleted)"}
+	case e.PrevHash != v.prev:
+		return &Break{Seq: e.Seq, EntryID: e.ID, Reason: "prev_hash does not match the previous entry"}
+	case e.canonicalEntry.hash(v.prev) != e.Hash:
+		return &Break{Seq: e.Seq, EntryID: e.ID, Reason: "entry was modified after it was sealed"}
+	}
+	if cp, ok := v.pinned[e.Seq]; ok && cp.Hash != e.Hash {
+		return &Break{Seq: e.Seq, EntryID: e.ID, Reason: fmt.Sprintf("hash differs from checkpoint %d", cp.ID)}
+	}
+	v.entries++
+	v.prev = e.Hash
+	return nil
+}
+
+// end catches a chain cut short behind the last checkpoint.
+func (v *chainVerifier) end() *Break {
+	if v.lastCheckpoint != nil && *v.lastCheckpoint > v.entries {
+		return &Break{Seq: v.entries + 1, Reason: "entries covered by a checkpoint are missing (truncated)"}
+	}
+	return nil
+}
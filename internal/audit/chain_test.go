@@ -0,0 +1,252 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/config"
+)
+
+func strPtr(s string) *string { return &s }
+
+func testKey(t *testing.T, seed byte) SigningKey {
+	t.Helper()
+	raw := make([]byte, ed25519.SeedSize)
+	raw[0] = seed
+	key, err := LoadSigningKey(&config.Config{SigningKey: base64.StdEncoding.EncodeToString(raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testChain seals n entries the way Seal does.
+func testChain(n int) []sealedEntry {
+	var chain []sealedEntry
+	prev := ""
+	for i := 1; i <= n; i++ {
+		e := canonicalEntry{
+			Seq:        int64(i),
+			ID:         int64(100 + i),
+			Action:     "item.update",
+			EntityType: strPtr("item"),
+			After:      strPtr(`{"quantity": 3}`),
+			CreatedAt:  time.Date(2025, 1, i, 12, 0, 0, 0, time.UTC).Format(time.RFC3339Nano),
+		}
+		s := sealedEntry{canonicalEntry: e, PrevHash: prev, Hash: e.hash(prev)}
+		chain = append(chain, s)
+		prev = s.Hash
+	}
+	return chain
+}
+
+// rehash recomputes the hashes from i on, as someone covering their tracks would.
+func rehash(chain []sealedEntry, i int) {
+	prev := ""
+	if i > 0 {
+		prev = chain[i-1].Hash
+	}
+	for ; i < len(chain); i++ {
+		chain[i].PrevHash = prev
+		chain[i].Hash = chain[i].canonicalEntry.hash(prev)
+		prev = chain[i].Hash
+	}
+}
+
+func checkpointAt(key SigningKey, chain []sealedEntry, seq int64) Checkpoint {
+	cp := Checkpoint{ID: seq, Seq: seq, Hash: chain[seq-1].Hash}
+	key.sign(&cp, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC))
+	return cp
+}
+
+// verifyChain runs what Verify does on rows from the database.
+func verifyChain(key SigningKey, checkpoints []Checkpoint, chain []sealedEntry) (int64, *Break) {
+	v, brk := newChainVerifier(key, checkpoints)
+	if brk != nil {
+		return v.entries, brk
+	}
+	for _, e := range chain {
+		if brk := v.next(e); brk != nil {
+			return v.entries, brk
+		}
+	}
+	return v.entries, v.end()
+}
+
+func TestVerifyChain(t *testing.T) {
+	key := testKey(t, 1)
+	otherKey := testKey(t, 2)
+
+	tests := []struct {
+		name   string
+		tamper func(chain []sealedEntry) ([]sealedEntry, []Checkpoint)
+		seq    int64  // where the break is reported, 0 for an intact chain
+		reason string // part of the reason
+	}{
+		{
+			name: "intact",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				return chain, []Checkpoint{checkpointAt(key, chain, 3), checkpointAt(key, chain, 5)}
+			},
+		},
+		{
+			name: "field changed",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				chain[1].Action = "item.delete"
+				return chain, nil
+			},
+			seq: 2, reason: "modified",
+		},
+		{
+			name: "json changed",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				chain[2].After = strPtr(`{"quantity": 30}`)
+				return chain, nil
+			},
+			seq: 3, reason: "modified",
+		},
+		{
+			name: "null turned into a value",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				chain[0].IP = strPtr("10.0.0.1")
+				return chain, nil
+			},
+			seq: 1, reason: "modified",
+		},
+		{
+			name: "timestamp moved",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				chain[3].CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339Nano)
+				return chain, nil
+			},
+			seq: 4, reason: "modified",
+		},
+		{
+			name: "entry deleted",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				return append(chain[:2], chain[3:]...), nil
+			},
+			seq: 3, reason: "missing",
+		},
+		{
+			name: "entry rehashed",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				chain[1].Action = "item.delete"
+				chain[1].Hash = chain[1].canonicalEntry.hash(chain[1].PrevHash)
+				return chain, nil
+			},
+			seq: 3, reason: "prev_hash",
+		},
+		{
+			name: "whole tail rehashed under a checkpoint",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				cps := []Checkpoint{checkpointAt(key, chain, 4)}
+				chain[1].Action = "item.delete"
+				rehash(chain, 1)
+				return chain, cps
+			},
+			seq: 4, reason: "checkpoint",
+		},
+		{
+			name: "deleted entry renumbered and rehashed under a checkpoint",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				cps := []Checkpoint{checkpointAt(key, chain, 5)}
+				chain = append(chain[:1], chain[2:]...)
+				for i := range chain {
+					chain[i].Seq = int64(i + 1)
+				}
+				rehash(chain, 1)
+				return chain, cps
+			},
+			seq: 5, reason: "truncated",
+		},
+		{
+			name: "truncated behind a checkpoint",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				return chain[:3], []Checkpoint{checkpointAt(key, chain, 5)}
+			},
+			seq: 4, reason: "truncated",
+		},
+		{
+			name: "checkpoint hash edited",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				cp := checkpointAt(key, chain, 3)
+				cp.Hash = chain[1].Hash
+				return chain, []Checkpoint{cp}
+			},
+			seq: 3, reason: "invalid signature",
+		},
+		{
+			name: "checkpoint from another key",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				return chain, []Checkpoint{checkpointAt(otherKey, chain, 3)}
+			},
+			seq: 3, reason: "signed by key",
+		},
+		{
+			name: "checkpoint forged with another key's id",
+			tamper: func(chain []sealedEntry) ([]sealedEntry, []Checkpoint) {
+				cp := checkpointAt(otherKey, chain, 3)
+				cp.KeyID = key.keyID()
+				return chain, []Checkpoint{cp}
+			},
+			seq: 3, reason: "invalid signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, cps := tt.tamper(testChain(5))
+			entries, brk := verifyChain(key, cps, chain)
+			if tt.seq == 0 {
+				if brk != nil {
+					t.Fatalf("intact chain reported broken: %+v", *brk)
+				}
+				if entries != 5 {
+					t.Errorf("checked %d entries, want 5", entries)
+				}
+				return
+			}
+			if brk == nil {
+				t.Fatal("tampering went unnoticed")
+			}
+			if brk.Seq != tt.seq || !strings.Contains(brk.Reason, tt.reason) {
+				t.Errorf("break at %d (%s), want %d (%s)", brk.Seq, brk.Reason, tt.seq, tt.reason)
+			}
+		})
+	}
+}
+
+func TestEntryHashChainsPrev(t *testing.T) {
+	e := testChain(1)[0].canonicalEntry
+	if e.hash("") != e.hash("") {
+		t.Error("hash is not deterministic")
+	}
+	if e.hash("") == e.hash("00") {
+		t.Error("hash ignores the previous hash")
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	a, err := LoadSigningKey(&config.Config{JWTSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := LoadSigningKey(&config.Config{JWTSecret: "secret"})
+	c, _ := LoadSigningKey(&config.Config{JWTSecret: "other"})
+	if a.PublicBase64() != b.PublicBase64() {
+		t.Error("key derived from JWT_SECRET is not stable")
+	}
+	if a.PublicBase64() == c.PublicBase64() {
+		t.Error("different JWT secrets give the same key")
+	}
+
+	for _, bad := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := LoadSigningKey(&config.Config{SigningKey: bad}); err == nil {
+			t.Errorf("SIGNING_KEY %q was accepted", bad)
+		}
+	}
+}
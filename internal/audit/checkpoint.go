@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/config"
+)
+
+// SigningKey is the server's Ed25519 key for audit checkpoints.
+type SigningKey struct {
+	priv ed25519.PrivateKey
+}
+
+// LoadSigningKey reads SIGNING_KEY (a base64 32-byte Ed25519 seed). Without
+// one the key is derived from JWT_SECRET, which is fine for development but
+// means rotating the JWT secret invalidates old checkpoints.
+func LoadSigningKey(cfg *config.Config) (SigningKey, error) {
+	if cfg.SigningKey == "" {
+		seed := sha256.Sum256([]byte("blaccend audit checkpoints\x00" + cfg.JWTSecret))
+		return SigningKey{ed25519.NewKeyFromSeed(seed[:])}, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(cfg.SigningKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return SigningKey{}, fmt.Errorf("SIGNING_KEY must be %d base64-encoded bytes", ed25519.SeedSize)
+	}
+	return SigningKey{ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func (k SigningKey) public() ed25519.PublicKey {
+	return k.priv.Public().(ed25519.PublicKey)
+}
+
+// PublicBase64 is what auditors need to check checkpoints on their own.
+func (k SigningKey) PublicBase64() string {
+	return base64.StdEncoding.EncodeToString(k.public())
+}
+
+// keyID names the key in checkpoint rows, so a rotated key is recognisable.
+func (k SigningKey) keyID() string {
+	sum := sha256.Sum256(k.public())
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// Checkpoint is a signed statement that the chain had this hash at seq.
+type Checkpoint struct {
+	ID        int64
+	Seq       int64
+	Hash      string
+	KeyID     string
+	Signature string // base64
+	CreatedAt time.Time
+}
+
+func (cp Checkpoint) message() []byte {
+	return []byte(fmt.Sprintf("blaccend-audit-checkpoint\n%d\n%s\n%s",
+		cp.Seq, cp.Hash, cp.CreatedAt.UTC().Format(time.RFC3339)))
+}
+
+// sign dates cp at and signs it.
+func (k SigningKey) sign(cp *Checkpoint, at time.Time) {
+	// whole seconds, so the signed message survives the round trip
+	cp.CreatedAt = at.UTC().Truncate(time.Second)
+	cp.KeyID = k.keyID()
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(k.priv, cp.message()))
+}
+
+// verify returns "" for a good checkpoint, else what is wrong with it.
+func (k SigningKey) verify(cp Checkpoint) string {
+	if cp.KeyID != k.keyID() {
+		return fmt.Sprintf("checkpoint %d is signed by key %s, not the current key %s", cp.ID, cp.KeyID, k.keyID())
+	}
+	sig, err := base64.StdEncoding.DecodeString(cp.Signature)
+	if err != nil || !ed25519.Verify(k.public(), cp.message(), sig) {
+		return fmt.Sprintf("checkpoint %d has an invalid signature", cp.ID)
+	}
+	return ""
+}
+
+// WriteCheckpoint seals pending entries and signs the head of the chain.
+// Nothing is written when the head is already checkpointed.
+func WriteCheckpoint(ctx context.Context, db *sql.DB, key SigningKey) error {
+	if _, err := Seal(ctx, db); err != nil {
+		return fmt.Errorf("seal: %w", err)
+	}
+
+	var cp Checkpoint
+	err := db.QueryRowContext(ctx, `
+		SELECT seq, hash FROM audit_log WHERE seq IS NOT NULL ORDER BY seq DESC LIMIT 1
+	`).Scan(&cp.Seq, &cp.Hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var done bool
+	if err := db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM audit_checkpoints WHERE seq = $1)
+	`, cp.Seq).Scan(&done); err != nil || done {
+		return err
+	}
+
+	key.sign(&cp, time.Now())
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO audit_checkpoints (seq, hash, key_id, signature, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, cp.Seq, cp.Hash, cp.KeyID, cp.Signature, cp.CreatedAt)
+	return err
+}
+
+func loadCheckpoints(ctx context.Context, db *sql.DB) ([]Checkpoint, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, seq, hash, key_id, signature, created_at FROM audit_checkpoints ORDER BY seq
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cps []Checkpoint
+	for rows.Next() {
+		var cp Checkpoint
+		if err := rows.Scan(&cp.ID, &cp.Seq, &cp.Hash, &cp.KeyID, &cp.Signature, &cp.CreatedAt); err != nil {
+			return nil, err
+		}
+		cps = append(cps, cp)
+	}
+	return cps, rows.Err()
+}
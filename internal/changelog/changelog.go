@@ -0,0 +1,140 @@
+package changelog
+
+// Change log of garage items and spaces. Triggers on both tables append a
+// row to garage_changes for every create/update/delete/restore, in the same
+// transaction as the change. Sequence then numbers committed rows: seq is
+// handed out after commit, so it only ever grows in the order readers can
+// see changes, and "everything after seq N" never skips a late commit the
+// way a plain BIGSERIAL would. Live streams (Hub) and offline sync both read
+// by seq.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// sequenceLockKey serialises sequencers across API replicas.
+const sequenceLockKey = 0x67636867 // "gchg"
+
+// Change is one sequenced garage_changes row.
+type Change struct {
+	Seq        int64     `json:"seq"`
+	Type       string    `json:"type"` // "item.updated", "space.deleted"...
+	EntityType string    `json:"entity_type"`
+	EntityID   int64     `json:"entity_id"`
+	SpaceID    *int64    `json:"space_id,omitempty"` // items only
+	Version    int       `json:"version"`
+	At         time.Time `json:"at"`
+}
+
+// Querier is satisfied by *sql.DB and *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// ErrTooOld means changes after the requested seq have already been pruned;
+// the client has to start over from a full fetch.
+var ErrTooOld = errors.New("change log no longer reaches that far back")
+
+// Sequence numbers every committed, unsequenced change in id order and
+// notifies listeners on the garage_changes channel when it did.
+func Sequence(ctx context.Context, db *sql.DB) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, sequenceLockKey); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE garage_changes c
+		SET seq = s.seq
+		FROM (
+			SELECT id, nextval('garage_changes_seq') AS seq
+			FROM (SELECT id FROM garage_changes WHERE seq IS NULL ORDER BY id FOR UPDATE) pending
+		) s
+		WHERE c.id = s.id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify('garage_changes', '')`); err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+const changeColumns = `seq, entity_type || '.' || action, entity_type, entity_id, space_id, version, created_at`
+
+// Since returns up to limit sequenced changes after seq, oldest first.
+// It fails with ErrTooOld when changes after seq have been pruned.
+func Since(ctx context.Context, q Querier, seq int64, limit int) ([]Change, error) {
+	var pruned int64
+	err := q.QueryRowContext(ctx, `SELECT COALESCE((SELECT seq FROM garage_changes_pruned), 0)`).Scan(&pruned)
+	if err != nil {
+		return nil, err
+	}
+	if seq < pruned {
+		return nil, ErrTooOld
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+changeColumns+`
+		FROM garage_changes
+		WHERE seq > $1
+		ORDER BY seq
+		LIMIT $2
+	`, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []Change
+	for rows.Next() {
+		var ch Change
+		if err := rows.Scan(&ch.Seq, &ch.Type, &ch.EntityType, &ch.EntityID, &ch.SpaceID, &ch.Version, &ch.At); err != nil {
+			return nil, err
+		}
+		ch.At = ch.At.UTC()
+		changes = append(changes, ch)
+	}
+	return changes, rows.Err()
+}
+
+// Head is the latest seq handed out, 0 before the first change.
+func Head(ctx context.Context, q Querier) (int64, error) {
+	var seq int64
+	err := q.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM garage_changes`).Scan(&seq)
+	return seq, err
+}
+
+// Prune drops sequenced changes older than retentionDays and remembers the
+// highest seq it dropped, which is how Since recognises stale tokens.
+func Prune(ctx context.Context, db *sql.DB, retentionDays int) (int64, error) {
+	var n int64
+	err := db.QueryRowContext(ctx, `
+		WITH gone AS (
+			DELETE FROM garage_changes
+			WHERE seq IS NOT NULL AND created_at < NOW() - make_interval(days => $1)
+			RETURNING seq
+		), horizon AS (
+			INSERT INTO garage_changes_pruned (seq)
+			SELECT MAX(seq) FROM gone HAVING COUNT(*) > 0
+			ON CONFLICT (only_row) DO UPDATE SET seq = GREATEST(garage_changes_pruned.seq, EXCLUDED.seq)
+		)
+		SELECT COUNT(*) FROM gone
+	`, retentionDays).Scan(&n)
+	return n, err
+}
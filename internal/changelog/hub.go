@@ -0,0 +1,173 @@
+package changelog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+const (
+	// how often the hub sequences and polls without being notified, which
+	// covers notifications lost while the listener was reconnecting
+	pollInterval = 5 * time.Second
+
+	subscriberBuffer = 256
+	pollBatch        = 500
+)
+
+// Hub fans sequenced changes out to subscribers in this process. Every API
+// replica runs its own hub; Postgres LISTEN/NOTIFY tells them all when new
+// changes were sequenced, whichever replica wrote them.
+type Hub struct {
+	db *sql.DB
+
+	mu   sync.Mutex
+	subs map[chan Change]struct{}
+	last int64 // highest seq broadcast so far
+}
+
+func NewHub(db *sql.DB) *Hub {
+	return &Hub{db: db, subs: map[chan Change]struct{}{}}
+}
+
+// Subscribe returns a channel of changes sequenced from now on and a func
+// to stop. The channel is closed when the subscriber falls too far behind;
+// it should reconnect and catch up from the change log.
+func (h *Hub) Subscribe() (<-chan Change, func()) {
+	ch := make(chan Change, subscriberBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Run listens for notifications until ctx is cancelled, reconnecting after
+// errors.
+func (h *Hub) Run(ctx context.Context) {
+	head, err := Head(ctx, h.db)
+	if err != nil {
+		log.Printf("changelog hub: %v", err)
+	}
+	h.last = head
+
+	for {
+		err := h.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("changelog hub: %v; reconnecting", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (h *Hub) listen(ctx context.Context) error {
+	conn, err := h.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pg := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pg.Exec(ctx, `LISTEN garage_changes_pending`); err != nil {
+			return err
+		}
+		if _, err := pg.Exec(ctx, `LISTEN garage_changes`); err != nil {
+			return err
+		}
+		// the connection goes back to the pool afterwards
+		defer pg.Exec(context.Background(), `UNLISTEN *`)
+
+		pending := true
+		for {
+			// catch up on anything missed before (re)connecting or between wakeups
+			if pending {
+				h.sequence(ctx)
+			}
+			h.poll(ctx)
+
+			waitCtx, cancel := context.WithTimeout(ctx, pollInterval)
+			n, err := pg.WaitForNotification(waitCtx)
+			cancel()
+			switch {
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case waitCtx.Err() != nil:
+				pending = true // quiet for a while: sequence and poll anyway
+			case err != nil:
+				return fmt.Errorf("wait for notification: %w", err)
+			default:
+				// garage_changes_pending: some transaction committed changes;
+				// garage_changes: some hub sequenced them, only poll
+				pending = n.Channel == "garage_changes_pending"
+			}
+		}
+	})
+}
+
+func (h *Hub) sequence(ctx context.Context) {
+	if _, err := Sequence(ctx, h.db); err != nil && ctx.Err() == nil {
+		log.Printf("changelog hub: sequence: %v", err)
+	}
+}
+
+// poll broadcasts everything sequenced since the last broadcast.
+func (h *Hub) poll(ctx context.Context) {
+	for {
+		changes, err := Since(ctx, h.db, h.last, pollBatch)
+		if err == ErrTooOld {
+			// the hub slept through a prune; start again from the head
+			head, herr := Head(ctx, h.db)
+			if herr != nil {
+				log.Printf("changelog hub: %v", herr)
+				return
+			}
+			h.last = head
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("changelog hub: poll: %v", err)
+			}
+			return
+		}
+		for _, ch := range changes {
+			h.broadcast(ch)
+			h.last = ch.Seq
+		}
+		if len(changes) < pollBatch {
+			return
+		}
+	}
+}
+
+func (h *Hub) broadcast(c Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- c:
+		default:
+			// too slow: drop it, it resumes from the log with Last-Event-ID
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
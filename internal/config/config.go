@@ -18,8 +18,10 @@ type Config struct {
 	DBName string
 
 	// JWT / security
-	JWTSecret string
-	AppURL    string // e.g. https://yourapp.com (used for email verification links)
+	JWTSecret  string
+	SigningKey string // base64 Ed25519 seed for audit checkpoints; derived from JWTSecret when empty
+	AppURL     string // e.g. https://yourapp.com (used for email verification links)
+	GeoIPDB    string // offline country database for login alerts, see geoip.Open
 
 	// SMTP email
 	SMTPHost string
@@ -27,6 +29,26 @@ type Config struct {
 	SMTPUser string
 	SMTPPass string
 	SMTPFrom string // FROM: noreply@yourapp.com
+	SMTPTLS  string // auto | starttls | tls | none
+
+	// Mail delivery: smtp, maildir (development) or memory
+	MailTransport string
+	MailDir       string // maildir transport target
+
+	// DKIM signing; off unless selector and key file are set
+	DKIMSelector string
+	DKIMDomain   string // defaults to the SMTP_FROM domain
+	DKIMKeyFile  string // PEM RSA or Ed25519 private key
+
+	// HTTP
+	BodyLimitMB int // max request body; garage restores upload whole archives
+
+	// Background jobs
+	DigestHour             int // UTC hour when daily digests go out
+	TrashRetentionDays     int // deleted items/spaces are purged after this many days
+	AuditCheckpointMinutes int // how often the audit chain head gets a signed checkpoint
+	ChangeLogRetentionDays int // item/space changes kept for live resume and offline sync
+	JobWorkers             int // concurrent workers of the durable job queue (mail...)
 }
 
 func Load() *Config {
@@ -51,6 +73,8 @@ func Load() *Config {
 
 	// JWT
 	cfg.JWTSecret = getEnv("JWT_SECRET", "dev-secret-change-me")
+	cfg.SigningKey = getEnv("SIGNING_KEY", "")
+	cfg.GeoIPDB = getEnv("GEOIP_DB", "")
 
 	// SMTP
 	cfg.SMTPHost = getEnv("SMTP_HOST", "localhost")
@@ -58,9 +82,28 @@ func Load() *Config {
 	cfg.SMTPPass = getEnv("SMTP_PASS", "")
 	cfg.SMTPFrom = getEnv("SMTP_FROM", "noreply@example.com")
 
+	cfg.SMTPTLS = getEnv("SMTP_TLS", "auto")
+
 	smtpPortStr := getEnv("SMTP_PORT", "1025")
 	cfg.SMTPPort, _ = strconv.Atoi(smtpPortStr)
 
+	// Mail
+	cfg.MailTransport = getEnv("MAIL_TRANSPORT", "smtp")
+	cfg.MailDir = getEnv("MAIL_DIR", "data/mail")
+	cfg.DKIMSelector = getEnv("DKIM_SELECTOR", "")
+	cfg.DKIMDomain = getEnv("DKIM_DOMAIN", "")
+	cfg.DKIMKeyFile = getEnv("DKIM_PRIVATE_KEY_FILE", "")
+
+	// HTTP
+	cfg.BodyLimitMB, _ = strconv.Atoi(getEnv("BODY_LIMIT_MB", "64"))
+
+	// Jobs
+	cfg.DigestHour, _ = strconv.Atoi(getEnv("DIGEST_HOUR", "7"))
+	cfg.TrashRetentionDays, _ = strconv.Atoi(getEnv("TRASH_RETENTION_DAYS", "30"))
+	cfg.AuditCheckpointMinutes, _ = strconv.Atoi(getEnv("AUDIT_CHECKPOINT_MINUTES", "60"))
+	cfg.ChangeLogRetentionDays, _ = strconv.Atoi(getEnv("CHANGE_LOG_RETENTION_DAYS", "30"))
+	cfg.JobWorkers, _ = strconv.Atoi(getEnv("JOB_WORKERS", "4"))
+
 	return cfg
 }
 
@@ -6,12 +6,37 @@ CREATE TABLE IF NOT EXISTS users (
     email_verified BOOLEAN NOT NULL DEFAULT FALSE,
     verify_token TEXT,
     verify_expires_at TIMESTAMPTZ,
+    reset_token TEXT,
+    reset_expires_at TIMESTAMPTZ,
+    password_reset_required BOOLEAN NOT NULL DEFAULT FALSE, -- set by "this wasn't me"
+    session_version INT NOT NULL DEFAULT 0, -- tokens carry it; bumping it logs every session out
+    calendar_token TEXT UNIQUE, -- secret in the reservations feed URL; NULL when revoked
     totp_secret TEXT,
     twofa_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'member')), -- owners get garage notifications
+    locale TEXT NOT NULL DEFAULT 'en', -- language of mail sent to the user
     date_registered TIMESTAMPTZ NOT NULL,
     last_seen TIMESTAMPTZ DEFAULT NULL
 );
 
+-- This file also runs on every start (db.Migrate), so everything in it must
+-- be idempotent. Columns added to a table after it first shipped get an
+-- ADD COLUMN IF NOT EXISTS next to it for databases created before them.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS reset_token TEXT,
+    ADD COLUMN IF NOT EXISTS reset_expires_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS session_version INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS calendar_token TEXT UNIQUE,
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'member')),
+    ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT 'en';
+
+-- the first user becomes the owner on signup; do the same for users that
+-- signed up before there were roles
+UPDATE users SET role = 'owner'
+WHERE id = (SELECT id FROM users ORDER BY date_registered, id LIMIT 1)
+  AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'owner');
+
 -- Optional sample users (these won't have usable passwords, just demo data)
 
 -- =========================
@@ -23,15 +48,551 @@ CREATE TABLE IF NOT EXISTS garage_spaces (
     name        TEXT NOT NULL,
     description TEXT,
     location    TEXT,
+    version     INT NOT NULL DEFAULT 1, -- bumped on every update, served as the ETag
+    deleted_at  TIMESTAMPTZ,            -- in the trash since; purged after TRASH_RETENTION_DAYS
     created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+ALTER TABLE garage_spaces
+    ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
 CREATE TABLE IF NOT EXISTS garage_items (
     id          BIGSERIAL PRIMARY KEY,
     space_id    BIGINT NOT NULL REFERENCES garage_spaces(id) ON DELETE CASCADE,
     name        TEXT NOT NULL,
-    quantity    INT NOT NULL DEFAULT 1,
+    quantity    INT NOT NULL DEFAULT 1 CHECK (quantity >= 0),
     notes       TEXT,
+    min_quantity     INT CHECK (min_quantity >= 0),     -- alert when quantity drops below this
+    reorder_quantity INT CHECK (reorder_quantity > 0),  -- how many to buy when restocking
+    usage_hours NUMERIC(10,1) NOT NULL DEFAULT 0,     -- hour meter for engines etc.
+    purchase_date    DATE,
+    vendor           TEXT,
+    purchase_price   NUMERIC(12,2) CHECK (purchase_price >= 0), -- per unit
+    currency         CHAR(3),                                   -- ISO 4217, e.g. RON / EUR
+    serial_number    TEXT,
+    warranty_expires DATE,
+    warranty_reminded_at TIMESTAMPTZ,
+    version     INT NOT NULL DEFAULT 1, -- bumped on every update, served as the ETag
+    deleted_at  TIMESTAMPTZ,            -- in the trash since; purged after TRASH_RETENTION_DAYS
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE garage_items
+    ADD COLUMN IF NOT EXISTS min_quantity INT CHECK (min_quantity >= 0),
+    ADD COLUMN IF NOT EXISTS reorder_quantity INT CHECK (reorder_quantity > 0),
+    ADD COLUMN IF NOT EXISTS usage_hours NUMERIC(10,1) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS purchase_date DATE,
+    ADD COLUMN IF NOT EXISTS vendor TEXT,
+    ADD COLUMN IF NOT EXISTS purchase_price NUMERIC(12,2) CHECK (purchase_price >= 0),
+    ADD COLUMN IF NOT EXISTS currency CHAR(3),
+    ADD COLUMN IF NOT EXISTS serial_number TEXT,
+    ADD COLUMN IF NOT EXISTS warranty_expires DATE,
+    ADD COLUMN IF NOT EXISTS warranty_reminded_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- same name Postgres gives the column CHECK above. NOT VALID so a negative
+-- quantity left over from before doesn't stop the server from starting; new
+-- writes are checked all the same
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'garage_items_quantity_check') THEN
+        ALTER TABLE garage_items ADD CONSTRAINT garage_items_quantity_check CHECK (quantity >= 0) NOT VALID;
+    END IF;
+END;
+$$;
+
+-- Optimistic concurrency: any UPDATE moves the row to a new version, whichever
+-- code path (ledger, usage meter, edits) did it. Bookkeeping columns no client
+-- sees (warranty_reminded_at) are left out, so background jobs touching them
+-- don't change ETags or show up in garage_changes.
+CREATE OR REPLACE FUNCTION bump_row_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS garage_spaces_version ON garage_spaces;
+CREATE TRIGGER garage_spaces_version
+    BEFORE UPDATE ON garage_spaces
+    FOR EACH ROW EXECUTE FUNCTION bump_row_version();
+
+DROP TRIGGER IF EXISTS garage_items_version ON garage_items;
+CREATE TRIGGER garage_items_version
+    BEFORE UPDATE ON garage_items
+    FOR EACH ROW
+    WHEN (to_jsonb(OLD) - 'warranty_reminded_at' IS DISTINCT FROM to_jsonb(NEW) - 'warranty_reminded_at')
+    EXECUTE FUNCTION bump_row_version();
+
+-- imports match existing items by name (case-insensitive) within a space
+CREATE INDEX IF NOT EXISTS garage_items_space_name_idx ON garage_items (space_id, lower(name));
+
+-- the trash view and the purge job only look at deleted rows
+CREATE INDEX IF NOT EXISTS garage_items_deleted_idx ON garage_items (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS garage_spaces_deleted_idx ON garage_spaces (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- keyset pagination (ORDER BY <column>, id) and the updated_since filter
+CREATE INDEX IF NOT EXISTS garage_items_updated_idx ON garage_items (updated_at, id);
+CREATE INDEX IF NOT EXISTS garage_items_name_idx ON garage_items (name, id);
+
+-- Append-only ledger; garage_items.quantity is the running sum of these deltas
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id             BIGSERIAL PRIMARY KEY,
+    item_id        BIGINT NOT NULL REFERENCES garage_items(id) ON DELETE CASCADE,
+    delta          INT NOT NULL CHECK (delta <> 0),
+    reason         TEXT NOT NULL CHECK (reason IN ('purchase', 'used', 'lost', 'adjustment')),
+    note           TEXT,
+    actor_id       INT REFERENCES users(id) ON DELETE SET NULL,
+    quantity_after INT NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS stock_movements_item_idx ON stock_movements (item_id, created_at DESC);
+
+CREATE OR REPLACE FUNCTION stock_movements_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'stock_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS stock_movements_no_update ON stock_movements;
+CREATE TRIGGER stock_movements_no_update
+    BEFORE UPDATE ON stock_movements
+    FOR EACH ROW EXECUTE FUNCTION stock_movements_immutable();
+
+-- items from before the ledger get their quantity booked as initial stock,
+-- like insertGarageItem does for new ones
+INSERT INTO stock_movements (item_id, delta, reason, note, quantity_after, created_at)
+SELECT i.id, i.quantity, 'adjustment', 'initial stock', i.quantity, i.created_at
+FROM garage_items i
+WHERE i.quantity <> 0
+  AND NOT EXISTS (SELECT 1 FROM stock_movements m WHERE m.item_id = i.id);
+
+-- Low-stock alerts; at most one open (unacknowledged) alert per item
+CREATE TABLE IF NOT EXISTS garage_alerts (
+    id              BIGSERIAL PRIMARY KEY,
+    item_id         BIGINT NOT NULL REFERENCES garage_items(id) ON DELETE CASCADE,
+    kind            TEXT NOT NULL DEFAULT 'low_stock',
+    quantity        INT NOT NULL,
+    min_quantity    INT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    notified_at     TIMESTAMPTZ,
+    acknowledged_at TIMESTAMPTZ,
+    acknowledged_by INT REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS garage_alerts_open_idx ON garage_alerts (item_id) WHERE acknowledged_at IS NULL;
+
+-- Items lent out; a row stays open until returned_at is set
+CREATE TABLE IF NOT EXISTS garage_loans (
+    id               BIGSERIAL PRIMARY KEY,
+    item_id          BIGINT NOT NULL REFERENCES garage_items(id) ON DELETE CASCADE,
+    quantity         INT NOT NULL CHECK (quantity > 0),
+    borrower_user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    borrower_contact TEXT, -- free text for borrowers without an account ("Ion, nr. 12")
+    lent_by          INT REFERENCES users(id) ON DELETE SET NULL,
+    note             TEXT,
+    checked_out_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    due_at           TIMESTAMPTZ,
+    returned_at      TIMESTAMPTZ,
+    last_reminded_at TIMESTAMPTZ,
+    overdue_event_at TIMESTAMPTZ -- when the loan.overdue webhook was queued
+);
+
+ALTER TABLE garage_loans ADD COLUMN IF NOT EXISTS overdue_event_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS garage_loans_open_idx ON garage_loans (item_id) WHERE returned_at IS NULL;
+
+-- Future bookings of an item; overlap is checked against quantity in a serializable transaction
+CREATE TABLE IF NOT EXISTS garage_reservations (
+    id           BIGSERIAL PRIMARY KEY,
+    item_id      BIGINT NOT NULL REFERENCES garage_items(id) ON DELETE CASCADE,
+    quantity     INT NOT NULL DEFAULT 1 CHECK (quantity > 0),
+    reserved_by  INT REFERENCES users(id) ON DELETE SET NULL,
+    starts_at    TIMESTAMPTZ NOT NULL,
+    ends_at      TIMESTAMPTZ NOT NULL,
+    note         TEXT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    cancelled_at TIMESTAMPTZ,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS garage_reservations_item_idx ON garage_reservations (item_id, starts_at) WHERE cancelled_at IS NULL;
+
+-- Recurring upkeep (oil change every 50h, inspection every year, ...)
+CREATE TABLE IF NOT EXISTS maintenance_plans (
+    id               BIGSERIAL PRIMARY KEY,
+    item_id          BIGINT NOT NULL REFERENCES garage_items(id) ON DELETE CASCADE,
+    title            TEXT NOT NULL,
+    description      TEXT,
+    schedule_kind    TEXT NOT NULL CHECK (schedule_kind IN ('days', 'hours', 'rrule')),
+    interval_days    INT CHECK (interval_days > 0),
+    interval_hours   NUMERIC(10,1) CHECK (interval_hours > 0),
+    rrule            TEXT,
+    starts_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(), -- anchor / DTSTART
+    last_done_at     TIMESTAMPTZ,
+    last_done_hours  NUMERIC(10,1),
+    next_due_at      TIMESTAMPTZ,   -- days / rrule plans
+    next_due_hours   NUMERIC(10,1), -- hours plans
+    last_reminded_at TIMESTAMPTZ,
+    created_by       INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (
+        (schedule_kind = 'days'  AND interval_days IS NOT NULL) OR
+        (schedule_kind = 'hours' AND interval_hours IS NOT NULL) OR
+        (schedule_kind = 'rrule' AND rrule IS NOT NULL)
+    )
+);
+
+CREATE INDEX IF NOT EXISTS maintenance_plans_item_idx ON maintenance_plans (item_id);
+
+CREATE TABLE IF NOT EXISTS maintenance_logs (
+    id           BIGSERIAL PRIMARY KEY,
+    plan_id      BIGINT NOT NULL REFERENCES maintenance_plans(id) ON DELETE CASCADE,
+    item_id      BIGINT NOT NULL REFERENCES garage_items(id) ON DELETE CASCADE,
+    performed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    performed_by INT REFERENCES users(id) ON DELETE SET NULL,
+    notes        TEXT,
+    cost         NUMERIC(12,2) CHECK (cost >= 0),
+    currency     CHAR(3),
+    usage_hours  NUMERIC(10,1), -- meter reading when the work was done
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS maintenance_logs_plan_idx ON maintenance_logs (plan_id, performed_at DESC);
+
+-- Receipts, warranty cards, photos... stored in the DB so backups stay in one place
+CREATE TABLE IF NOT EXISTS garage_attachments (
+    id           BIGSERIAL PRIMARY KEY,
+    item_id      BIGINT NOT NULL REFERENCES garage_items(id) ON DELETE CASCADE,
+    kind         TEXT NOT NULL DEFAULT 'other' CHECK (kind IN ('receipt', 'warranty', 'photo', 'manual', 'other')),
+    filename     TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes   INT NOT NULL,
+    data         BYTEA NOT NULL,
+    uploaded_by  INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS garage_attachments_item_idx ON garage_attachments (item_id);
+
+-- Large imports run in chunks; processed_rows is committed together with each
+-- chunk, so a failed job resumes exactly where it stopped
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id             TEXT PRIMARY KEY,
+    status         TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'failed', 'done')),
+    on_duplicate   TEXT NOT NULL DEFAULT 'skip',
+    records        JSONB NOT NULL,       -- mapped rows, see api.importRecord
+    total_rows     INT NOT NULL,
+    processed_rows INT NOT NULL DEFAULT 0,
+    created_count  INT NOT NULL DEFAULT 0,
+    updated_count  INT NOT NULL DEFAULT 0,
+    skipped_count  INT NOT NULL DEFAULT 0,
+    error          TEXT,
+    created_by     INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Who changed what. Written in the same transaction as the change it
+-- describes; before/after hold only the fields that changed (whole records
+-- for creates and deletes)
+CREATE TABLE IF NOT EXISTS audit_log (
+    id          BIGSERIAL PRIMARY KEY,
+    actor_id    INT,          -- no FK: entries outlive the users they name
+    action      TEXT NOT NULL, -- "item.update", "auth.login_failed"...
+    entity_type TEXT,
+    entity_id   BIGINT,
+    ip          TEXT,
+    user_agent  TEXT,
+    before      JSONB,
+    after       JSONB,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- hash chain, filled in by audit.Seal once the entry has committed
+    seq         BIGINT UNIQUE,
+    prev_hash   TEXT,
+    hash        TEXT
+);
+
+ALTER TABLE audit_log
+    ADD COLUMN IF NOT EXISTS seq BIGINT UNIQUE,
+    ADD COLUMN IF NOT EXISTS prev_hash TEXT,
+    ADD COLUMN IF NOT EXISTS hash TEXT;
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity_type, entity_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_created_idx ON audit_log (created_at);
+
+CREATE INDEX IF NOT EXISTS audit_log_unsealed_idx ON audit_log (id) WHERE seq IS NULL;
+
+-- the only change allowed is sealing: setting seq/prev_hash/hash once,
+-- leaving the entry itself untouched
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+       AND OLD.seq IS NULL AND NEW.seq IS NOT NULL
+       AND NEW.hash IS NOT NULL AND NEW.prev_hash IS NOT NULL
+       AND (NEW.id, NEW.actor_id, NEW.action, NEW.entity_type, NEW.entity_id, NEW.ip,
+            NEW.user_agent, NEW.before, NEW.after, NEW.created_at)
+           IS NOT DISTINCT FROM
+           (OLD.id, OLD.actor_id, OLD.action, OLD.entity_type, OLD.entity_id, OLD.ip,
+            OLD.user_agent, OLD.before, OLD.after, OLD.created_at)
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- signed statements that the chain had hash at seq (see audit.WriteCheckpoint)
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+    id         BIGSERIAL PRIMARY KEY,
+    seq        BIGINT NOT NULL,
+    hash       TEXT NOT NULL,
+    key_id     TEXT NOT NULL,
+    signature  TEXT NOT NULL, -- base64 Ed25519
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION audit_checkpoints_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_checkpoints is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_checkpoints_append_only ON audit_checkpoints;
+CREATE TRIGGER audit_checkpoints_append_only
+    BEFORE UPDATE OR DELETE ON audit_checkpoints
+    FOR EACH ROW EXECUTE FUNCTION audit_checkpoints_append_only();
+
+DROP TRIGGER IF EXISTS audit_checkpoints_no_truncate ON audit_checkpoints;
+CREATE TRIGGER audit_checkpoints_no_truncate
+    BEFORE TRUNCATE ON audit_checkpoints
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_checkpoints_append_only();
+
+-- Outbound webhooks: endpoints registered by owners and the durable queue /
+-- delivery log the worker sends from (see internal/webhooks)
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id          BIGSERIAL PRIMARY KEY,
+    url         TEXT NOT NULL,
+    secret      TEXT NOT NULL, -- HMAC key for X-Blaccend-Signature
+    events      TEXT[] NOT NULL,
+    description TEXT,
+    active      BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by  INT REFERENCES users(id) ON DELETE SET NULL,
     created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id               BIGSERIAL PRIMARY KEY,
+    endpoint_id      BIGINT NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    event            TEXT NOT NULL,
+    payload          JSONB NOT NULL,
+    status           TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts         INT NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_status_code INT,
+    last_error       TEXT,
+    replay_of        BIGINT REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at     TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_endpoint_idx ON webhook_deliveries (endpoint_id, id);
+
+-- Change log of items and spaces for live updates and offline sync. Rows are
+-- written by trigger in the changing transaction; seq is assigned after commit
+-- by changelog.Sequence, so it grows in commit order.
+CREATE TABLE IF NOT EXISTS garage_changes (
+    id          BIGSERIAL PRIMARY KEY,
+    seq         BIGINT UNIQUE,
+    entity_type TEXT NOT NULL,   -- item, space
+    entity_id   BIGINT NOT NULL,
+    action      TEXT NOT NULL,   -- created, updated, deleted, restored
+    space_id    BIGINT,          -- items only
+    version     INT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE SEQUENCE IF NOT EXISTS garage_changes_seq;
+CREATE INDEX IF NOT EXISTS garage_changes_unsequenced_idx ON garage_changes (id) WHERE seq IS NULL;
+CREATE INDEX IF NOT EXISTS garage_changes_created_idx ON garage_changes (created_at);
+
+-- highest seq removed by changelog.Prune; older sync tokens need a full resync
+CREATE TABLE IF NOT EXISTS garage_changes_pruned (
+    only_row BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (only_row),
+    seq      BIGINT NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION garage_changes_capture() RETURNS trigger AS $$
+DECLARE
+    kind   TEXT := CASE TG_TABLE_NAME WHEN 'garage_items' THEN 'item' ELSE 'space' END;
+    action TEXT;
+    r      JSONB;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        action := 'created';
+        r := to_jsonb(NEW);
+    ELSIF TG_OP = 'DELETE' THEN
+        -- purging the trash: clients already saw the delete
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        action := 'deleted';
+        r := to_jsonb(OLD);
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        action := 'deleted';
+        r := to_jsonb(NEW);
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        action := 'restored';
+        r := to_jsonb(NEW);
+    ELSIF NEW.deleted_at IS NOT NULL THEN
+        -- changes inside the trash are invisible to clients
+        RETURN NULL;
+    ELSIF NEW.version = OLD.version THEN
+        -- bookkeeping only (see bump_row_version): nothing a client can see
+        RETURN NULL;
+    ELSE
+        action := 'updated';
+        r := to_jsonb(NEW);
+    END IF;
+
+    INSERT INTO garage_changes (entity_type, entity_id, action, space_id, version)
+    VALUES (kind, (r->>'id')::BIGINT, action, (r->>'space_id')::BIGINT, (r->>'version')::INT);
+
+    -- delivered on commit; one per transaction since the payload never changes
+    PERFORM pg_notify('garage_changes_pending', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS garage_spaces_changes ON garage_spaces;
+CREATE TRIGGER garage_spaces_changes
+    AFTER INSERT OR UPDATE OR DELETE ON garage_spaces
+    FOR EACH ROW EXECUTE FUNCTION garage_changes_capture();
+
+DROP TRIGGER IF EXISTS garage_items_changes ON garage_items;
+CREATE TRIGGER garage_items_changes
+    AFTER INSERT OR UPDATE OR DELETE ON garage_items
+    FOR EACH ROW EXECUTE FUNCTION garage_changes_capture();
+
+-- Results of applied POST /sync mutations, so a client resending a batch
+-- gets the original results back instead of applying it twice. Pruned with
+-- the change log.
+CREATE TABLE IF NOT EXISTS sync_mutations (
+    user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    mutation_id TEXT NOT NULL,
+    action      TEXT NOT NULL,   -- item.create, space.delete...
+    result      JSONB NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, mutation_id)
+);
+
+CREATE INDEX IF NOT EXISTS sync_mutations_created_idx ON sync_mutations (created_at);
+
+-- Durable background jobs (services.Runner). Jobs are inserted in the
+-- transaction that needs them and claimed by workers with SKIP LOCKED;
+-- run_at is both when a pending job is due and the lease of a claimed one.
+CREATE TABLE IF NOT EXISTS jobs (
+    id          BIGSERIAL PRIMARY KEY,
+    kind        TEXT NOT NULL,
+    payload     JSONB NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'done', 'dead')),
+    run_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    attempts    INT NOT NULL DEFAULT 0,
+    last_error  TEXT,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, id);
+
+-- next run of each recurring job; the row lock decides which replica enqueues it
+CREATE TABLE IF NOT EXISTS job_schedules (
+    kind        TEXT PRIMARY KEY,
+    next_run_at TIMESTAMPTZ NOT NULL,
+    last_run_at TIMESTAMPTZ
+);
+
+-- =========================
+-- NOTIFICATIONS
+-- =========================
+
+-- how each user wants each event (low_stock, loans, maintenance, warranty,
+-- security) delivered; no row means the default (daily email, security
+-- instantly)
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event      TEXT NOT NULL,
+    channel    TEXT NOT NULL CHECK (channel IN ('email', 'webhook', 'none')),
+    cadence    TEXT NOT NULL CHECK (cadence IN ('instant', 'daily', 'weekly')),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, event),
+    CHECK (event <> 'security' OR (channel = 'email' AND cadence = 'instant'))
+);
+
+-- security notices used to allow digests
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint
+                   WHERE conname = 'notification_preferences_check'
+                     AND pg_get_constraintdef(oid) LIKE '%cadence%') THEN
+        UPDATE notification_preferences SET cadence = 'instant' WHERE event = 'security';
+        ALTER TABLE notification_preferences DROP CONSTRAINT IF EXISTS notification_preferences_check;
+        ALTER TABLE notification_preferences ADD CONSTRAINT notification_preferences_check
+            CHECK (event <> 'security' OR (channel = 'email' AND cadence = 'instant'));
+    END IF;
+END;
+$$;
+
+-- pending until delivered alone (instant) or with a digest
+CREATE TABLE IF NOT EXISTS notifications (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event        TEXT NOT NULL,
+    dedupe_key   TEXT, -- a newer pending notification with the same key replaces this one
+    payload      JSONB NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMPTZ,
+    channel      TEXT -- how it went out; 'none' when it was dropped
+);
+
+CREATE INDEX IF NOT EXISTS notifications_pending_idx
+    ON notifications (user_id, event) WHERE delivered_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS notifications_dedupe_idx
+    ON notifications (user_id, event, dedupe_key) WHERE delivered_at IS NULL;
+
+-- where each user has logged in from; a login from a new device, network
+-- (/24, /48) or country gets a security notification with a "this wasn't
+-- me" link (alert_token)
+CREATE TABLE IF NOT EXISTS known_devices (
+    id            BIGSERIAL PRIMARY KEY,
+    user_id       INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    fingerprint   TEXT NOT NULL,
+    ip_range      TEXT NOT NULL,
+    country       TEXT NOT NULL DEFAULT '', -- '' when GeoIP doesn't know
+    user_agent    TEXT,
+    last_ip       TEXT,
+    alert_token   TEXT UNIQUE,
+    alerted_at    TIMESTAMPTZ, -- the link in the alert expires a week after
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    denied_at     TIMESTAMPTZ, -- "this wasn't me"; no longer counts as known
+    UNIQUE (user_id, fingerprint, ip_range, country)
+);
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+)
+
+// schema is idempotent: docker runs it on an empty volume, Migrate on every
+// start, so existing databases pick up new tables and columns.
+//
+//go:embed init.sql
+var schema string
+
+// migrateLockKey serialises Migrate across server instances (pg_advisory_xact_lock).
+const migrateLockKey = 0x736368 // "sch"
+
+// Migrate brings the database up to the current schema in one transaction.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrateLockKey); err != nil {
+		return err
+	}
+	// no arguments: sent as one simple-protocol query, so the whole file runs
+	if _, err := tx.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("apply schema: %w", err)
+	}
+	return tx.Commit()
+}
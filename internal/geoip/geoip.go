@@ -0,0 +1,111 @@
+package geoip
+
+// country lookups for login alerts. The data comes from a file on disk, so
+// no address ever leaves the server and nothing breaks without a network.
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Locator finds the country an IP address is in.
+type Locator interface {
+	// Country returns the ISO 3166 alpha-2 code for ip, "" when unknown.
+	Country(ip netip.Addr) string
+}
+
+// Disabled knows no countries; used when no database is configured.
+var Disabled Locator = disabled{}
+
+type disabled struct{}
+
+func (disabled) Country(netip.Addr) string { return "" }
+
+// Ranges is a Locator over sorted, non-overlapping address ranges.
+type Ranges struct {
+	ranges []countryRange
+}
+
+type countryRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// Open loads a range database: CSV lines of first address, last address and
+// country code, e.g. "1.0.0.0,1.0.0.255,AU" (the DB-IP "IP to Country Lite"
+// format; IPv4 and IPv6 can be mixed). An empty path gives Disabled, and so
+// does an error, next to the error.
+func Open(path string) (Locator, error) {
+	if path == "" {
+		return Disabled, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Disabled, err
+	}
+	defer f.Close()
+
+	var db Ranges
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) < 3 {
+			return Disabled, fmt.Errorf("%s:%d: want first,last,country", path, line)
+		}
+		start, err1 := netip.ParseAddr(strings.Trim(fields[0], `" `))
+		end, err2 := netip.ParseAddr(strings.Trim(fields[1], `" `))
+		if err1 != nil || err2 != nil || start.BitLen() != end.BitLen() || end.Less(start) {
+			return Disabled, fmt.Errorf("%s:%d: bad address range", path, line)
+		}
+		country := strings.ToUpper(strings.Trim(fields[2], `" `))
+		db.ranges = append(db.ranges, countryRange{start.Unmap(), end.Unmap(), country})
+	}
+	if err := sc.Err(); err != nil {
+		return Disabled, err
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].start.Less(db.ranges[j].start) })
+	return &db, nil
+}
+
+func (db *Ranges) Country(ip netip.Addr) string {
+	ip = ip.Unmap()
+	// the last range starting at or before ip
+	i := sort.Search(len(db.ranges), func(i int) bool { return ip.Less(db.ranges[i].start) }) - 1
+	if i < 0 {
+		return ""
+	}
+	r := db.ranges[i]
+	if r.end.BitLen() != ip.BitLen() || r.end.Less(ip) {
+		return ""
+	}
+	// "ZZ" is what the lite databases use for unassigned space
+	if r.country == "ZZ" {
+		return ""
+	}
+	return r.country
+}
+
+// Network is the range an address is treated as part of when telling new
+// logins from familiar ones: its /24 for IPv4, /48 for IPv6. Roaming within
+// a provider's block shouldn't look like a new place.
+func Network(ip netip.Addr) string {
+	ip = ip.Unmap()
+	bits := 48
+	if ip.Is4() {
+		bits = 24
+	}
+	p, err := ip.Prefix(bits)
+	if err != nil {
+		return ip.String()
+	}
+	return p.String()
+}
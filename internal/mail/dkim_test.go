@@ -0,0 +1,327 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/BlaccStacc/blaccend/internal/config"
+)
+
+// The ed25519-sha256 example of RFC 8463 Appendix A.
+const (
+	rfc8463Seed   = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+	rfc8463Public = "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+	rfc8463Sig    = "/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11BusFa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw=="
+	rfc8463BH     = "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="
+
+	rfc8463Message = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+		" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+		"From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n" +
+		"\r\n" +
+		"We lost the game.  Are you hungry yet?\r\n" +
+		"\r\n" +
+		"Joe.\r\n"
+)
+
+func TestDKIMRFC8463Vector(t *testing.T) {
+	header, body, _ := strings.Cut(rfc8463Message, "\r\n\r\n")
+	fields := splitHeaderFields(header + "\r\n")
+	if len(fields) != 6 {
+		t.Fatalf("split into %d header fields, want 6", len(fields))
+	}
+
+	bh := sha256.Sum256(relaxedBody([]byte(body)))
+	if got := base64.StdEncoding.EncodeToString(bh[:]); got != rfc8463BH {
+		t.Errorf("body hash %s, want %s", got, rfc8463BH)
+	}
+
+	// h= lists from, subject and date twice; the second ones don't exist
+	var canon strings.Builder
+	for _, f := range fields[1:] {
+		canon.WriteString(relaxedHeader(f))
+		canon.WriteString("\r\n")
+	}
+	sig := fields[0]
+	canon.WriteString(relaxedHeader(sig[:strings.LastIndex(sig, "b=")+2]))
+	digest := sha256.Sum256([]byte(canon.String()))
+
+	seed, _ := base64.StdEncoding.DecodeString(rfc8463Seed)
+	key := ed25519.NewKeyFromSeed(seed)
+	if got := base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)); got != rfc8463Public {
+		t.Fatalf("public key %s, want %s", got, rfc8463Public)
+	}
+	// Ed25519 is deterministic, so canonicalizing right reproduces the signature
+	b, err := key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := base64.StdEncoding.EncodeToString(b); got != rfc8463Sig {
+		t.Errorf("signature %s, want %s", got, rfc8463Sig)
+	}
+
+	pub, _ := base64.StdEncoding.DecodeString(rfc8463Public)
+	if err := verifyDKIM([]byte(rfc8463Message), ed25519.PublicKey(pub)); err != nil {
+		t.Errorf("test verifier rejects the RFC example: %v", err)
+	}
+}
+
+func TestRelaxedBody(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"\r\n\r\n", ""},
+		{"a  b \t\r\n", "a b\r\n"},
+		{" \tindented\r\n", " indented\r\n"},
+		{"text\r\n\r\n\r\n", "text\r\n"},
+		{"no final newline", "no final newline\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(relaxedBody([]byte(tt.in))); got != tt.want {
+			t.Errorf("relaxedBody(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRelaxedHeader(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Subject: Hello", "subject:Hello"},
+		{"SUBJECT :  Hello   there  ", "subject:Hello there"},
+		{"Subject: folded\r\n\tover\r\n  lines", "subject:folded over lines"},
+	}
+	for _, tt := range tests {
+		if got := relaxedHeader(tt.in); got != tt.want {
+			t.Errorf("relaxedHeader(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func writeKey(t *testing.T, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDKIMSignRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		key  crypto.Signer
+	}{
+		{"rsa-sha256", rsaKey},
+		{"ed25519-sha256", edKey},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := LoadDKIMSigner(&config.Config{
+				SMTPFrom:     "Garage <garage@Example.org>",
+				DKIMSelector: "mail",
+				DKIMKeyFile:  writeKey(t, tt.key),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signer.Domain != "example.org" {
+				t.Errorf("domain %q, want example.org from SMTP_FROM", signer.Domain)
+			}
+
+			transport := &MemoryTransport{}
+			m := &Mailer{Transport: transport, From: "Garage <garage@example.org>", DKIM: signer}
+			err = m.Send(Message{
+				To:          "ana@example.net",
+				Subject:     "Stoc redus: șurubelnițe — a long subject that has to be folded over more than one line",
+				Text:        "Only 2 left.  \r\n\r\n\r\n",
+				HTML:        "<p>Only 2 left.</p>",
+				Unsubscribe: "https://garage.example.org/notifications",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := transport.Messages()[0].Data
+
+			if !bytes.HasPrefix(msg, []byte("DKIM-Signature: v=1; a="+tt.name+";")) {
+				t.Fatalf("message doesn't start with a %s signature:\n%s", tt.name, msg)
+			}
+			if err := verifyDKIM(msg, tt.key.Public()); err != nil {
+				t.Fatalf("signature doesn't verify: %v\n%s", err, msg)
+			}
+
+			// what receivers do to mail in transit mustn't matter for relaxed
+			// canonicalization, any real change must
+			for _, mod := range []struct {
+				name, old, new string
+				valid          bool
+			}{
+				{"header refolded", "\r\nSubject: ", "\r\nSubject:  \r\n\t", true},
+				{"trailing whitespace", "Only 2 left.", "Only 2 left.   ", true},
+				{"subject changed", "\r\nSubject: ", "\r\nSubject: Re: ", false},
+				{"body changed", "Only 2 left.", "Only 9 left.", false},
+				{"signed header removed", "\r\nAuto-Submitted: auto-generated", "", false},
+			} {
+				changed := bytes.Replace(msg, []byte(mod.old), []byte(mod.new), 1)
+				if bytes.Equal(changed, msg) {
+					t.Fatalf("%s: %q not in the message", mod.name, mod.old)
+				}
+				err := verifyDKIM(changed, tt.key.Public())
+				if mod.valid && err != nil {
+					t.Errorf("%s: signature broke: %v", mod.name, err)
+				}
+				if !mod.valid && err == nil {
+					t.Errorf("%s: signature still verifies", mod.name)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDKIMSigner(t *testing.T) {
+	signer, err := LoadDKIMSigner(&config.Config{})
+	if signer != nil || err != nil {
+		t.Errorf("unconfigured: got %v, %v; want nil, nil", signer, err)
+	}
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	path := writeKey(t, edKey)
+	weak, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	for _, cfg := range []config.Config{
+		{DKIMSelector: "mail"},
+		{DKIMKeyFile: path},
+		{DKIMSelector: "mail", DKIMKeyFile: path}, // no domain anywhere
+		{DKIMSelector: "mail", DKIMKeyFile: filepath.Join(t.TempDir(), "missing.pem"), DKIMDomain: "example.org"},
+		{DKIMSelector: "mail", DKIMKeyFile: writeKey(t, weak), DKIMDomain: "example.org"},
+	} {
+		if _, err := LoadDKIMSigner(&cfg); err == nil {
+			t.Errorf("LoadDKIMSigner(%+v) succeeded, want error", cfg)
+		}
+	}
+
+	signer, err = LoadDKIMSigner(&config.Config{DKIMSelector: "mail", DKIMKeyFile: path, DKIMDomain: "mail.example.org", SMTPFrom: "a@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Domain != "mail.example.org" {
+		t.Errorf("domain %q, want DKIM_DOMAIN", signer.Domain)
+	}
+}
+
+// verifyDKIM checks the first DKIM-Signature of msg the way a receiver does
+// (RFC 6376 6.1.3), with relaxed/relaxed canonicalization written
+// independently of the signer's.
+func verifyDKIM(msg []byte, pub crypto.PublicKey) error {
+	header, body, ok := strings.Cut(string(msg), "\r\n\r\n")
+	if !ok {
+		return errors.New("no body")
+	}
+	// unfold into one string per field
+	unfolded := strings.Split(regexp.MustCompile(`\r\n([ \t])`).ReplaceAllString(header, "$1"), "\r\n")
+
+	canonHeader := func(field string) string {
+		name, value, _ := strings.Cut(field, ":")
+		value = regexp.MustCompile(`[ \t]+`).ReplaceAllString(value, " ")
+		return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + strings.Trim(value, " \t")
+	}
+
+	sigField := unfolded[0]
+	if !strings.HasPrefix(strings.ToLower(sigField), "dkim-signature:") {
+		return errors.New("no DKIM-Signature first")
+	}
+	tags := map[string]string{}
+	for _, tag := range strings.Split(sigField[len("DKIM-Signature:"):], ";") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(k)] = regexp.MustCompile(`\s+`).ReplaceAllString(v, "")
+	}
+	if tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("canonicalization %q", tags["c"])
+	}
+
+	// body: strip trailing whitespace, squeeze runs, drop empty lines at the end
+	lines := strings.Split(body, "\r\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(regexp.MustCompile(`[ \t]+`).ReplaceAllString(l, " "), " ")
+	}
+	canonBody := strings.TrimRight(strings.Join(lines, "\r\n"), "\r\n")
+	if canonBody != "" {
+		canonBody += "\r\n"
+	}
+	bh := sha256.Sum256([]byte(canonBody))
+	if base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return errors.New("body hash mismatch")
+	}
+
+	// headers named in h=, each instance used once, bottom up
+	used := map[int]bool{}
+	var data strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.TrimSpace(name)
+		for i := len(unfolded) - 1; i >= 1; i-- {
+			n, _, _ := strings.Cut(unfolded[i], ":")
+			if !used[i] && strings.EqualFold(strings.TrimSpace(n), name) {
+				used[i] = true
+				data.WriteString(canonHeader(unfolded[i]) + "\r\n")
+				break
+			}
+		}
+	}
+	withoutB := regexp.MustCompile(`(b=)[^;]*$`).ReplaceAllString(sigField, "$1")
+	data.WriteString(canonHeader(withoutB))
+	digest := sha256.Sum256([]byte(data.String()))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return fmt.Errorf("algorithm %q for an RSA key", tags["a"])
+		}
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig)
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			return fmt.Errorf("algorithm %q for an Ed25519 key", tags["a"])
+		}
+		if !ed25519.Verify(k, digest[:], sig) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("key type %T", pub)
+}
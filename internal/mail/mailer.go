@@ -2,89 +2,187 @@ package mail
 
 import (
 	"bytes"
+	"crypto/rand"
 	"fmt"
-	"html/template"
-	"net/smtp"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"time"
 
 	"github.com/BlaccStacc/blaccend/internal/config"
 )
 
 type Mailer struct {
-	Host     string
-	Port     int
-	Username string
-	Password string
-	From     string
-	AppURL   string
+	Transport Transport
+	From      string
+	AppURL    string
+	DKIM      *DKIMSigner // nil sends unsigned mail
 }
 
 func NewMailer(cfg *config.Config) *Mailer {
 	return &Mailer{
-		Host:     cfg.SMTPHost,
-		Port:     cfg.SMTPPort,
-		Username: cfg.SMTPUser,
-		Password: cfg.SMTPPass,
-		From:     cfg.SMTPFrom,
-		AppURL:   cfg.AppURL,
+		Transport: NewTransport(cfg),
+		From:      cfg.SMTPFrom,
+		AppURL:    cfg.AppURL,
 	}
 }
 
-func (m *Mailer) Send(to string, subject string, htmlBody string) error {
-	if m.Host == "" || m.Port == 0 {
-		return fmt.Errorf("smtp not configured (host=%q port=%d)", m.Host, m.Port)
+// Recipient is an address and the locale to write to it in.
+type Recipient struct {
+	Email  string
+	Locale string
+}
+
+// Message is one mail to one address.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+
+	// Unsubscribe is where notification mail can be turned off; it becomes
+	// the List-Unsubscribe header
+	Unsubscribe string
+}
+
+// Send mails a multipart/alternative message with a text and an HTML body,
+// DKIM-signed when the mailer has a key.
+func (m *Mailer) Send(msg Message) error {
+	from, err := netmail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("mail from address %q: %w", m.From, err)
 	}
-	if m.From == "" {
-		return fmt.Errorf("smtp from address is empty")
+	to, err := netmail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("mail to address %q: %w", msg.To, err)
 	}
 
-	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
-
-	var msg bytes.Buffer
-	msg.WriteString(fmt.Sprintf("From: %s\r\n", m.From))
-	msg.WriteString(fmt.Sprintf("To: %s\r\n", to))
-	msg.WriteString(fmt.Sprintf("Subject: %s\r\n", subject))
-	msg.WriteString("MIME-Version: 1.0\r\n")
-	msg.WriteString("Content-Type: text/html; charset=\"UTF-8\"\r\n")
-	msg.WriteString("\r\n")
-	msg.WriteString(htmlBody)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + `; charset="UTF-8"`},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return err
+		}
+		if err := qp.Close(); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
 
-	var auth smtp.Auth
-	if m.Username != "" {
-		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	messageID, err := newMessageID(addressDomain(from.Address))
+	if err != nil {
+		return err
 	}
 
-	if err := smtp.SendMail(addr, auth, m.From, []string{to}, msg.Bytes()); err != nil {
-		return fmt.Errorf("smtp sendmail failed: %w", err)
+	var data bytes.Buffer
+	writeHeader(&data, "From", from.String())
+	writeHeader(&data, "To", to.String())
+	writeHeader(&data, "Subject", encodeHeader(msg.Subject))
+	writeHeader(&data, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&data, "Message-ID", messageID)
+	writeHeader(&data, "MIME-Version", "1.0")
+	writeHeader(&data, "Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", mw.Boundary()))
+	// tells autoresponders not to answer (RFC 3834)
+	writeHeader(&data, "Auto-Submitted", "auto-generated")
+	if msg.Unsubscribe != "" {
+		writeHeader(&data, "List-Unsubscribe", "<"+msg.Unsubscribe+">")
+	}
+	data.WriteString("\r\n")
+	data.Write(body.Bytes())
+
+	raw := data.Bytes()
+	if m.DKIM != nil {
+		if raw, err = m.DKIM.Sign(raw); err != nil {
+			return err
+		}
 	}
 
+	if err := m.Transport.Send(from.Address, []string{to.Address}, raw); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
 	return nil
 }
 
-func SendVerificationEmail(to, token string) error {
-	cfg := config.Load()
-	mailer := NewMailer(cfg)
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
+}
 
-	verifyURL := fmt.Sprintf("%s/verify-email?token=%s", cfg.AppURL, token)
+// encodeHeader RFC 2047-encodes non-ASCII text, folding between encoded
+// words so long subjects stay within the line length limit.
+func encodeHeader(s string) string {
+	return strings.ReplaceAll(mime.QEncoding.Encode("UTF-8", s), "?= =?", "?=\r\n =?")
+}
 
-	const tpl = `
-		<h2>Verify your email</h2>
-		<p>Click the link below to confirm your account:</p>
-		<p><a href="{{.URL}}">{{.URL}}</a></p>
-	`
+// newMessageID returns a unique Message-ID on the sender's domain.
+func newMessageID(domain string) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	if domain == "" {
+		domain = "localhost"
+	}
+	return fmt.Sprintf("<%d.%x@%s>", time.Now().Unix(), b, domain), nil
+}
 
-	t, err := template.New("verify").Parse(tpl)
+// sendTemplate renders the named templates in the recipient's locale and
+// sends the result.
+func (m *Mailer) sendTemplate(to Recipient, name string, data any) error {
+	subject, text, html, err := render(to.Locale, name, data)
 	if err != nil {
-		return fmt.Errorf("parse verify template: %w", err)
+		return err
 	}
+	return m.Send(Message{To: to.Email, Subject: subject, Text: text, HTML: html})
+}
 
-	var body bytes.Buffer
-	if err := t.Execute(&body, map[string]string{"URL": verifyURL}); err != nil {
-		return fmt.Errorf("execute verify template: %w", err)
+// sendNotification is sendTemplate for mail a user can opt out of.
+func (m *Mailer) sendNotification(to Recipient, name string, data any) error {
+	subject, text, html, err := render(to.Locale, name, data)
+	if err != nil {
+		return err
 	}
+	return m.Send(Message{
+		To:          to.Email,
+		Subject:     subject,
+		Text:        text,
+		HTML:        html,
+		Unsubscribe: m.AppURL + "/settings/notifications",
+	})
+}
 
-	if err := mailer.Send(to, "Verify your email", body.String()); err != nil {
+func (m *Mailer) SendVerificationEmail(to Recipient, token string) error {
+	verifyURL := fmt.Sprintf("%s/verify-email?token=%s", m.AppURL, token)
+
+	if err := m.sendTemplate(to, "verify", map[string]string{"URL": verifyURL}); err != nil {
 		return fmt.Errorf("send verification email: %w", err)
 	}
+	return nil
+}
+
+func (m *Mailer) SendPasswordResetEmail(to Recipient, token string) error {
+	resetURL := fmt.Sprintf("%s/reset-password?token=%s", m.AppURL, token)
 
+	if err := m.sendTemplate(to, "password_reset", map[string]string{"URL": resetURL}); err != nil {
+		return fmt.Errorf("send password reset email: %w", err)
+	}
 	return nil
 }
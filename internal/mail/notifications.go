@@ -0,0 +1,114 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+)
+
+// LowStockLine is one row of the low-stock digest.
+type LowStockLine struct {
+	ItemName        string
+	SpaceName       string
+	Quantity        int
+	MinQuantity     int
+	ReorderQuantity *int
+}
+
+func (m *Mailer) SendLowStockDigest(to Recipient, lines []LowStockLine) error {
+	err := m.sendNotification(to, "low_stock", map[string]any{
+		"Lines": lines,
+		"URL":   fmt.Sprintf("%s/garage/alerts", m.AppURL),
+	})
+	if err != nil {
+		return fmt.Errorf("send low-stock digest: %w", err)
+	}
+	return nil
+}
+
+// OverdueLoan describes a lent item that is past its return date.
+type OverdueLoan struct {
+	ItemName    string
+	Quantity    int
+	Borrower    string
+	DueAt       time.Time
+	DaysOverdue int
+}
+
+func (m *Mailer) SendOverdueLoanReminder(to Recipient, loans []OverdueLoan) error {
+	err := m.sendNotification(to, "overdue_loan", map[string]any{
+		"Loans": loans,
+		"URL":   fmt.Sprintf("%s/garage/loans?overdue=true", m.AppURL),
+	})
+	if err != nil {
+		return fmt.Errorf("send overdue-loan reminder: %w", err)
+	}
+	return nil
+}
+
+// MaintenanceDue is one plan in the maintenance reminder.
+type MaintenanceDue struct {
+	ItemName   string
+	Title      string
+	DueAt      *time.Time
+	DueHours   *float64
+	UsageHours float64
+	Overdue    bool
+}
+
+func (m *Mailer) SendMaintenanceReminder(to Recipient, due []MaintenanceDue) error {
+	err := m.sendNotification(to, "maintenance", map[string]any{
+		"Due": due,
+		"URL": fmt.Sprintf("%s/garage/maintenance", m.AppURL),
+	})
+	if err != nil {
+		return fmt.Errorf("send maintenance reminder: %w", err)
+	}
+	return nil
+}
+
+// WarrantyExpiry is one item in the warranty reminder.
+type WarrantyExpiry struct {
+	ItemName     string
+	SerialNumber *string
+	Vendor       *string
+	Expires      time.Time
+}
+
+func (m *Mailer) SendWarrantyReminder(to Recipient, items []WarrantyExpiry) error {
+	err := m.sendNotification(to, "warranty", map[string]any{
+		"Items": items,
+		"URL":   fmt.Sprintf("%s/garage/warranties", m.AppURL),
+	})
+	if err != nil {
+		return fmt.Errorf("send warranty reminder: %w", err)
+	}
+	return nil
+}
+
+// LoginAlert is a sign-in from somewhere the user hasn't used before.
+type LoginAlert struct {
+	At         time.Time
+	IP         string
+	Country    string
+	UserAgent  string
+	NewDevice  bool
+	NewNetwork bool
+	NewCountry bool
+	Token      string // for the "this wasn't me" link
+}
+
+// SendLoginAlert can't be unsubscribed from: security notices always go out.
+func (m *Mailer) SendLoginAlert(to Recipient, alerts []LoginAlert) error {
+	type alert struct {
+		LoginAlert
+		DenyURL string
+	}
+	list := make([]alert, len(alerts))
+	for i, a := range alerts {
+		list[i] = alert{a, fmt.Sprintf("%s/login-alert?token=%s", m.AppURL, a.Token)}
+	}
+	if err := m.sendTemplate(to, "login_alert", map[string]any{"Alerts": list}); err != nil {
+		return fmt.Errorf("send login alert: %w", err)
+	}
+	return nil
+}
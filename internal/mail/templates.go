@@ -0,0 +1,105 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Every mail has a text and an HTML template per locale under templates/:
+// <locale>/<name>.txt and <locale>/<name>.html. The text one also defines
+// "subject".
+
+//go:embed templates
+var templateFS embed.FS
+
+// DefaultLocale is used for users without a (supported) locale.
+const DefaultLocale = "en"
+
+// Locales lists the languages mail can be written in.
+var Locales = []string{"en", "ro"}
+
+var templateNames = []string{"verify", "password_reset", "login_alert", "low_stock", "overdue_loan", "maintenance", "warranty"}
+
+var templateFuncs = map[string]any{
+	"date":  func(t *time.Time) string { return t.UTC().Format("2006-01-02") },
+	"deref": func(f *float64) float64 { return *f },
+}
+
+type mailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templates by locale, then name
+var templates = loadTemplates()
+
+func loadTemplates() map[string]map[string]mailTemplate {
+	all := map[string]map[string]mailTemplate{}
+	for _, locale := range Locales {
+		all[locale] = map[string]mailTemplate{}
+		for _, name := range templateNames {
+			base := "templates/" + locale + "/" + name
+			all[locale][name] = mailTemplate{
+				text: texttemplate.Must(texttemplate.New(name+".txt").Funcs(templateFuncs).ParseFS(templateFS, base+".txt")),
+				html: htmltemplate.Must(htmltemplate.New(name+".html").Funcs(templateFuncs).ParseFS(templateFS, base+".html")),
+			}
+		}
+	}
+	return all
+}
+
+// IsLocale reports whether mail can be written in locale.
+func IsLocale(locale string) bool {
+	_, ok := templates[locale]
+	return ok
+}
+
+// MatchLocale returns the first supported language among candidates, which
+// may be tags ("ro-RO") or whole Accept-Language values, or DefaultLocale.
+func MatchLocale(candidates ...string) string {
+	for _, c := range candidates {
+		for _, tag := range strings.Split(c, ",") {
+			tag, _, _ = strings.Cut(tag, ";")
+			lang, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+			lang, _, _ = strings.Cut(lang, "_")
+			if lang = strings.ToLower(lang); IsLocale(lang) {
+				return lang
+			}
+		}
+	}
+	return DefaultLocale
+}
+
+// render fills in the subject, text and HTML bodies of a mail.
+func render(locale, name string, data any) (subject, text, html string, err error) {
+	if !IsLocale(locale) {
+		locale = DefaultLocale
+	}
+	t, ok := templates[locale][name]
+	if !ok {
+		return "", "", "", fmt.Errorf("no mail template %q", name)
+	}
+
+	var buf bytes.Buffer
+	if err := t.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", fmt.Errorf("execute %s subject: %w", name, err)
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := t.text.Execute(&buf, data); err != nil {
+		return "", "", "", fmt.Errorf("execute %s text: %w", name, err)
+	}
+	text = strings.TrimSpace(buf.String()) + "\n"
+
+	buf.Reset()
+	if err := t.html.Execute(&buf, data); err != nil {
+		return "", "", "", fmt.Errorf("execute %s html: %w", name, err)
+	}
+	return subject, text, buf.String(), nil
+}
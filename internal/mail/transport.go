@@ -0,0 +1,216 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/config"
+)
+
+// Transport hands a finished message (headers and body, CRLF line endings)
+// to whatever delivers it.
+type Transport interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// NewTransport picks the transport MAIL_TRANSPORT asks for: smtp (default),
+// maildir for development, or memory to keep everything in the process.
+func NewTransport(cfg *config.Config) Transport {
+	switch cfg.MailTransport {
+	case "maildir":
+		return &MaildirTransport{Dir: cfg.MailDir}
+	case "memory":
+		return &MemoryTransport{}
+	}
+	return &SMTPTransport{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUser,
+		Password: cfg.SMTPPass,
+		TLS:      cfg.SMTPTLS,
+	}
+}
+
+// ---------- SMTP ----------
+
+// smtpTimeout bounds a whole SMTP session, dial included.
+const smtpTimeout = 30 * time.Second
+
+// SMTPTransport talks to a mail server. TLS is one of:
+//
+//	auto      implicit TLS on port 465, otherwise STARTTLS when offered
+//	starttls  STARTTLS, refuse servers that don't offer it
+//	tls       implicit TLS (SMTPS)
+//	none      plain text, e.g. a local MailHog
+type SMTPTransport struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      string
+}
+
+func (t *SMTPTransport) Send(from string, to []string, msg []byte) error {
+	if t.Host == "" || t.Port == 0 {
+		return fmt.Errorf("smtp not configured (host=%q port=%d)", t.Host, t.Port)
+	}
+
+	mode := t.TLS
+	if mode == "" || mode == "auto" {
+		mode = "auto"
+		if t.Port == 465 {
+			mode = "tls"
+		}
+	}
+	addr := net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+	tlsConfig := &tls.Config{ServerName: t.Host}
+
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if mode == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp dial: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp greeting: %w", err)
+	}
+	defer c.Close()
+
+	if mode == "auto" || mode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("smtp starttls: %w", err)
+			}
+		} else if mode == "starttls" {
+			return errors.New("smtp server does not offer STARTTLS")
+		}
+	}
+
+	if t.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support AUTH")
+		}
+		if err := c.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("smtp RCPT TO %s: %w", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	return c.Quit()
+}
+
+// ---------- maildir ----------
+
+var maildirSeq atomic.Int64
+
+// MaildirTransport delivers into a Maildir (Dir/new), which most mail
+// clients can open; handy in development. Return-Path and Delivered-To
+// record the envelope.
+type MaildirTransport struct {
+	Dir string
+}
+
+func (t *MaildirTransport) Send(from string, to []string, msg []byte) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.Dir, sub), 0o755); err != nil {
+			return fmt.Errorf("maildir: %w", err)
+		}
+	}
+
+	host, _ := os.Hostname()
+	host = strings.NewReplacer("/", "_", ":", "_").Replace(host)
+	name := fmt.Sprintf("%d.P%dQ%d.%s", time.Now().Unix(), os.Getpid(), maildirSeq.Add(1), host)
+
+	var envelope strings.Builder
+	fmt.Fprintf(&envelope, "Return-Path: <%s>\r\n", from)
+	for _, rcpt := range to {
+		fmt.Fprintf(&envelope, "Delivered-To: %s\r\n", rcpt)
+	}
+
+	tmp := filepath.Join(t.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, append([]byte(envelope.String()), msg...), 0o644); err != nil {
+		return fmt.Errorf("maildir: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(t.Dir, "new", name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("maildir: %w", err)
+	}
+	return nil
+}
+
+// ---------- memory ----------
+
+// SentMessage is one message a MemoryTransport accepted.
+type SentMessage struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// MemoryTransport keeps messages in memory instead of sending them; meant
+// for tests.
+type MemoryTransport struct {
+	mu   sync.Mutex
+	sent []SentMessage
+}
+
+func (t *MemoryTransport) Send(from string, to []string, msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, SentMessage{
+		From: from,
+		To:   append([]string(nil), to...),
+		Data: append([]byte(nil), msg...),
+	})
+	return nil
+}
+
+// Messages returns what was sent so far, oldest first.
+func (t *MemoryTransport) Messages() []SentMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SentMessage(nil), t.sent...)
+}
+
+// Reset forgets every message.
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = nil
+}
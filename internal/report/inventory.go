@@ -0,0 +1,258 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InventoryRow is one item line of the home-inventory report.
+type InventoryRow struct {
+	ItemID          int64
+	Name            string
+	Breadcrumb      string // "Garage > Shelf A1"
+	Quantity        int
+	SerialNumber    *string
+	Vendor          *string
+	PurchaseDate    *time.Time
+	PurchasePrice   *float64 // per unit
+	Currency        *string
+	WarrantyExpires *time.Time
+	EstimatedValue  *float64 // depreciated, for the whole quantity
+	PhotoURL        string
+
+	// JPEG thumbnail; only the PDF writer uses it
+	Thumbnail                       []byte
+	ThumbnailWidth, ThumbnailHeight int
+}
+
+// InventoryWriter streams report rows out in some format.
+type InventoryWriter interface {
+	WriteRow(InventoryRow) error
+	Close() error
+}
+
+// Depreciate estimates today's value of a unit bought at price on purchased,
+// losing rate (0.2 = 20%) of its remaining value every year. Without a
+// purchase date the price is returned unchanged.
+func Depreciate(price float64, purchased *time.Time, rate float64, now time.Time) float64 {
+	if purchased == nil || rate <= 0 {
+		return price
+	}
+	years := now.Sub(*purchased).Hours() / 24 / 365.25
+	if years <= 0 {
+		return price
+	}
+	return math.Round(price*math.Pow(1-rate, years)*100) / 100
+}
+
+func optString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func optDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+func optMoney(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', 2, 64)
+}
+
+// ---------- CSV ----------
+
+type inventoryCSV struct {
+	w *csv.Writer
+}
+
+func NewInventoryCSV(w io.Writer) (InventoryWriter, error) {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{
+		"item_id", "name", "location", "quantity", "serial_number", "vendor", "purchase_date",
+		"purchase_price", "currency", "purchase_value", "estimated_value", "warranty_expires", "photo_url",
+	})
+	return &inventoryCSV{w: cw}, err
+}
+
+func (c *inventoryCSV) WriteRow(r InventoryRow) error {
+	var purchaseValue *float64
+	if r.PurchasePrice != nil {
+		v := *r.PurchasePrice * float64(r.Quantity)
+		purchaseValue = &v
+	}
+
+	err := c.w.Write([]string{
+		strconv.FormatInt(r.ItemID, 10),
+		r.Name,
+		r.Breadcrumb,
+		strconv.Itoa(r.Quantity),
+		optString(r.SerialNumber),
+		optString(r.Vendor),
+		optDate(r.PurchaseDate),
+		optMoney(r.PurchasePrice),
+		optString(r.Currency),
+		optMoney(purchaseValue),
+		optMoney(r.EstimatedValue),
+		optDate(r.WarrantyExpires),
+		r.PhotoURL,
+	})
+	if err != nil {
+		return err
+	}
+	// flush per row so the response actually streams
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *inventoryCSV) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ---------- PDF ----------
+
+const (
+	pdfMargin    = 40.0
+	pdfRowHeight = 72.0
+	pdfThumbSize = 60.0
+)
+
+type inventoryPDF struct {
+	pdf      *PDF
+	title    string
+	subtitle string
+	page     *Page
+	pageNo   int
+	y        float64
+	items    int
+	totals   map[string]float64 // estimated value per currency
+}
+
+// NewInventoryPDF starts a PDF report; title/subtitle head every page.
+func NewInventoryPDF(w io.Writer, title, subtitle string) InventoryWriter {
+	return &inventoryPDF{pdf: NewPDF(w), title: title, subtitle: subtitle, totals: map[string]float64{}}
+}
+
+func (p *inventoryPDF) startPage() error {
+	if p.page != nil {
+		if err := p.finishPage(); err != nil {
+			return err
+		}
+	}
+	p.page = p.pdf.NewPage()
+	p.pageNo++
+
+	top := PageHeight - pdfMargin
+	p.page.Text(pdfMargin, top-14, 16, true, p.title)
+	p.page.Text(pdfMargin, top-30, 9, false, p.subtitle)
+	p.page.Line(pdfMargin, top-38, PageWidth-pdfMargin, top-38)
+	p.y = top - 44
+	return nil
+}
+
+func (p *inventoryPDF) finishPage() error {
+	p.page.Text(PageWidth-pdfMargin-40, pdfMargin/2, 8, false, fmt.Sprintf("Page %d", p.pageNo))
+	return p.pdf.FinishPage(p.page)
+}
+
+func (p *inventoryPDF) WriteRow(r InventoryRow) error {
+	if p.page == nil || p.y-pdfRowHeight < pdfMargin {
+		if err := p.startPage(); err != nil {
+			return err
+		}
+	}
+
+	top := p.y
+	textX := pdfMargin + pdfThumbSize + 12
+	textW := PageWidth - pdfMargin - textX
+
+	if len(r.Thumbnail) > 0 && r.ThumbnailWidth > 0 && r.ThumbnailHeight > 0 {
+		img := p.pdf.AddJPEG(r.Thumbnail, r.ThumbnailWidth, r.ThumbnailHeight)
+		// fit inside the square box keeping the aspect ratio
+		w, h := pdfThumbSize, pdfThumbSize
+		if r.ThumbnailWidth > r.ThumbnailHeight {
+			h = pdfThumbSize * float64(r.ThumbnailHeight) / float64(r.ThumbnailWidth)
+		} else {
+			w = pdfThumbSize * float64(r.ThumbnailWidth) / float64(r.ThumbnailHeight)
+		}
+		p.page.Image(img, pdfMargin, top-6-h, w, h)
+	} else {
+		p.page.Text(pdfMargin+12, top-38, 8, false, "no photo")
+	}
+
+	name := r.Name
+	if r.Quantity != 1 {
+		name = fmt.Sprintf("%s  x%d", r.Name, r.Quantity)
+	}
+	p.page.Text(textX, top-16, 11, true, Truncate(name, 11, textW))
+	p.page.Text(textX, top-30, 9, false, Truncate("Location: "+r.Breadcrumb, 9, textW))
+
+	details := []string{}
+	if r.SerialNumber != nil {
+		details = append(details, "S/N "+*r.SerialNumber)
+	}
+	if r.Vendor != nil {
+		details = append(details, "Vendor: "+*r.Vendor)
+	}
+	if r.WarrantyExpires != nil {
+		details = append(details, "Warranty until "+optDate(r.WarrantyExpires))
+	}
+	p.page.Text(textX, top-43, 9, false, Truncate(strings.Join(details, "   "), 9, textW))
+
+	cur := optString(r.Currency)
+	value := []string{}
+	if r.PurchaseDate != nil {
+		value = append(value, "Bought "+optDate(r.PurchaseDate))
+	}
+	if r.PurchasePrice != nil {
+		value = append(value, fmt.Sprintf("Price %s %s/unit", optMoney(r.PurchasePrice), cur))
+	}
+	if r.EstimatedValue != nil {
+		value = append(value, fmt.Sprintf("Estimated value %s %s", optMoney(r.EstimatedValue), cur))
+		p.totals[cur] += *r.EstimatedValue
+	}
+	p.page.Text(textX, top-56, 9, false, Truncate(strings.Join(value, "   "), 9, textW))
+
+	p.page.Line(pdfMargin, top-pdfRowHeight+4, PageWidth-pdfMargin, top-pdfRowHeight+4)
+	p.y -= pdfRowHeight
+	p.items++
+	return nil
+}
+
+func (p *inventoryPDF) Close() error {
+	if p.page == nil || p.y-40-14*float64(len(p.totals)) < pdfMargin {
+		if err := p.startPage(); err != nil {
+			return err
+		}
+	}
+
+	p.page.Text(pdfMargin, p.y-20, 11, true, fmt.Sprintf("%d item(s). Estimated total value:", p.items))
+	currencies := make([]string, 0, len(p.totals))
+	for cur := range p.totals {
+		currencies = append(currencies, cur)
+	}
+	sort.Strings(currencies)
+	y := p.y - 36
+	for _, cur := range currencies {
+		p.page.Text(pdfMargin+12, y, 10, false, fmt.Sprintf("%s %s", strconv.FormatFloat(p.totals[cur], 'f', 2, 64), cur))
+		y -= 14
+	}
+
+	if err := p.finishPage(); err != nil {
+		return err
+	}
+	return p.pdf.Close()
+}
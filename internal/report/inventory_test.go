@@ -0,0 +1,141 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDepreciate(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	yearsAgo := func(y float64) *time.Time {
+		t := now.Add(-time.Duration(y * 365.25 * 24 * float64(time.Hour)))
+		return &t
+	}
+
+	tests := []struct {
+		name      string
+		price     float64
+		purchased *time.Time
+		rate      float64
+		want      float64
+	}{
+		{"no purchase date", 1000, nil, 0.2, 1000},
+		{"no depreciation", 1000, yearsAgo(3), 0, 1000},
+		{"bought today", 1000, yearsAgo(0), 0.2, 1000},
+		{"bought in the future", 1000, yearsAgo(-1), 0.2, 1000},
+		{"one year", 1000, yearsAgo(1), 0.2, 800},
+		{"two years compound", 1000, yearsAgo(2), 0.2, 640},
+		{"half a year", 1000, yearsAgo(0.5), 0.2, 894.43},
+		{"rounded to cents", 9.99, yearsAgo(1), 0.3, 6.99},
+		{"ten years at 50%", 1024, yearsAgo(10), 0.5, 1},
+	}
+	for _, tt := range tests {
+		if got := Depreciate(tt.price, tt.purchased, tt.rate, now); got != tt.want {
+			t.Errorf("%s: Depreciate(%v) = %v, want %v", tt.name, tt.price, got, tt.want)
+		}
+	}
+}
+
+func strPtr(s string) *string     { return &s }
+func floatPtr(f float64) *float64 { return &f }
+func datePtr(s string) *time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return &t
+}
+
+func sampleRows() []InventoryRow {
+	return []InventoryRow{
+		{
+			ItemID: 7, Name: `Drill, "cordless"`, Breadcrumb: "Garage > Shelf A1", Quantity: 2,
+			SerialNumber: strPtr("SN-1"), Vendor: strPtr("Dedeman"), PurchaseDate: datePtr("2024-03-15"),
+			PurchasePrice: floatPtr(349.5), Currency: strPtr("RON"), WarrantyExpires: datePtr("2026-03-15"),
+			EstimatedValue: floatPtr(600), PhotoURL: "https://example.org/garage/attachments/3",
+		},
+		{ItemID: 8, Name: "Rope", Breadcrumb: "Shed", Quantity: 1},
+	}
+}
+
+func TestInventoryCSV(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := NewInventoryCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range sampleRows() {
+		if err := out.WriteRow(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+	want := [][]string{
+		{"item_id", "name", "location", "quantity", "serial_number", "vendor", "purchase_date",
+			"purchase_price", "currency", "purchase_value", "estimated_value", "warranty_expires", "photo_url"},
+		{"7", `Drill, "cordless"`, "Garage > Shelf A1", "2", "SN-1", "Dedeman", "2024-03-15",
+			"349.50", "RON", "699.00", "600.00", "2026-03-15", "https://example.org/garage/attachments/3"},
+		{"8", "Rope", "Shed", "1", "", "", "", "", "", "", "", "", ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(records), len(want))
+	}
+	for i := range want {
+		if strings.Join(records[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("record %d:\n got %q\nwant %q", i, records[i], want[i])
+		}
+	}
+}
+
+func TestInventoryPDF(t *testing.T) {
+	var buf bytes.Buffer
+	out := NewInventoryPDF(&buf, "Home inventory", "All spaces")
+
+	rows := sampleRows()
+	rows[0].Thumbnail, rows[0].ThumbnailWidth, rows[0].ThumbnailHeight = testJPEG(t, 4, 2), 4, 2
+	// enough rows for several pages
+	for i := 0; i < 30; i++ {
+		rows = append(rows, InventoryRow{
+			ItemID: int64(100 + i), Name: "Șurubelniță (set)", Breadcrumb: "Shed", Quantity: 1,
+			PurchasePrice: floatPtr(10), Currency: strPtr("EUR"), EstimatedValue: floatPtr(10),
+		})
+	}
+	for _, r := range rows {
+		if err := out.WriteRow(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := checkPDF(t, buf.Bytes())
+	if doc.pages < 2 {
+		t.Errorf("%d rows fit on %d page(s)", len(rows), doc.pages)
+	}
+	if doc.images != 1 {
+		t.Errorf("%d images embedded, want 1", doc.images)
+	}
+	for _, s := range []string{
+		`(Drill, "cordless"  x2) Tj`,
+		`(Surubelnita \(set\)) Tj`,
+		`(Location: Garage > Shelf A1) Tj`,
+		`(Bought 2024-03-15   Price 349.50 RON/unit   Estimated value 600.00 RON) Tj`,
+		`(32 item\(s\). Estimated total value:) Tj`,
+		`(300.00 EUR) Tj`,
+		`(600.00 RON) Tj`,
+		`(Page 1) Tj`,
+		`/Im`,
+	} {
+		if !strings.Contains(doc.text, s) {
+			t.Errorf("page content lacks %q", s)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package report
+
+// Minimal streaming PDF 1.4 writer: Helvetica text, lines and JPEG images.
+// Objects are flushed to the underlying writer as soon as they are complete,
+// so a report with thousands of rows never sits in memory as a whole. Only
+// the page tree, catalog and xref table are written at the end.
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A4 portrait, in points
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+// fixed object numbers, written last in Close
+const (
+	objCatalog  = 1
+	objPages    = 2
+	objFont     = 3
+	objFontBold = 4
+	firstFreeID = 5
+)
+
+type PDF struct {
+	w       io.Writer
+	written int64
+	offsets map[int]int64
+	nextID  int
+	pages   []int
+	err     error
+}
+
+func NewPDF(w io.Writer) *PDF {
+	p := &PDF{w: w, offsets: map[int]int64{}, nextID: firstFreeID}
+	// binary comment marks the file as binary for transfer tools
+	p.write([]byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"))
+	return p
+}
+
+func (p *PDF) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	p.err = err
+}
+
+func (p *PDF) allocID() int {
+	id := p.nextID
+	p.nextID++
+	return id
+}
+
+func (p *PDF) writeObject(id int, dict string, stream []byte) {
+	p.offsets[id] = p.written
+	p.write([]byte(fmt.Sprintf("%d 0 obj\n%s\n", id, dict)))
+	if stream != nil {
+		p.write([]byte("stream\n"))
+		p.write(stream)
+		p.write([]byte("\nendstream\n"))
+	}
+	p.write([]byte("endobj\n"))
+}
+
+// Image is a JPEG already written to the file, ready to be drawn on pages.
+type Image struct {
+	id            int
+	Width, Height int
+}
+
+// AddJPEG embeds a baseline RGB JPEG as an image XObject.
+func (p *PDF) AddJPEG(data []byte, width, height int) *Image {
+	img := &Image{id: p.allocID(), Width: width, Height: height}
+	dict := fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB "+
+		"/BitsPerComponent 8 /Filter /DCTDecode /Length %d >>", width, height, len(data))
+	p.writeObject(img.id, dict, data)
+	return img
+}
+
+// Page collects drawing operators until it is handed to FinishPage.
+type Page struct {
+	content bytes.Buffer
+	images  map[int]bool
+}
+
+func (p *PDF) NewPage() *Page {
+	return &Page{images: map[int]bool{}}
+}
+
+// Text draws s with its baseline at (x, y), measured from the bottom-left corner.
+func (pg *Page) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&pg.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escapePDFString(s))
+}
+
+func (pg *Page) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&pg.content, "0.8 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", x1, y1, x2, y2)
+}
+
+// Image draws img scaled into the w x h box with its bottom-left corner at (x, y).
+func (pg *Page) Image(img *Image, x, y, w, h float64) {
+	pg.images[img.id] = true
+	fmt.Fprintf(&pg.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, x, y, img.id)
+}
+
+// FinishPage writes the page's content stream and page object.
+func (p *PDF) FinishPage(pg *Page) error {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(pg.content.Bytes())
+	zw.Close()
+
+	contentID := p.allocID()
+	p.writeObject(contentID, fmt.Sprintf("<< /Filter /FlateDecode /Length %d >>", z.Len()), z.Bytes())
+
+	var xobjects strings.Builder
+	ids := make([]int, 0, len(pg.images))
+	for id := range pg.images {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Fprintf(&xobjects, " /Im%d %d 0 R", id, id)
+	}
+
+	pageID := p.allocID()
+	p.writeObject(pageID, fmt.Sprintf(
+		"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> /XObject <<%s >> >> /Contents %d 0 R >>",
+		objPages, PageWidth, PageHeight, objFont, objFontBold, xobjects.String(), contentID), nil)
+	p.pages = append(p.pages, pageID)
+
+	return p.err
+}
+
+// Close writes the page tree, fonts, catalog and cross-reference table.
+func (p *PDF) Close() error {
+	var kids strings.Builder
+	for _, id := range p.pages {
+		fmt.Fprintf(&kids, "%d 0 R ", id)
+	}
+	p.writeObject(objPages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), len(p.pages)), nil)
+	p.writeObject(objFont, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>", nil)
+	p.writeObject(objFontBold, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>", nil)
+	p.writeObject(objCatalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", objPages), nil)
+
+	xref := p.written
+	var b strings.Builder
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", p.nextID)
+	for id := 1; id < p.nextID; id++ {
+		fmt.Fprintf(&b, "%010d 00000 n \n", p.offsets[id])
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", p.nextID, objCatalog, xref)
+	p.write([]byte(b.String()))
+
+	return p.err
+}
+
+// winAnsiExtra maps the non-Latin-1 characters we are likely to meet (Romanian
+// diacritics, typographic punctuation) into WinAnsiEncoding or plain ASCII.
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '–': 0x96, '—': 0x97,
+	'ă': 'a', 'Ă': 'A', 'ș': 's', 'Ș': 'S', 'ş': 's', 'Ş': 'S', 'ț': 't', 'Ț': 'T', 'ţ': 't', 'Ţ': 'T',
+}
+
+func escapePDFString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		var c byte
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			c = byte(r)
+		case r == '\n' || r == '\r' || r == '\t':
+			c = ' '
+		case r < 0x80 || (r >= 0xA0 && r <= 0xFF):
+			c = byte(r)
+		default:
+			var ok bool
+			if c, ok = winAnsiExtra[r]; !ok {
+				c = '?'
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Truncate shortens s so it fits roughly maxWidth points at the given font
+// size, using Helvetica's average glyph width.
+func Truncate(s string, size, maxWidth float64) string {
+	maxChars := int(maxWidth / (size * 0.5))
+	r := []rune(s)
+	if len(r) <= maxChars {
+		return s
+	}
+	if maxChars < 2 {
+		return ""
+	}
+	return string(r[:maxChars-1]) + "…"
+}
@@ -0,0 +1,134 @@
+package report
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type pdfDoc struct {
+	pages, images int
+	text          string // every page's content stream, decompressed
+}
+
+// checkPDF checks the file structure a reader relies on: header, an xref
+// entry pointing at every object, a trailer and a page tree whose /Count
+// matches the page objects.
+func checkPDF(t *testing.T, data []byte) pdfDoc {
+	t.Helper()
+	var doc pdfDoc
+
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) {
+		t.Fatalf("no PDF header: %q", data[:min(len(data), 16)])
+	}
+	if !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("no end-of-file marker")
+	}
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+	var size int
+	fmt.Sscanf(string(data[xref:]), "xref\n0 %d\n", &size)
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(data[xref:], -1)
+	if len(entries) != size-1 {
+		t.Fatalf("xref has %d entries, header says %d", len(entries), size-1)
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[off:], []byte(want)) {
+			t.Errorf("xref entry for object %d points at %q", i+1, data[off:min(len(data), off+12)])
+		}
+	}
+	if !bytes.Contains(data, []byte(fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R >>", size))) {
+		t.Error("trailer doesn't match the xref table")
+	}
+
+	doc.pages = bytes.Count(data, []byte("<< /Type /Page /Parent"))
+	if !bytes.Contains(data, []byte(fmt.Sprintf("/Count %d >>", doc.pages))) {
+		t.Errorf("page tree doesn't count the %d page objects", doc.pages)
+	}
+	doc.images = bytes.Count(data, []byte("/Subtype /Image"))
+
+	var text strings.Builder
+	streams := regexp.MustCompile(`<< /Filter /FlateDecode /Length (\d+) >>\nstream\n`)
+	for _, loc := range streams.FindAllSubmatchIndex(data, -1) {
+		n, _ := strconv.Atoi(string(data[loc[2]:loc[3]]))
+		zr, err := zlib.NewReader(bytes.NewReader(data[loc[1] : loc[1]+n]))
+		if err != nil {
+			t.Fatalf("content stream: %v", err)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("content stream: %v", err)
+		}
+		text.Write(b)
+	}
+	doc.text = text.String()
+	return doc
+}
+
+func TestPDFStructure(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewPDF(&buf)
+	for i := 1; i <= 3; i++ {
+		pg := p.NewPage()
+		pg.Text(40, 800, 12, i == 1, fmt.Sprintf("page %d", i))
+		pg.Line(40, 790, 555, 790)
+		if err := p.FinishPage(pg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := checkPDF(t, buf.Bytes())
+	if doc.pages != 3 {
+		t.Errorf("%d pages, want 3", doc.pages)
+	}
+	for _, s := range []string{"/F2 12.0 Tf 40.00 800.00 Td (page 1) Tj", "/F1 12.0 Tf 40.00 800.00 Td (page 3) Tj"} {
+		if !strings.Contains(doc.text, s) {
+			t.Errorf("content lacks %q", s)
+		}
+	}
+}
+
+func TestEscapePDFString(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", "plain"},
+		{`a (b) \c`, `a \(b\) \\c`},
+		{"two\nlines\ttab", "two lines tab"},
+		{"café", "caf\xe9"},
+		{"ăîșț — 5€", "a\xees\x74 \x97 5\x80"},
+		{"日本", "??"},
+	}
+	for _, tt := range tests {
+		if got := escapePDFString(tt.in); got != tt.want {
+			t.Errorf("escapePDFString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	// 10pt Helvetica: 5pt a character on average
+	if got := Truncate("short", 10, 100); got != "short" {
+		t.Errorf("got %q", got)
+	}
+	if got := Truncate("abcdefghijklmnopqrstuvwxyz", 10, 50); got != "abcdefghi…" {
+		t.Errorf("got %q", got)
+	}
+	if got := Truncate("abc", 10, 5); got != "" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,95 @@
+package report
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif" // register decoders for image.Decode
+	"image/jpeg"
+	_ "image/png"
+)
+
+// maxThumbnailSourcePixels caps the images Thumbnail decodes: a few KB of
+// PNG can claim gigapixels, and decoding allocates all of them.
+const maxThumbnailSourcePixels = 40_000_000
+
+var ErrImageTooLarge = errors.New("image too large to thumbnail")
+
+// Thumbnail decodes a JPEG/PNG/GIF and returns it re-encoded as a JPEG no
+// larger than max x max pixels. Each output pixel averages the block of
+// source pixels it covers, which is plenty for report-sized previews.
+// Images over maxThumbnailSourcePixels give ErrImageTooLarge.
+func Thumbnail(data []byte, max int) (out []byte, width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxThumbnailSourcePixels {
+		return nil, 0, 0, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 {
+		return nil, 0, 0, image.ErrFormat
+	}
+
+	width, height = sw, sh
+	if sw > max || sh > max {
+		if sw >= sh {
+			width, height = max, sh*max/sw
+		} else {
+			width, height = sw*max/sh, max
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*sh/height
+		y1 := b.Min.Y + (y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*sw/width
+			x1 := b.Min.X + (x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			// transparent pixels are flattened onto white
+			var r, g, bl, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					bl += uint64(cb + 0xffff - ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), width, height, nil
+}
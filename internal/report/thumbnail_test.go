@@ -0,0 +1,69 @@
+package report
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		w, h, wantW, wantH int
+	}{
+		{400, 200, 160, 80},
+		{200, 400, 80, 160},
+		{1000, 3, 160, 1},
+		{10, 10, 10, 10}, // never scaled up
+	}
+	for _, tt := range tests {
+		src := image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h))
+		for i := range src.Pix {
+			src.Pix[i] = 0 // transparent
+		}
+		var in bytes.Buffer
+		png.Encode(&in, src)
+
+		out, w, h, err := Thumbnail(in.Bytes(), 160)
+		if err != nil {
+			t.Fatalf("%dx%d: %v", tt.w, tt.h, err)
+		}
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("%dx%d: thumbnail %dx%d, want %dx%d", tt.w, tt.h, w, h, tt.wantW, tt.wantH)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%dx%d: output is not a JPEG: %v", tt.w, tt.h, err)
+		}
+		if b := img.Bounds(); b.Dx() != w || b.Dy() != h {
+			t.Errorf("%dx%d: JPEG is %v, reported %dx%d", tt.w, tt.h, b, w, h)
+		}
+		// transparency is flattened onto white
+		if r, g, b, _ := img.At(0, 0).RGBA(); r < 0xf000 || g < 0xf000 || b < 0xf000 {
+			t.Errorf("%dx%d: transparent pixel became %v", tt.w, tt.h, color.RGBA64{uint16(r), uint16(g), uint16(b), 0xffff})
+		}
+	}
+}
+
+func TestThumbnailRejects(t *testing.T) {
+	// a GIF header claiming 65535x65535 pixels: tiny file, gigantic decode
+	huge := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00")
+	if _, _, _, err := Thumbnail(huge, 160); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("huge image: err = %v, want ErrImageTooLarge", err)
+	}
+	if _, _, _, err := Thumbnail([]byte("not an image"), 160); err == nil {
+		t.Error("garbage decoded")
+	}
+}
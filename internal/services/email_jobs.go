@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/BlaccStacc/blaccend/internal/mail"
+)
+
+// Every email goes out as a job, so a mail server that is down delays mail
+// instead of failing whatever wanted to send it. Payloads carry everything
+// the message needs, including the recipient's locale.
+
+type verificationEmail struct {
+	To     string `json:"to"`
+	Locale string `json:"locale,omitempty"`
+	Token  string `json:"token"`
+}
+
+type passwordResetEmail struct {
+	To     string `json:"to"`
+	Locale string `json:"locale,omitempty"`
+	Token  string `json:"token"`
+}
+
+type loginAlertEmail struct {
+	To     string            `json:"to"`
+	Locale string            `json:"locale,omitempty"`
+	Alerts []mail.LoginAlert `json:"alerts"`
+}
+
+type lowStockEmail struct {
+	To     string              `json:"to"`
+	Locale string              `json:"locale,omitempty"`
+	Lines  []mail.LowStockLine `json:"lines"`
+}
+
+type overdueLoanEmail struct {
+	To     string             `json:"to"`
+	Locale string             `json:"locale,omitempty"`
+	Loans  []mail.OverdueLoan `json:"loans"`
+	Loan   *mail.OverdueLoan  `json:"loan,omitempty"` // queued before reminders were batched
+}
+
+type maintenanceEmail struct {
+	To     string                `json:"to"`
+	Locale string                `json:"locale,omitempty"`
+	Due    []mail.MaintenanceDue `json:"due"`
+}
+
+type warrantyEmail struct {
+	To     string                `json:"to"`
+	Locale string                `json:"locale,omitempty"`
+	Items  []mail.WarrantyExpiry `json:"items"`
+}
+
+// QueueVerificationEmail queues the address verification mail for a new
+// account; ex is the transaction that creates it.
+func QueueVerificationEmail(ex Execer, to mail.Recipient, token string) error {
+	return Enqueue(ex, "email.verification", verificationEmail{To: to.Email, Locale: to.Locale, Token: token})
+}
+
+// QueuePasswordResetEmail queues a password reset link; ex is the
+// transaction that stores the token.
+func QueuePasswordResetEmail(ex Execer, to mail.Recipient, token string) error {
+	return Enqueue(ex, "email.password_reset", passwordResetEmail{To: to.Email, Locale: to.Locale, Token: token})
+}
+
+// queueNotificationEmail queues one mail with all of a user's pending
+// notifications for event; each payload is one line of it.
+func queueNotificationEmail(ex Execer, to mail.Recipient, event string, payloads []json.RawMessage) error {
+	switch event {
+	case EventLowStock:
+		lines, err := decodePayloads[mail.LowStockLine](payloads)
+		if err != nil {
+			return err
+		}
+		return Enqueue(ex, "email.low_stock", lowStockEmail{To: to.Email, Locale: to.Locale, Lines: lines})
+	case EventLoans:
+		loans, err := decodePayloads[mail.OverdueLoan](payloads)
+		if err != nil {
+			return err
+		}
+		return Enqueue(ex, "email.overdue_loan", overdueLoanEmail{To: to.Email, Locale: to.Locale, Loans: loans})
+	case EventMaintenance:
+		due, err := decodePayloads[mail.MaintenanceDue](payloads)
+		if err != nil {
+			return err
+		}
+		return Enqueue(ex, "email.maintenance", maintenanceEmail{To: to.Email, Locale: to.Locale, Due: due})
+	case EventSecurity:
+		alerts, err := decodePayloads[mail.LoginAlert](payloads)
+		if err != nil {
+			return err
+		}
+		return Enqueue(ex, "email.login_alert", loginAlertEmail{To: to.Email, Locale: to.Locale, Alerts: alerts})
+	case EventWarranty:
+		items, err := decodePayloads[mail.WarrantyExpiry](payloads)
+		if err != nil {
+			return err
+		}
+		return Enqueue(ex, "email.warranty", warrantyEmail{To: to.Email, Locale: to.Locale, Items: items})
+	}
+	return fmt.Errorf("no mail for %q notifications", event)
+}
+
+func decodePayloads[T any](payloads []json.RawMessage) ([]T, error) {
+	out := make([]T, len(payloads))
+	for i, p := range payloads {
+		if err := json.Unmarshal(p, &out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func registerEmailJobs(r *Runner, mailer *mail.Mailer) {
+	r.Handle("email.verification", func(_ context.Context, job Job) error {
+		var p verificationEmail
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return err
+		}
+		return mailer.SendVerificationEmail(mail.Recipient{Email: p.To, Locale: p.Locale}, p.Token)
+	})
+	r.Handle("email.password_reset", func(_ context.Context, job Job) error {
+		var p passwordResetEmail
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return err
+		}
+		return mailer.SendPasswordResetEmail(mail.Recipient{Email: p.To, Locale: p.Locale}, p.Token)
+	})
+	r.Handle("email.login_alert", func(_ context.Context, job Job) error {
+		var p loginAlertEmail
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return err
+		}
+		return mailer.SendLoginAlert(mail.Recipient{Email: p.To, Locale: p.Locale}, p.Alerts)
+	})
+	r.Handle("email.low_stock", func(_ context.Context, job Job) error {
+		var p lowStockEmail
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return err
+		}
+		return mailer.SendLowStockDigest(mail.Recipient{Email: p.To, Locale: p.Locale}, p.Lines)
+	})
+	r.Handle("email.overdue_loan", func(_ context.Context, job Job) error {
+		var p overdueLoanEmail
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return err
+		}
+		if p.Loan != nil {
+			p.Loans = append(p.Loans, *p.Loan)
+		}
+		return mailer.SendOverdueLoanReminder(mail.Recipient{Email: p.To, Locale: p.Locale}, p.Loans)
+	})
+	r.Handle("email.maintenance", func(_ context.Context, job Job) error {
+		var p maintenanceEmail
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return err
+		}
+		return mailer.SendMaintenanceReminder(mail.Recipient{Email: p.To, Locale: p.Locale}, p.Due)
+	})
+	r.Handle("email.warranty", func(_ context.Context, job Job) error {
+		var p warrantyEmail
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return err
+		}
+		return mailer.SendWarrantyReminder(mail.Recipient{Email: p.To, Locale: p.Locale}, p.Items)
+	})
+}
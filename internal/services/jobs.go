@@ -0,0 +1,301 @@
+package services
+
+// Durable background jobs. A job is a row in the jobs table, inserted with
+// Enqueue inside the transaction that needs it, so it exists exactly when
+// that transaction commits (a transactional outbox). Workers claim due jobs
+// with FOR UPDATE SKIP LOCKED, so any number of them can run across API
+// replicas. A failing job is retried with exponential backoff and, after
+// maxJobAttempts, left "dead" for an owner to look at and retry.
+//
+// Recurring jobs (Runner.Every, Runner.Daily) are driven by job_schedules:
+// whichever replica gets there first enqueues the run, so each one happens
+// once however many replicas are up.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	maxJobAttempts = 10
+	firstJobRetry  = 30 * time.Second
+	maxJobRetry    = 6 * time.Hour
+
+	// a claimed job is retried after this if its worker dies; also the
+	// longest a job may run
+	jobLease = 5 * time.Minute
+
+	jobPollInterval     = 2 * time.Second
+	jobScheduleInterval = 30 * time.Second
+	jobRetentionDays    = 7 // finished jobs are pruned after this
+)
+
+// Execer is satisfied by *sql.DB and *sql.Tx. Pass the transaction that makes
+// the change so no job runs for changes that roll back.
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Job is a claimed job as its handler sees it.
+type Job struct {
+	ID       int64
+	Kind     string
+	Payload  json.RawMessage
+	Attempts int // including this one
+}
+
+// JobFunc runs one job. An error means try again later, so handlers must be
+// safe to run more than once.
+type JobFunc func(ctx context.Context, job Job) error
+
+// Enqueue queues a kind job to run as soon as a worker is free. payload is
+// anything that marshals to JSON.
+func Enqueue(ex Execer, kind string, payload any) error {
+	return EnqueueAt(ex, kind, payload, time.Now())
+}
+
+// EnqueueAt is Enqueue for a job that must not run before runAt.
+func EnqueueAt(ex Execer, kind string, payload any, runAt time.Time) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec(`INSERT INTO jobs (kind, payload, run_at) VALUES ($1, $2, $3)`, kind, string(body), runAt)
+	return err
+}
+
+// jobBackoff is the wait before the next try after attempts failed ones.
+func jobBackoff(attempts int) time.Duration {
+	d := firstJobRetry
+	for i := 1; i < attempts && d < maxJobRetry; i++ {
+		d *= 2
+	}
+	if d > maxJobRetry {
+		d = maxJobRetry
+	}
+	return d
+}
+
+type jobSchedule struct {
+	kind string
+	next func(now time.Time) time.Time
+}
+
+// Runner runs the jobs it has handlers for and enqueues its recurring ones.
+type Runner struct {
+	db        *sql.DB
+	handlers  map[string]JobFunc
+	schedules []jobSchedule
+}
+
+func NewRunner(db *sql.DB) *Runner {
+	r := &Runner{db: db, handlers: map[string]JobFunc{}}
+	r.Handle("jobs.prune", func(ctx context.Context, _ Job) error {
+		return pruneJobs(ctx, db)
+	})
+	r.Daily("jobs.prune", 3)
+	return r
+}
+
+// Handle registers fn for kind. Call it before Run.
+func (r *Runner) Handle(kind string, fn JobFunc) {
+	r.handlers[kind] = fn
+}
+
+// Every enqueues an empty kind job every interval.
+func (r *Runner) Every(kind string, interval time.Duration) {
+	r.schedules = append(r.schedules, jobSchedule{kind, func(now time.Time) time.Time {
+		return now.Add(interval)
+	}})
+}
+
+// Daily enqueues an empty kind job every day at hour:00 UTC.
+func (r *Runner) Daily(kind string, hour int) {
+	r.schedules = append(r.schedules, jobSchedule{kind, func(now time.Time) time.Time {
+		return nextDailyRun(now, hour)
+	}})
+}
+
+// Run starts workers and the scheduler and blocks until ctx is cancelled.
+func (r *Runner) Run(ctx context.Context, workers int) {
+	if err := r.initSchedules(ctx); err != nil {
+		log.Printf("job schedules: %v", err)
+	}
+	for i := 0; i < workers; i++ {
+		go r.work(ctx)
+	}
+	runEvery(ctx, "job schedules", jobScheduleInterval, r.enqueueScheduled)
+}
+
+// initSchedules registers new schedules and pulls existing ones forward when
+// their slot moved earlier (e.g. DIGEST_HOUR changed).
+func (r *Runner) initSchedules(ctx context.Context) error {
+	now := time.Now().UTC()
+	for _, s := range r.schedules {
+		_, err := r.db.ExecContext(ctx, `
+			INSERT INTO job_schedules (kind, next_run_at) VALUES ($1, $2)
+			ON CONFLICT (kind) DO UPDATE SET next_run_at = LEAST(job_schedules.next_run_at, EXCLUDED.next_run_at)
+		`, s.kind, s.next(now))
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.kind, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) enqueueScheduled(ctx context.Context) error {
+	for _, s := range r.schedules {
+		if err := r.enqueueIfDue(ctx, s); err != nil {
+			return fmt.Errorf("schedule %s: %w", s.kind, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) enqueueIfDue(ctx context.Context, s jobSchedule) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// in case initSchedules couldn't reach the database
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO job_schedules (kind, next_run_at) VALUES ($1, $2) ON CONFLICT (kind) DO NOTHING
+	`, s.kind, s.next(now))
+	if err != nil {
+		return err
+	}
+
+	var next time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT next_run_at FROM job_schedules WHERE kind = $1 FOR UPDATE SKIP LOCKED
+	`, s.kind).Scan(&next)
+	if err == sql.ErrNoRows {
+		return nil // another replica is on it
+	}
+	if err != nil {
+		return err
+	}
+	if next.After(now) {
+		return nil
+	}
+
+	if err := Enqueue(tx, s.kind, struct{}{}); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE job_schedules SET next_run_at = $2, last_run_at = NOW() WHERE kind = $1
+	`, s.kind, s.next(now))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// work claims and runs jobs one at a time until ctx is cancelled.
+func (r *Runner) work(ctx context.Context) {
+	for {
+		job, ok, err := r.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("claim job: %v", err)
+		}
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+
+		runErr := r.run(ctx, job)
+		if err := r.finish(ctx, job, runErr); err != nil && ctx.Err() == nil {
+			log.Printf("finish job %d: %v", job.ID, err)
+		}
+	}
+}
+
+// claim takes the next due job and leases it by pushing run_at forward.
+func (r *Runner) claim(ctx context.Context) (Job, bool, error) {
+	var j Job
+	var payload string
+	err := r.db.QueryRowContext(ctx, `
+		UPDATE jobs
+		SET attempts = attempts + 1,
+		    run_at = NOW() + make_interval(secs => $1)
+		WHERE id = (
+		    SELECT id FROM jobs
+		    WHERE status = 'pending' AND run_at <= NOW()
+		    ORDER BY run_at, id
+		    LIMIT 1
+		    FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload::text, attempts
+	`, int(jobLease.Seconds())).Scan(&j.ID, &j.Kind, &payload, &j.Attempts)
+	if err == sql.ErrNoRows {
+		return j, false, nil
+	}
+	if err != nil {
+		return j, false, err
+	}
+	j.Payload = json.RawMessage(payload)
+	return j, true, nil
+}
+
+// run calls the job's handler, turning panics into errors.
+func (r *Runner) run(ctx context.Context, job Job) (err error) {
+	fn, ok := r.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, jobLease)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return fn(ctx, job)
+}
+
+func (r *Runner) finish(ctx context.Context, job Job, runErr error) error {
+	if runErr == nil {
+		_, err := r.db.ExecContext(ctx, `
+			UPDATE jobs SET status = 'done', finished_at = NOW(), last_error = NULL WHERE id = $1
+		`, job.ID)
+		return err
+	}
+
+	if job.Attempts >= maxJobAttempts {
+		log.Printf("job %d (%s) is dead after %d attempts: %v", job.ID, job.Kind, job.Attempts, runErr)
+		_, err := r.db.ExecContext(ctx, `
+			UPDATE jobs SET status = 'dead', finished_at = NOW(), last_error = $2 WHERE id = $1
+		`, job.ID, runErr.Error())
+		return err
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE jobs
+		SET last_error = $2,
+		    run_at = NOW() + make_interval(secs => $3)
+		WHERE id = $1
+	`, job.ID, runErr.Error(), int(jobBackoff(job.Attempts).Seconds()))
+	return err
+}
+
+// pruneJobs drops jobs that finished successfully more than jobRetentionDays
+// ago. Dead jobs stay until someone retries them.
+func pruneJobs(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		DELETE FROM jobs
+		WHERE status = 'done' AND finished_at < NOW() - make_interval(days => $1)
+	`, jobRetentionDays)
+	return err
+}
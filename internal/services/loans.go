@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/mail"
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+)
+
+// NotifyOverdueLoans notifies the borrower (when they have an account) and
+// the lender about every open loan past its due date. Each loan comes up at
+// most once a day; when neither side has an account the owners get it
+// instead.
+func NotifyOverdueLoans(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT l.id, i.name, l.quantity, COALESCE(b.username, l.borrower_contact, 'unknown'), l.due_at,
+		       b.id, lender.id
+		FROM garage_loans l
+		JOIN garage_items i ON i.id = l.item_id AND i.deleted_at IS NULL
+		LEFT JOIN users b ON b.id = l.borrower_user_id
+		LEFT JOIN users lender ON lender.id = l.lent_by
+		WHERE l.returned_at IS NULL
+		  AND l.due_at < NOW()
+		  AND (l.last_reminded_at IS NULL OR l.last_reminded_at < NOW() - INTERVAL '20 hours')
+		ORDER BY l.due_at
+	`)
+	if err != nil {
+		return fmt.Errorf("load overdue loans: %w", err)
+	}
+
+	type reminder struct {
+		loanID int64
+		loan   mail.OverdueLoan
+		to     []int64
+	}
+	var reminders []reminder
+	for rows.Next() {
+		var r reminder
+		var borrowerID, lenderID *int64
+		if err := rows.Scan(&r.loanID, &r.loan.ItemName, &r.loan.Quantity, &r.loan.Borrower, &r.loan.DueAt,
+			&borrowerID, &lenderID); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan overdue loan: %w", err)
+		}
+		r.loan.DaysOverdue = int(time.Since(r.loan.DueAt).Hours() / 24)
+		if borrowerID != nil {
+			r.to = append(r.to, *borrowerID)
+		}
+		if lenderID != nil && (borrowerID == nil || *lenderID != *borrowerID) {
+			r.to = append(r.to, *lenderID)
+		}
+		reminders = append(reminders, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load overdue loans: %w", err)
+	}
+
+	var owners []int64
+	for _, r := range reminders {
+		to := r.to
+		if len(to) == 0 {
+			if owners == nil {
+				if owners, err = ownerIDs(ctx, tx); err != nil {
+					return fmt.Errorf("load owners: %w", err)
+				}
+			}
+			to = owners
+		}
+		if len(to) == 0 {
+			continue
+		}
+
+		for _, userID := range to {
+			if err := Notify(tx, userID, EventLoans, fmt.Sprintf("loan:%d", r.loanID), r.loan); err != nil {
+				return fmt.Errorf("notify loan %d: %w", r.loanID, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE garage_loans SET last_reminded_at = NOW() WHERE id = $1`, r.loanID); err != nil {
+			return fmt.Errorf("mark loan %d reminded: %w", r.loanID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+type overdueLoanEvent struct {
+	LoanID          int64   `json:"loan_id"`
+	ItemID          int64   `json:"item_id"`
+	ItemName        string  `json:"item_name"`
+	Quantity        int     `json:"quantity"`
+	BorrowerUserID  *int64  `json:"borrower_user_id,omitempty"`
+	BorrowerContact *string `json:"borrower_contact,omitempty"`
+	DueAt           string  `json:"due_at"`
+}
+
+// QueueOverdueLoanEvents queues a loan.overdue webhook the first time an open
+// loan passes its due date.
+func QueueOverdueLoanEvents(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE garage_loans l
+		SET overdue_event_at = NOW()
+		FROM garage_items i
+		WHERE i.id = l.item_id AND i.deleted_at IS NULL
+		  AND l.returned_at IS NULL
+		  AND l.due_at < NOW()
+		  AND l.overdue_event_at IS NULL
+		RETURNING l.id, l.item_id, i.name, l.quantity, l.borrower_user_id, l.borrower_contact, l.due_at
+	`)
+	if err != nil {
+		return fmt.Errorf("mark overdue loans: %w", err)
+	}
+	var events []overdueLoanEvent
+	for rows.Next() {
+		var e overdueLoanEvent
+		var due time.Time
+		if err := rows.Scan(&e.LoanID, &e.ItemID, &e.ItemName, &e.Quantity, &e.BorrowerUserID,
+			&e.BorrowerContact, &due); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan overdue loan: %w", err)
+		}
+		e.DueAt = due.UTC().Format(time.RFC3339)
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("mark overdue loans: %w", err)
+	}
+
+	for _, e := range events {
+		if err := webhooks.Enqueue(tx, "loan.overdue", e); err != nil {
+			return fmt.Errorf("queue loan %d: %w", e.LoanID, err)
+		}
+	}
+	return tx.Commit()
+}
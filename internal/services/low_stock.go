@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/BlaccStacc/blaccend/internal/mail"
+)
+
+// NotifyLowStock notifies every owner of the open low-stock alerts they
+// haven't heard about yet, and marks them notified in the same transaction.
+func NotifyLowStock(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT a.id, i.name, s.name, a.quantity, a.min_quantity, i.reorder_quantity
+		FROM garage_alerts a
+		JOIN garage_items i ON i.id = a.item_id AND i.deleted_at IS NULL
+		JOIN garage_spaces s ON s.id = i.space_id
+		WHERE a.notified_at IS NULL AND a.acknowledged_at IS NULL
+		ORDER BY s.name, i.name
+	`)
+	if err != nil {
+		return fmt.Errorf("load pending alerts: %w", err)
+	}
+
+	var ids []int64
+	var lines []mail.LowStockLine
+	for rows.Next() {
+		var id int64
+		var l mail.LowStockLine
+		if err := rows.Scan(&id, &l.ItemName, &l.SpaceName, &l.Quantity, &l.MinQuantity, &l.ReorderQuantity); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan alert: %w", err)
+		}
+		ids = append(ids, id)
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load pending alerts: %w", err)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	owners, err := ownerIDs(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("load owners: %w", err)
+	}
+	// keep the alerts pending if there is nobody to tell
+	if len(owners) == 0 {
+		return nil
+	}
+
+	for _, owner := range owners {
+		for i, l := range lines {
+			if err := Notify(tx, owner, EventLowStock, fmt.Sprintf("alert:%d", ids[i]), l); err != nil {
+				return fmt.Errorf("notify low stock: %w", err)
+			}
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE garage_alerts SET notified_at = NOW() WHERE id = ANY($1)`, ids); err != nil {
+		return fmt.Errorf("mark alerts notified: %w", err)
+	}
+
+	return tx.Commit()
+}
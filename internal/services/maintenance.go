@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/mail"
+)
+
+// A plan counts as due soon this many days before its date, or once the hour
+// meter is within MaintenanceSoonFraction of the interval from the due
+// reading. The API's status and the reminders both go by these.
+const (
+	MaintenanceSoonDays     = 7
+	MaintenanceSoonFraction = 0.1
+)
+
+// MaintenanceSchedule is how often a maintenance plan recurs: every N days,
+// every N hours on the item's hour meter, or an RRULE anchored at StartsAt.
+type MaintenanceSchedule struct {
+	Kind          string // days | hours | rrule
+	IntervalDays  *int
+	IntervalHours *float64
+	RRule         *string
+	StartsAt      time.Time
+}
+
+func (s MaintenanceSchedule) Validate() error {
+	switch s.Kind {
+	case "days":
+		if s.IntervalDays == nil || *s.IntervalDays <= 0 {
+			return errors.New("interval_days must be positive")
+		}
+	case "hours":
+		if s.IntervalHours == nil || *s.IntervalHours <= 0 {
+			return errors.New("interval_hours must be positive")
+		}
+	case "rrule":
+		if s.RRule == nil {
+			return errors.New("rrule is required")
+		}
+		if _, err := ParseRRule(*s.RRule); err != nil {
+			return err
+		}
+	default:
+		return errors.New("schedule_kind must be days, hours or rrule")
+	}
+	return nil
+}
+
+// NextDue works out when the plan is due after its last completion (nil when
+// it was never done). Hour-based plans are due at a meter reading instead of
+// a date; currentHours seeds them when there is no completion yet. An RRULE
+// plan moves to the first occurrence after both the completion and the
+// occurrence it was due at (prevDueAt), so work done early doesn't leave it
+// due at the same date again. Both results are nil for an RRULE that has run
+// out of occurrences.
+func (s MaintenanceSchedule) NextDue(prevDueAt, lastDoneAt *time.Time, lastDoneHours *float64, currentHours float64) (*time.Time, *float64, error) {
+	switch s.Kind {
+	case "days":
+		base := s.StartsAt
+		if lastDoneAt != nil {
+			base = *lastDoneAt
+		}
+		due := base.AddDate(0, 0, *s.IntervalDays)
+		return &due, nil, nil
+
+	case "hours":
+		base := currentHours
+		if lastDoneHours != nil {
+			base = *lastDoneHours
+		}
+		due := base + *s.IntervalHours
+		return nil, &due, nil
+
+	case "rrule":
+		rule, err := ParseRRule(*s.RRule)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the anchor itself is the first occurrence
+		after := s.StartsAt.Add(-time.Second)
+		if lastDoneAt != nil && lastDoneAt.After(after) {
+			after = *lastDoneAt
+		}
+		if prevDueAt != nil && prevDueAt.After(after) {
+			after = *prevDueAt
+		}
+		due, ok := rule.Next(s.StartsAt, after)
+		if !ok {
+			return nil, nil, nil
+		}
+		return &due, nil, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown schedule kind %q", s.Kind)
+}
+
+// NotifyMaintenanceDue notifies the owners of plans that are overdue or
+// due soon (see MaintenanceSoonDays). A plan is repeated at most weekly until
+// someone logs the work.
+func NotifyMaintenanceDue(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT p.id, p.title, i.name, p.next_due_at, p.next_due_hours, i.usage_hours
+		FROM maintenance_plans p
+		JOIN garage_items i ON i.id = p.item_id AND i.deleted_at IS NULL
+		WHERE ((p.next_due_at IS NOT NULL AND p.next_due_at <= NOW() + make_interval(days => $1))
+		    OR (p.next_due_hours IS NOT NULL AND i.usage_hours >= p.next_due_hours - p.interval_hours * $2))
+		  AND (p.last_reminded_at IS NULL OR p.last_reminded_at < NOW() - INTERVAL '7 days')
+		ORDER BY p.next_due_at NULLS LAST, p.id
+	`, MaintenanceSoonDays, MaintenanceSoonFraction)
+	if err != nil {
+		return fmt.Errorf("load due maintenance: %w", err)
+	}
+
+	now := time.Now()
+	var ids []int64
+	var due []mail.MaintenanceDue
+	for rows.Next() {
+		var id int64
+		var d mail.MaintenanceDue
+		if err := rows.Scan(&id, &d.Title, &d.ItemName, &d.DueAt, &d.DueHours, &d.UsageHours); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan maintenance plan: %w", err)
+		}
+		d.Overdue = (d.DueAt != nil && d.DueAt.Before(now)) || (d.DueHours != nil && d.UsageHours >= *d.DueHours)
+		ids = append(ids, id)
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load due maintenance: %w", err)
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	owners, err := ownerIDs(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("load owners: %w", err)
+	}
+	// keep them for the next run if there is nobody to tell
+	if len(owners) == 0 {
+		return nil
+	}
+
+	for _, owner := range owners {
+		for i, d := range due {
+			if err := Notify(tx, owner, EventMaintenance, fmt.Sprintf("plan:%d", ids[i]), d); err != nil {
+				return fmt.Errorf("notify maintenance: %w", err)
+			}
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE maintenance_plans SET last_reminded_at = NOW() WHERE id = ANY($1)`, ids); err != nil {
+		return fmt.Errorf("mark plans reminded: %w", err)
+	}
+
+	return tx.Commit()
+}
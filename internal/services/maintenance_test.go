@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDueRRule(t *testing.T) {
+	rule := "FREQ=MONTHLY;BYMONTHDAY=1"
+	s := MaintenanceSchedule{Kind: "rrule", RRule: &rule, StartsAt: date(2025, 1, 1)}
+
+	tests := []struct {
+		name          string
+		prevDue, done *time.Time
+		want          time.Time
+	}{
+		{"new plan: the anchor", nil, nil, date(2025, 1, 1)},
+		{"done on the day", ptr(date(2025, 3, 1)), ptr(date(2025, 3, 1)), date(2025, 4, 1)},
+		{"done late", ptr(date(2025, 3, 1)), ptr(date(2025, 3, 20)), date(2025, 4, 1)},
+		{"done very late", ptr(date(2025, 3, 1)), ptr(date(2025, 5, 3)), date(2025, 6, 1)},
+		// early work counts for the occurrence it was due at, not again for it
+		{"done early", ptr(date(2025, 3, 1)), ptr(date(2025, 2, 25)), date(2025, 4, 1)},
+	}
+	for _, tt := range tests {
+		got, hours, err := s.NextDue(tt.prevDue, tt.done, nil, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got == nil || hours != nil || !got.Equal(tt.want) {
+			t.Errorf("%s: due %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func ptr(t time.Time) *time.Time { return &t }
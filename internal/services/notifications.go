@@ -0,0 +1,350 @@
+package services
+
+// Notifications. Producers (low stock, overdue loans, maintenance,
+// warranties, security) record one per user with Notify. Every user picks,
+// per event, how notifications reach them (email, webhook or not at all) and
+// how often (instant, or in a daily or weekly digest). Instant ones get a
+// notifications.deliver job right away; the daily notifications.digest job
+// queues one for every user and event whose digest is due. Delivery only
+// queues the mail or webhook, which retry on their own.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/mail"
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+)
+
+// Notification events users have preferences for.
+const (
+	EventLowStock    = "low_stock"
+	EventLoans       = "loans"
+	EventMaintenance = "maintenance"
+	EventWarranty    = "warranty"
+	EventSecurity    = "security" // can't be turned off
+)
+
+var NotificationEvents = []string{EventLowStock, EventLoans, EventMaintenance, EventWarranty, EventSecurity}
+
+const (
+	// weekly digests go out with the daily ones on this day
+	weeklyDigestDay = time.Monday
+
+	notificationRetentionDays = 30 // delivered notifications are pruned after this
+)
+
+// ExecQueryer is satisfied by *sql.DB and *sql.Tx.
+type ExecQueryer interface {
+	Execer
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// NotificationPreference is how one user wants one event delivered.
+type NotificationPreference struct {
+	Event   string
+	Channel string // email | webhook | none
+	Cadence string // instant | daily | weekly
+}
+
+// DefaultNotificationPreference applies until the user picks something else:
+// a daily email, and security notices by email straight away.
+func DefaultNotificationPreference(event string) NotificationPreference {
+	if event == EventSecurity {
+		return NotificationPreference{Event: event, Channel: "email", Cadence: "instant"}
+	}
+	return NotificationPreference{Event: event, Channel: "email", Cadence: "daily"}
+}
+
+func IsNotificationEvent(event string) bool {
+	for _, e := range NotificationEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (p NotificationPreference) Validate() error {
+	if !IsNotificationEvent(p.Event) {
+		return fmt.Errorf("unknown notification event %q", p.Event)
+	}
+	switch p.Channel {
+	case "email", "webhook", "none":
+	default:
+		return errors.New("channel must be email, webhook or none")
+	}
+	switch p.Cadence {
+	case "instant", "daily", "weekly":
+	default:
+		return errors.New("cadence must be instant, daily or weekly")
+	}
+	// webhook endpoints are garage-wide, they wouldn't reach the user
+	if p.Event == EventSecurity && (p.Channel != "email" || p.Cadence != "instant") {
+		return errors.New("security notifications always go out by email right away")
+	}
+	return nil
+}
+
+// NotificationPreferences returns the user's preference for every event,
+// defaults included.
+func NotificationPreferences(ctx context.Context, q queryer, userID int64) ([]NotificationPreference, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT event, channel, cadence FROM notification_preferences WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	set := map[string]NotificationPreference{}
+	for rows.Next() {
+		var p NotificationPreference
+		if err := rows.Scan(&p.Event, &p.Channel, &p.Cadence); err != nil {
+			return nil, err
+		}
+		set[p.Event] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	prefs := make([]NotificationPreference, 0, len(NotificationEvents))
+	for _, event := range NotificationEvents {
+		p, ok := set[event]
+		if !ok {
+			p = DefaultNotificationPreference(event)
+		}
+		prefs = append(prefs, p)
+	}
+	return prefs, nil
+}
+
+// SetNotificationPreference stores a validated preference.
+func SetNotificationPreference(ex Execer, userID int64, p NotificationPreference) error {
+	_, err := ex.Exec(`
+		INSERT INTO notification_preferences (user_id, event, channel, cadence)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, event) DO UPDATE
+		SET channel = EXCLUDED.channel, cadence = EXCLUDED.cadence, updated_at = NOW()
+	`, userID, p.Event, p.Channel, p.Cadence)
+	return err
+}
+
+func notificationPreference(q ExecQueryer, userID int64, event string) (NotificationPreference, error) {
+	p := DefaultNotificationPreference(event)
+	err := q.QueryRow(`
+		SELECT channel, cadence FROM notification_preferences WHERE user_id = $1 AND event = $2
+	`, userID, event).Scan(&p.Channel, &p.Cadence)
+	if err != nil && err != sql.ErrNoRows {
+		return p, err
+	}
+	return p, nil
+}
+
+// Notify records a notification for userID about event; payload is what the
+// mail or webhook for it needs (see queueNotificationEmail). ex is the
+// transaction that found the reason for it. A pending notification with the
+// same key (e.g. "loan:12") is replaced, so a digest mentions everything
+// once; an empty key never replaces. Nothing is recorded for events the user
+// turned off.
+func Notify(ex ExecQueryer, userID int64, event, key string, payload any) error {
+	if !IsNotificationEvent(event) {
+		return fmt.Errorf("unknown notification event %q", event)
+	}
+	pref, err := notificationPreference(ex, userID, event)
+	if err != nil {
+		return err
+	}
+	if pref.Channel == "none" {
+		return nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := ex.Exec(`
+		INSERT INTO notifications (user_id, event, dedupe_key, payload) VALUES ($1, $2, NULLIF($3, ''), $4)
+		ON CONFLICT (user_id, event, dedupe_key) WHERE delivered_at IS NULL
+		DO UPDATE SET payload = EXCLUDED.payload
+	`, userID, event, key, string(data)); err != nil {
+		return err
+	}
+
+	if pref.Cadence == "instant" {
+		return Enqueue(ex, "notifications.deliver", notificationBatch{UserID: userID, Event: event})
+	}
+	return nil
+}
+
+// notificationBatch is the payload of a notifications.deliver job.
+type notificationBatch struct {
+	UserID int64  `json:"user_id"`
+	Event  string `json:"event"`
+}
+
+// notificationDigest is the data of a notification.digest webhook.
+type notificationDigest struct {
+	UserID        int64             `json:"user_id"`
+	Event         string            `json:"event"`
+	Notifications []json.RawMessage `json:"notifications"`
+}
+
+// DeliverNotifications sends the user's pending notifications for event as
+// one mail or webhook, whichever their preference says now. Email only goes
+// to verified addresses; otherwise the notifications are dropped.
+func DeliverNotifications(ctx context.Context, db *sql.DB, userID int64, event string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, payload::text
+		FROM notifications
+		WHERE user_id = $1 AND event = $2 AND delivered_at IS NULL
+		ORDER BY id
+		FOR UPDATE SKIP LOCKED
+	`, userID, event)
+	if err != nil {
+		return fmt.Errorf("load notifications: %w", err)
+	}
+	var ids []int64
+	var payloads []json.RawMessage
+	for rows.Next() {
+		var id int64
+		var payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan notification: %w", err)
+		}
+		ids = append(ids, id)
+		payloads = append(payloads, json.RawMessage(payload))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load notifications: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	pref, err := notificationPreference(tx, userID, event)
+	if err != nil {
+		return fmt.Errorf("load preference: %w", err)
+	}
+	channel := pref.Channel
+	switch channel {
+	case "email":
+		var to mail.Recipient
+		var verified bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT email, locale, email_verified FROM users WHERE id = $1
+		`, userID).Scan(&to.Email, &to.Locale, &verified)
+		if err != nil {
+			return fmt.Errorf("load user: %w", err)
+		}
+		if !verified {
+			channel = "none"
+			break
+		}
+		if err := queueNotificationEmail(tx, to, event, payloads); err != nil {
+			return fmt.Errorf("queue %s mail: %w", event, err)
+		}
+	case "webhook":
+		digest := notificationDigest{UserID: userID, Event: event, Notifications: payloads}
+		if err := webhooks.Enqueue(tx, "notification.digest", digest); err != nil {
+			return fmt.Errorf("queue %s webhook: %w", event, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE notifications SET delivered_at = NOW(), channel = $2 WHERE id = ANY($1)
+	`, ids, channel); err != nil {
+		return fmt.Errorf("mark notifications delivered: %w", err)
+	}
+	return tx.Commit()
+}
+
+// QueueNotificationDigests queues delivery for every user and event whose
+// digest is due: daily ones every day, weekly ones on weeklyDigestDay.
+// Instant notifications still pending (their delivery died) come along.
+func QueueNotificationDigests(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT n.user_id, n.event, p.cadence
+		FROM notifications n
+		LEFT JOIN notification_preferences p ON p.user_id = n.user_id AND p.event = n.event
+		WHERE n.delivered_at IS NULL
+	`)
+	if err != nil {
+		return fmt.Errorf("load pending notifications: %w", err)
+	}
+	weekly := time.Now().UTC().Weekday() == weeklyDigestDay
+	var due []notificationBatch
+	for rows.Next() {
+		var b notificationBatch
+		var cadence *string
+		if err := rows.Scan(&b.UserID, &b.Event, &cadence); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan pending notification: %w", err)
+		}
+		c := DefaultNotificationPreference(b.Event).Cadence
+		if cadence != nil {
+			c = *cadence
+		}
+		if c != "weekly" || weekly {
+			due = append(due, b)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load pending notifications: %w", err)
+	}
+
+	for _, b := range due {
+		if err := Enqueue(tx, "notifications.deliver", b); err != nil {
+			return fmt.Errorf("queue digest: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// PruneNotifications drops notifications delivered more than
+// notificationRetentionDays ago.
+func PruneNotifications(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		DELETE FROM notifications
+		WHERE delivered_at < NOW() - make_interval(days => $1)
+	`, notificationRetentionDays)
+	return err
+}
+
+func registerNotificationJobs(r *Runner, db *sql.DB, digestHour int) {
+	r.Handle("notifications.deliver", func(ctx context.Context, job Job) error {
+		var b notificationBatch
+		if err := json.Unmarshal(job.Payload, &b); err != nil {
+			return err
+		}
+		return DeliverNotifications(ctx, db, b.UserID, b.Event)
+	})
+	r.Handle("notifications.digest", func(ctx context.Context, _ Job) error {
+		return QueueNotificationDigests(ctx, db)
+	})
+	r.Handle("notifications.prune", func(ctx context.Context, _ Job) error {
+		return PruneNotifications(ctx, db)
+	})
+	r.Daily("notifications.digest", digestHour)
+	r.Daily("notifications.prune", 3)
+}
@@ -1,3 +0,0 @@
-package services
-
-// aici vine logica serviciilor etc
\ No newline at end of file
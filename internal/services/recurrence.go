@@ -0,0 +1,317 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RRule is the subset of RFC 5545 recurrence rules we need for maintenance
+// plans: FREQ (DAILY/WEEKLY/MONTHLY/YEARLY), INTERVAL, COUNT, UNTIL, BYMONTH,
+// BYMONTHDAY (negative = from the end of the month) and BYDAY without
+// ordinal prefixes. Example: "FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=1".
+type RRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      *time.Time
+	ByMonth    []int
+	ByMonthDay []int
+	ByDay      []time.Weekday
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// ParseRRule parses an RRULE value, with or without the "RRULE:" prefix.
+func ParseRRule(s string) (*RRule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, errors.New("empty rrule")
+	}
+
+	r := &RRule{Interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed rrule part %q", part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			switch v := strings.ToUpper(value); v {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.Freq = v
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid COUNT %q", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseRRuleTime(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UNTIL %q", value)
+			}
+			r.Until = &t
+		case "BYMONTH":
+			nums, err := parseRRuleInts(value, 1, 12, false)
+			if err != nil {
+				return nil, fmt.Errorf("invalid BYMONTH: %w", err)
+			}
+			r.ByMonth = nums
+		case "BYMONTHDAY":
+			nums, err := parseRRuleInts(value, 1, 31, true)
+			if err != nil {
+				return nil, fmt.Errorf("invalid BYMONTHDAY: %w", err)
+			}
+			r.ByMonthDay = nums
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				wd, ok := rruleWeekdays[strings.ToUpper(d)]
+				if !ok {
+					return nil, fmt.Errorf("unsupported BYDAY %q", d)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "WKST":
+			// weeks always start on Monday here
+		default:
+			return nil, fmt.Errorf("unsupported rrule part %q", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, errors.New("rrule needs FREQ")
+	}
+	if r.Count > 0 && r.Until != nil {
+		return nil, errors.New("rrule can't have both COUNT and UNTIL")
+	}
+	return r, nil
+}
+
+// rruleHorizonYears bounds the search so a rule that never matches
+// (e.g. BYMONTHDAY=31;BYMONTH=2) can't spin forever. It is measured from
+// `after`, so a rule without COUNT or UNTIL never runs out.
+const rruleHorizonYears = 100
+
+// Next returns the first occurrence strictly after `after`, where dtstart is
+// the first occurrence's anchor. ok is false once the rule is exhausted.
+func (r *RRule) Next(dtstart, after time.Time) (next time.Time, ok bool) {
+	limit := after
+	if dtstart.After(limit) {
+		limit = dtstart
+	}
+	limit = limit.AddDate(rruleHorizonYears, 0, 0)
+
+	seen := 0
+	for period := 0; !r.periodStart(dtstart, period).After(limit); period++ {
+		for _, occ := range r.expand(dtstart, period) {
+			if occ.Before(dtstart) {
+				continue
+			}
+			if r.Until != nil && occ.After(*r.Until) {
+				return time.Time{}, false
+			}
+			seen++
+			if r.Count > 0 && seen > r.Count {
+				return time.Time{}, false
+			}
+			if occ.After(after) {
+				return occ, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// periodStart is where the n-th period after dtstart begins; every
+// occurrence expand lists for it is at or after this.
+func (r *RRule) periodStart(dtstart time.Time, n int) time.Time {
+	step := n * r.Interval
+	y, mon, d := dtstart.Date()
+	loc := dtstart.Location()
+	switch r.Freq {
+	case "WEEKLY":
+		offset := (int(dtstart.Weekday()) + 6) % 7
+		return time.Date(y, mon, d-offset+7*step, 0, 0, 0, 0, loc)
+	case "MONTHLY":
+		return time.Date(y, mon+time.Month(step), 1, 0, 0, 0, 0, loc)
+	case "YEARLY":
+		return time.Date(y+step, time.January, 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(y, mon, d+step, 0, 0, 0, 0, loc)
+}
+
+// expand lists the occurrences inside the n-th period after dtstart, sorted.
+func (r *RRule) expand(dtstart time.Time, n int) []time.Time {
+	step := n * r.Interval
+	h, m, s := dtstart.Clock()
+	loc := dtstart.Location()
+	at := func(y int, mon time.Month, d int) (time.Time, bool) {
+		t := time.Date(y, mon, d, h, m, s, 0, loc)
+		// time.Date normalises Feb 30 into March; treat that as "no such day"
+		return t, t.Month() == mon && t.Day() == d
+	}
+
+	var out []time.Time
+	switch r.Freq {
+	case "DAILY":
+		d := dtstart.AddDate(0, 0, step)
+		if r.matchMonth(d) && r.matchMonthDay(d) && r.matchDay(d) {
+			out = append(out, d)
+		}
+
+	case "WEEKLY":
+		// Monday of dtstart's week, moved forward by whole weeks
+		offset := (int(dtstart.Weekday()) + 6) % 7
+		monday := dtstart.AddDate(0, 0, -offset+7*step)
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{dtstart.Weekday()}
+		}
+		for _, wd := range days {
+			d := monday.AddDate(0, 0, (int(wd)+6)%7)
+			if r.matchMonth(d) {
+				out = append(out, d)
+			}
+		}
+
+	case "MONTHLY":
+		first := time.Date(dtstart.Year(), dtstart.Month(), 1, h, m, s, 0, loc).AddDate(0, step, 0)
+		if r.matchMonth(first) {
+			out = append(out, r.daysInMonth(first, dtstart, at)...)
+		}
+
+	case "YEARLY":
+		year := dtstart.Year() + step
+		months := r.ByMonth
+		if len(months) == 0 {
+			if len(r.ByDay) > 0 && len(r.ByMonthDay) == 0 {
+				months = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+			} else {
+				months = []int{int(dtstart.Month())}
+			}
+		}
+		for _, mon := range months {
+			first := time.Date(year, time.Month(mon), 1, h, m, s, 0, loc)
+			out = append(out, r.daysInMonth(first, dtstart, at)...)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return out
+}
+
+// daysInMonth expands BYMONTHDAY / BYDAY inside the month starting at first,
+// falling back to dtstart's day of month.
+func (r *RRule) daysInMonth(first, dtstart time.Time, at func(int, time.Month, int) (time.Time, bool)) []time.Time {
+	y, mon := first.Year(), first.Month()
+	last := first.AddDate(0, 1, -1).Day()
+
+	var out []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, md := range r.ByMonthDay {
+			if md < 0 {
+				md = last + 1 + md
+			}
+			if t, ok := at(y, mon, md); ok && r.matchDay(t) {
+				out = append(out, t)
+			}
+		}
+	case len(r.ByDay) > 0:
+		for d := 1; d <= last; d++ {
+			if t, ok := at(y, mon, d); ok && r.matchDay(t) {
+				out = append(out, t)
+			}
+		}
+	default:
+		if t, ok := at(y, mon, dtstart.Day()); ok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func (r *RRule) matchMonth(t time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if int(t.Month()) == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RRule) matchMonthDay(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	for _, md := range r.ByMonthDay {
+		if md < 0 {
+			md = last + 1 + md
+		}
+		if t.Day() == md {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RRule) matchDay(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if t.Weekday() == wd {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRRuleTime(v string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("bad time")
+}
+
+func parseRRuleInts(v string, min, max int, allowNegative bool) ([]int, error) {
+	var out []int
+	for _, p := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", p)
+		}
+		abs := n
+		if abs < 0 && allowNegative {
+			abs = -abs
+		}
+		if abs < min || abs > max {
+			return nil, fmt.Errorf("%d out of range", n)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 9, 0, 0, 0, time.UTC)
+}
+
+func TestParseRRule(t *testing.T) {
+	r, err := ParseRRule("RRULE:FREQ=monthly;INTERVAL=2;BYMONTHDAY=1,-1;BYDAY=MO,fr;WKST=MO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Freq != "MONTHLY" || r.Interval != 2 {
+		t.Errorf("got FREQ=%s INTERVAL=%d", r.Freq, r.Interval)
+	}
+	if len(r.ByMonthDay) != 2 || r.ByMonthDay[0] != 1 || r.ByMonthDay[1] != -1 {
+		t.Errorf("BYMONTHDAY = %v", r.ByMonthDay)
+	}
+	if len(r.ByDay) != 2 || r.ByDay[0] != time.Monday || r.ByDay[1] != time.Friday {
+		t.Errorf("BYDAY = %v", r.ByDay)
+	}
+
+	r, err = ParseRRule("FREQ=DAILY;UNTIL=20250301")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Interval != 1 || r.Until == nil || !r.Until.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got INTERVAL=%d UNTIL=%v", r.Interval, r.Until)
+	}
+}
+
+func TestParseRRuleErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"RRULE:",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;INTERVAL=x",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=DAILY;COUNT=3;UNTIL=20250101",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ=YEARLY;BYMONTH=13",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;BYMONTHDAY=-32",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=DAILY;INTERVAL",
+	} {
+		if _, err := ParseRRule(s); err == nil {
+			t.Errorf("ParseRRule(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestRRuleNext(t *testing.T) {
+	tests := []struct {
+		rule    string
+		dtstart time.Time
+		after   time.Time
+		want    []time.Time // the next occurrences, in order
+	}{
+		{
+			rule:    "FREQ=DAILY;INTERVAL=3",
+			dtstart: date(2025, 1, 30),
+			after:   date(2025, 1, 30),
+			want:    []time.Time{date(2025, 2, 2), date(2025, 2, 5)},
+		},
+		{
+			// dtstart itself is the first occurrence
+			rule:    "FREQ=DAILY",
+			dtstart: date(2025, 1, 1),
+			after:   date(2024, 12, 1),
+			want:    []time.Time{date(2025, 1, 1), date(2025, 1, 2)},
+		},
+		{
+			rule:    "FREQ=WEEKLY;BYDAY=MO,TH",
+			dtstart: date(2025, 1, 1), // a Wednesday
+			after:   date(2025, 1, 1),
+			want:    []time.Time{date(2025, 1, 2), date(2025, 1, 6), date(2025, 1, 9)},
+		},
+		{
+			rule:    "FREQ=WEEKLY;INTERVAL=2",
+			dtstart: date(2025, 1, 1),
+			after:   date(2025, 1, 1),
+			want:    []time.Time{date(2025, 1, 15), date(2025, 1, 29)},
+		},
+		{
+			// months without a 31st are skipped, not moved
+			rule:    "FREQ=MONTHLY",
+			dtstart: date(2025, 1, 31),
+			after:   date(2025, 1, 31),
+			want:    []time.Time{date(2025, 3, 31), date(2025, 5, 31), date(2025, 7, 31)},
+		},
+		{
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=-1",
+			dtstart: date(2024, 1, 15),
+			after:   date(2024, 1, 15),
+			want:    []time.Time{date(2024, 1, 31), date(2024, 2, 29), date(2024, 3, 31)},
+		},
+		{
+			rule:    "FREQ=MONTHLY;BYDAY=SA",
+			dtstart: date(2025, 2, 20),
+			after:   date(2025, 2, 20),
+			want:    []time.Time{date(2025, 2, 22), date(2025, 3, 1), date(2025, 3, 8)},
+		},
+		{
+			rule:    "FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=1",
+			dtstart: date(2025, 6, 1),
+			after:   date(2025, 6, 1),
+			want:    []time.Time{date(2026, 3, 1), date(2027, 3, 1)},
+		},
+		{
+			rule:    "FREQ=YEARLY;BYMONTH=4,10",
+			dtstart: date(2025, 1, 15),
+			after:   date(2025, 1, 15),
+			want:    []time.Time{date(2025, 4, 15), date(2025, 10, 15), date(2026, 4, 15)},
+		},
+		{
+			// only leap years have a Feb 29
+			rule:    "FREQ=YEARLY",
+			dtstart: date(2024, 2, 29),
+			after:   date(2024, 2, 29),
+			want:    []time.Time{date(2028, 2, 29)},
+		},
+		{
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: date(2025, 1, 1),
+			after:   date(2024, 12, 31),
+			want:    []time.Time{date(2025, 1, 1), date(2025, 1, 2), date(2025, 1, 3), {}},
+		},
+		{
+			rule:    "FREQ=WEEKLY;UNTIL=20250115T090000Z",
+			dtstart: date(2025, 1, 1),
+			after:   date(2025, 1, 1),
+			want:    []time.Time{date(2025, 1, 8), date(2025, 1, 15), {}},
+		},
+		{
+			// no COUNT or UNTIL: never runs out, however far from dtstart
+			rule:    "FREQ=DAILY",
+			dtstart: date(2025, 1, 1),
+			after:   date(2060, 6, 1),
+			want:    []time.Time{date(2060, 6, 2), date(2060, 6, 3)},
+		},
+		{
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=-1",
+			dtstart: date(2000, 1, 1),
+			after:   date(2100, 2, 1),
+			want:    []time.Time{date(2100, 2, 28), date(2100, 3, 31)},
+		},
+		{
+			// never matches: gives up instead of looping
+			rule:    "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30",
+			dtstart: date(2025, 1, 1),
+			after:   date(2025, 1, 1),
+			want:    []time.Time{{}},
+		},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseRRule(tt.rule)
+		if err != nil {
+			t.Fatalf("ParseRRule(%q): %v", tt.rule, err)
+		}
+		after := tt.after
+		for i, want := range tt.want {
+			got, ok := r.Next(tt.dtstart, after)
+			if want.IsZero() {
+				if ok {
+					t.Errorf("%s: occurrence %d = %v, want none", tt.rule, i, got)
+				}
+				break
+			}
+			if !ok || !got.Equal(want) {
+				t.Errorf("%s: occurrence %d = %v (ok=%v), want %v", tt.rule, i, got, ok, want)
+				break
+			}
+			after = got
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runDaily calls fn every day at hour:00 UTC until ctx is cancelled.
+// Errors are logged and the job simply waits for its next slot.
+func runDaily(ctx context.Context, name string, hour int, fn func(context.Context) error) {
+	for {
+		next := nextDailyRun(time.Now().UTC(), hour)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		if err := fn(ctx); err != nil {
+			log.Printf("job %s failed: %v", name, err)
+		}
+	}
+}
+
+func nextDailyRun(now time.Time, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next
+}
+
+// runEvery calls fn every interval until ctx is cancelled.
+func runEvery(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := fn(ctx); err != nil {
+			log.Printf("job %s failed: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+// aici vine logica serviciilor: background jobs that run next to the API
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/BlaccStacc/blaccend/internal/audit"
+	"github.com/BlaccStacc/blaccend/internal/changelog"
+	"github.com/BlaccStacc/blaccend/internal/config"
+	"github.com/BlaccStacc/blaccend/internal/mail"
+	"github.com/BlaccStacc/blaccend/internal/webhooks"
+)
+
+// notifyInterval is how often the garage is checked for things to notify
+// users about.
+const notifyInterval = 15 * time.Minute
+
+// Start launches the background jobs. They stop when ctx is cancelled.
+// register adds handlers for job kinds other packages queue.
+func Start(ctx context.Context, db *sql.DB, cfg *config.Config, register ...func(*Runner)) {
+	// durable jobs: mail, notifications and the checks that raise them
+	jobs := NewRunner(db)
+	for _, fn := range register {
+		fn(jobs)
+	}
+	mailer := mail.NewMailer(cfg)
+	if signer, err := mail.LoadDKIMSigner(cfg); err != nil {
+		log.Printf("dkim signing disabled: %v", err)
+	} else {
+		mailer.DKIM = signer
+	}
+	registerEmailJobs(jobs, mailer)
+	registerNotificationJobs(jobs, db, cfg.DigestHour)
+	jobs.Handle("notify.low_stock", func(ctx context.Context, _ Job) error {
+		return NotifyLowStock(ctx, db)
+	})
+	jobs.Handle("notify.overdue_loans", func(ctx context.Context, _ Job) error {
+		return NotifyOverdueLoans(ctx, db)
+	})
+	jobs.Handle("notify.maintenance", func(ctx context.Context, _ Job) error {
+		return NotifyMaintenanceDue(ctx, db)
+	})
+	jobs.Handle("notify.warranties", func(ctx context.Context, _ Job) error {
+		return NotifyExpiringWarranties(ctx, db)
+	})
+	// often enough for instant notifications; daily ones wait for the digest
+	jobs.Every("notify.low_stock", notifyInterval)
+	jobs.Every("notify.overdue_loans", notifyInterval)
+	jobs.Every("notify.maintenance", notifyInterval)
+	jobs.Every("notify.warranties", notifyInterval)
+	go jobs.Run(ctx, cfg.JobWorkers)
+
+	go runDaily(ctx, "trash purge", cfg.DigestHour, func(ctx context.Context) error {
+		return PurgeTrash(ctx, db, cfg.TrashRetentionDays)
+	})
+	go runDaily(ctx, "change log prune", cfg.DigestHour, func(ctx context.Context) error {
+		if _, err := changelog.Prune(ctx, db, cfg.ChangeLogRetentionDays); err != nil {
+			return err
+		}
+		return PruneSyncMutations(ctx, db, cfg.ChangeLogRetentionDays)
+	})
+
+	// webhooks: the delivery worker drains the queue, overdue loans are
+	// queued as soon as they pass their due date rather than once a day
+	go runEvery(ctx, "webhook deliveries", 10*time.Second, func(ctx context.Context) error {
+		return webhooks.Deliver(ctx, db)
+	})
+	go runEvery(ctx, "overdue loan events", 15*time.Minute, func(ctx context.Context) error {
+		return QueueOverdueLoanEvents(ctx, db)
+	})
+
+	// audit entries are chained shortly after they commit and the head of the
+	// chain is signed periodically
+	go runEvery(ctx, "audit seal", time.Minute, func(ctx context.Context) error {
+		_, err := audit.Seal(ctx, db)
+		return err
+	})
+	if key, err := audit.LoadSigningKey(cfg); err != nil {
+		log.Printf("audit checkpoints disabled: %v", err)
+	} else if cfg.AuditCheckpointMinutes > 0 {
+		go runEvery(ctx, "audit checkpoint", time.Duration(cfg.AuditCheckpointMinutes)*time.Minute, func(ctx context.Context) error {
+			return audit.WriteCheckpoint(ctx, db, key)
+		})
+	}
+}
+
+// queryer is satisfied by *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// ownerIDs returns the users with the owner role.
+func ownerIDs(ctx context.Context, q queryer) ([]int64, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id FROM users WHERE role = 'owner' ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
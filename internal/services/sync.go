@@ -0,0 +1,21 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// PruneSyncMutations forgets applied sync mutations older than
+// retentionDays. A client offline that long has to start over from a full
+// sync anyway.
+func PruneSyncMutations(ctx context.Context, db *sql.DB, retentionDays int) error {
+	_, err := db.ExecContext(ctx, `
+		DELETE FROM sync_mutations
+		WHERE created_at < NOW() - make_interval(days => $1)
+	`, retentionDays)
+	if err != nil {
+		return fmt.Errorf("prune sync mutations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// PurgeTrash permanently deletes items and spaces that have sat in the trash
+// for longer than retentionDays. Their movements, loans, attachments... go
+// with them through ON DELETE CASCADE.
+func PurgeTrash(ctx context.Context, db *sql.DB, retentionDays int) error {
+	res, err := db.ExecContext(ctx, `
+		DELETE FROM garage_items
+		WHERE deleted_at < NOW() - make_interval(days => $1)
+	`, retentionDays)
+	if err != nil {
+		return fmt.Errorf("purge items: %w", err)
+	}
+	items, _ := res.RowsAffected()
+
+	res, err = db.ExecContext(ctx, `
+		DELETE FROM garage_spaces
+		WHERE deleted_at < NOW() - make_interval(days => $1)
+	`, retentionDays)
+	if err != nil {
+		return fmt.Errorf("purge spaces: %w", err)
+	}
+	spaces, _ := res.RowsAffected()
+
+	if items > 0 || spaces > 0 {
+		log.Printf("trash purge: removed %d item(s) and %d space(s) older than %d days", items, spaces, retentionDays)
+	}
+	return nil
+}
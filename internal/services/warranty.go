@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/BlaccStacc/blaccend/internal/mail"
+)
+
+// warrantyReminderDays is how long before expiry the owners hear about it.
+const warrantyReminderDays = 30
+
+// NotifyExpiringWarranties notifies the owners, once per warranty date, of
+// items whose warranty runs out within warrantyReminderDays.
+func NotifyExpiringWarranties(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, name, serial_number, vendor, warranty_expires
+		FROM garage_items
+		WHERE warranty_expires BETWEEN CURRENT_DATE AND CURRENT_DATE + $1::int
+		  AND warranty_reminded_at IS NULL
+		  AND deleted_at IS NULL
+		ORDER BY warranty_expires, id
+	`, warrantyReminderDays)
+	if err != nil {
+		return fmt.Errorf("load expiring warranties: %w", err)
+	}
+
+	var ids []int64
+	var items []mail.WarrantyExpiry
+	for rows.Next() {
+		var id int64
+		var w mail.WarrantyExpiry
+		if err := rows.Scan(&id, &w.ItemName, &w.SerialNumber, &w.Vendor, &w.Expires); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan warranty: %w", err)
+		}
+		ids = append(ids, id)
+		items = append(items, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load expiring warranties: %w", err)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	owners, err := ownerIDs(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("load owners: %w", err)
+	}
+	// keep them for the next run if there is nobody to tell
+	if len(owners) == 0 {
+		return nil
+	}
+
+	for _, owner := range owners {
+		for i, w := range items {
+			if err := Notify(tx, owner, EventWarranty, fmt.Sprintf("item:%d", ids[i]), w); err != nil {
+				return fmt.Errorf("notify warranty: %w", err)
+			}
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE garage_items SET warranty_reminded_at = NOW() WHERE id = ANY($1)`, ids); err != nil {
+		return fmt.Errorf("mark items reminded: %w", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,210 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	maxAttempts  = 10
+	firstRetry   = 30 * time.Second
+	maxRetry     = 6 * time.Hour
+	deliverBatch = 20
+
+	// a claimed delivery is retried after this if the worker dies mid-send
+	claimLease = 5 * time.Minute
+)
+
+// client doesn't follow redirects (a 3xx is a failed delivery) and won't
+// connect to link-local or cloud metadata addresses, so an endpoint URL
+// can't be used to read the server's instance credentials. Private and
+// loopback addresses stay allowed: receivers often run on the same network.
+// No proxy either, or the check would only ever see the proxy's address.
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: refuseInternalAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        20,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// metadataAddresses are cloud metadata services outside link-local space.
+var metadataAddresses = map[netip.Addr]bool{
+	netip.MustParseAddr("fd00:ec2::254"):   true, // AWS, IPv6
+	netip.MustParseAddr("100.100.100.200"): true, // Alibaba Cloud
+}
+
+// refuseInternalAddress is a net.Dialer Control: it runs after DNS
+// resolution, so a hostname can't sneak past it.
+func refuseInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || metadataAddresses[ip] {
+		return fmt.Errorf("refusing to deliver to %s", ip)
+	}
+	return nil
+}
+
+// backoff is the wait before the next try after attempts failed ones.
+func backoff(attempts int) time.Duration {
+	d := firstRetry
+	for i := 1; i < attempts && d < maxRetry; i++ {
+		d *= 2
+	}
+	if d > maxRetry {
+		d = maxRetry
+	}
+	return d
+}
+
+type claimed struct {
+	id       int64
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+// Deliver sends every due delivery. Several workers can run at once: rows
+// are claimed with SKIP LOCKED and leased while the request is in flight.
+func Deliver(ctx context.Context, db *sql.DB) error {
+	for {
+		batch, err := claimDue(ctx, db)
+		if err != nil {
+			return fmt.Errorf("claim deliveries: %w", err)
+		}
+		for _, d := range batch {
+			code, sendErr := send(ctx, d)
+			if err := finish(ctx, db, d, code, sendErr); err != nil {
+				return fmt.Errorf("update delivery %d: %w", d.id, err)
+			}
+		}
+		if len(batch) < deliverBatch {
+			return nil
+		}
+	}
+}
+
+func claimDue(ctx context.Context, db *sql.DB) ([]claimed, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1,
+		    next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhook_endpoints e
+		WHERE e.id = d.endpoint_id
+		  AND d.id IN (
+		    SELECT dd.id
+		    FROM webhook_deliveries dd
+		    JOIN webhook_endpoints ee ON ee.id = dd.endpoint_id AND ee.active
+		    WHERE dd.status = 'pending' AND dd.next_attempt_at <= NOW()
+		    ORDER BY dd.next_attempt_at, dd.id
+		    LIMIT $1
+		    FOR UPDATE OF dd SKIP LOCKED
+		  )
+		RETURNING d.id, d.event, d.payload::text, d.attempts, e.url, e.secret
+	`, deliverBatch, int(claimLease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []claimed
+	for rows.Next() {
+		var d claimed
+		var payload string
+		if err := rows.Scan(&d.id, &d.event, &payload, &d.attempts, &d.url, &d.secret); err != nil {
+			return nil, err
+		}
+		d.payload = []byte(payload)
+		batch = append(batch, d)
+	}
+	return batch, rows.Err()
+}
+
+// send posts one delivery and returns the response status (0 when there was none).
+func send(ctx context.Context, d claimed) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(d.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "blaccend-webhooks")
+	req.Header.Set("X-Blaccend-Event", d.event)
+	req.Header.Set("X-Blaccend-Delivery", strconv.FormatInt(d.id, 10))
+	req.Header.Set("X-Blaccend-Signature", Signature(d.secret, time.Now(), d.payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// the body is never stored: owners can read last_error, and it could be
+	// anything the receiver (or whatever the URL really points at) returns.
+	// Reading a little lets the connection be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func finish(ctx context.Context, db *sql.DB, d claimed, code int, sendErr error) error {
+	var status *int
+	if code != 0 {
+		status = &code
+	}
+
+	if sendErr == nil {
+		_, err := db.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'delivered', delivered_at = NOW(), last_status_code = $2, last_error = NULL
+			WHERE id = $1
+		`, d.id, status)
+		return err
+	}
+
+	if d.attempts >= maxAttempts {
+		_, err := db.ExecContext(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'failed', last_status_code = $2, last_error = $3
+			WHERE id = $1
+		`, d.id, status, sendErr.Error())
+		return err
+	}
+
+	_, err := db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET last_status_code = $2, last_error = $3,
+		    next_attempt_at = NOW() + make_interval(secs => $4)
+		WHERE id = $1
+	`, d.id, status, sendErr.Error(), int(backoff(d.attempts).Seconds()))
+	return err
+}
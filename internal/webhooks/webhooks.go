@@ -0,0 +1,81 @@
+package webhooks
+
+// outbound webhooks: owners register endpoints, events are queued in
+// webhook_deliveries inside the transaction that caused them and a
+// background worker (Deliver) posts them with retries
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Events lists everything an endpoint can subscribe to.
+var Events = []string{
+	"item.created",
+	"item.updated",
+	"item.deleted",
+	"stock.low",
+	"loan.overdue",
+	"user.registered",
+	"notification.digest", // notifications of users who chose the webhook channel
+}
+
+// IsEvent reports whether name is one of Events.
+func IsEvent(name string) bool {
+	for _, e := range Events {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Execer is satisfied by *sql.DB and *sql.Tx. Pass the transaction that makes
+// the change so nothing is sent for changes that roll back.
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Envelope is the JSON body every delivery carries.
+type Envelope struct {
+	Event     string `json:"event"`
+	CreatedAt string `json:"created_at"`
+	Data      any    `json:"data"`
+}
+
+// Enqueue queues event for every active endpoint subscribed to it. data is
+// anything that marshals to JSON, usually the record as the API returns it.
+func Enqueue(ex Execer, event string, data any) error {
+	body, err := json.Marshal(Envelope{
+		Event:     event,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ex.Exec(`
+		INSERT INTO webhook_deliveries (endpoint_id, event, payload)
+		SELECT id, $1, $2
+		FROM webhook_endpoints
+		WHERE active AND $1 = ANY(events)
+	`, event, string(body))
+	return err
+}
+
+// Signature is the X-Blaccend-Signature header value for body sent at t:
+// "t=<unix>,v1=<hex HMAC-SHA256 of "<unix>.<body>" keyed with the secret>".
+// Receivers should recompute it and reject old timestamps.
+func Signature(secret string, t time.Time, body []byte) string {
+	ts := fmt.Sprint(t.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}